@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// baselineSuffix is appended to the log name of a target to name its
+// baseline file.
+const baselineSuffix = ".out"
+
+// maxDiffCells bounds the work of diffing two outputs, in lines of one times
+// lines of the other; larger outputs are only reported as different.
+const maxDiffCells = 4_000_000
+
+// baseline compares the stdout of every task with a golden copy kept for its
+// target in a directory, named as by logName, and reports the outputs that
+// changed, are new or were removed. With update, it rewrites the directory
+// with the outputs of the run instead.
+type baseline struct {
+	mu      sync.Mutex
+	dir     string
+	update  bool
+	out     io.Writer
+	same    int
+	changed int
+	added   int
+	removed int
+	written int
+}
+
+// newBaseline reads and writes the baselines in dir, reporting to out.
+func newBaseline(dir string, update bool, out io.Writer) (*baseline, error) {
+	if update {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	} else if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &baseline{dir: dir, update: update, out: out}, nil
+}
+
+// handle is the executor event handler comparing the output of finished
+// tasks with their baseline.
+func (b *baseline) handle(ev executor.Event) {
+	if ev.Type != executor.TaskFinished || ev.Result.Skipped || ev.Result.Cancelled {
+		return
+	}
+	result := ev.Result
+	path := filepath.Join(b.dir, logName(result.Target)+baselineSuffix)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.update {
+		if err := os.WriteFile(path, result.Stdout, 0o644); err != nil {
+			warnf(os.Stderr, "cannot write baseline: %v", err)
+		} else {
+			b.written++
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		b.added++
+		fmt.Fprintf(b.out, "New output: %s\n", result.Target)
+		writeLines(b.out, "+ ", result.Stdout)
+	case err != nil:
+		warnf(os.Stderr, "cannot read baseline: %v", err)
+	case bytes.Equal(want, result.Stdout):
+		b.same++
+	default:
+		b.changed++
+		fmt.Fprintf(b.out, "Changed output: %s\n", result.Target)
+		writeDiff(b.out, want, result.Stdout)
+	}
+}
+
+// close looks for the baselines of targets that are no longer among targets,
+// reporting them as removed or deleting them with update, and prints a
+// summary of the comparison. It returns the number of outputs that differ
+// from their baseline.
+func (b *baseline) close(targets []string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	known := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		known[logName(target)+baselineSuffix] = true
+	}
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		warnf(os.Stderr, "cannot read baselines: %v", err)
+	}
+	var stale []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasSuffix(name, baselineSuffix) && !known[name] {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+
+	if b.update {
+		for _, name := range stale {
+			if err := os.Remove(filepath.Join(b.dir, name)); err != nil {
+				warnf(os.Stderr, "cannot remove baseline: %v", err)
+			}
+		}
+		fmt.Fprintf(b.out, "Baseline: wrote %d outputs, removed %d stale ones\n", b.written, len(stale))
+		return 0
+	}
+
+	for _, name := range stale {
+		// Names are percent-encoded targets, possibly shortened by logName
+		target, err := url.PathUnescape(strings.TrimSuffix(name, baselineSuffix))
+		if err != nil {
+			target = filepath.Join(b.dir, name)
+		}
+		fmt.Fprintf(b.out, "Removed output: %s\n", target)
+	}
+	b.removed = len(stale)
+	fmt.Fprintf(b.out, "Baseline: %d unchanged, %d changed, %d new, %d removed\n", b.same, b.changed, b.added, b.removed)
+	return b.changed + b.added + b.removed
+}
+
+// writeLines writes each line of data to w after prefix.
+func writeLines(w io.Writer, prefix string, data []byte) {
+	for _, line := range splitLines(data) {
+		fmt.Fprintf(w, "%s%s\n", prefix, line)
+	}
+}
+
+// splitLines splits data into lines without their terminators.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// writeDiff writes the lines removed from old with "- " and those added in
+// new with "+ ", in order, leaving out those they share.
+func writeDiff(w io.Writer, old, new []byte) {
+	a, b := splitLines(old), splitLines(new)
+	if len(a)*len(b) > maxDiffCells {
+		fmt.Fprintf(w, "  (%d lines before, %d now; too long to compare line by line)\n", len(a), len(b))
+		return
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j, written := 0, 0, false
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+			continue
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(w, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(w, "+ %s\n", b[j])
+			j++
+		}
+		written = true
+	}
+	if !written {
+		fmt.Fprintln(w, "  (only the final newline differs)")
+	}
+}
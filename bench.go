@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// benchTolerance is how much slower than the fastest run the recommended
+// worker count may be, so that fewer workers are preferred for about the
+// same wall time.
+const benchTolerance = 0.05
+
+// benchRun is the outcome of running every target with one worker count.
+type benchRun struct {
+	workers int
+	wall    time.Duration
+	cpu     time.Duration
+	failed  int
+}
+
+// utilization returns the share of the CPUs of the machine the commands
+// kept busy.
+func (b benchRun) utilization() float64 {
+	if b.wall <= 0 {
+		return 0
+	}
+	return b.cpu.Seconds() / (b.wall.Seconds() * float64(runtime.NumCPU()))
+}
+
+// parseWorkerCounts parses a -bench-workers list such as "1,2,4,8".
+func parseWorkerCounts(s string) ([]int, error) {
+	var counts []int
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid worker count %q", field)
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// runBench runs command for every target once per worker count, with
+// runner set up otherwise as for a normal run. resize, if not nil, is called
+// with each count before its run. It stops early if ctx is cancelled.
+func runBench(ctx context.Context, runner *executor.Executor, targets []string, command string, counts []int, resize func(int)) ([]benchRun, error) {
+	var runs []benchRun
+	for _, workers := range counts {
+		logger.Info("Benchmarking", "workers", workers, "targets", len(targets))
+		runner.Workers = workers
+		if resize != nil {
+			resize(workers)
+		}
+
+		start := time.Now()
+		results, err := runner.Run(ctx, targets, command)
+		if err != nil && results == nil {
+			return runs, err
+		}
+		run := benchRun{workers: workers, wall: time.Since(start), failed: results.Failed()}
+		for _, result := range results {
+			// The targets of a batch share the result of its first
+			if len(result.Batch) == 0 || result.Target == result.Batch[0] {
+				run.cpu += result.CPUTime
+			}
+		}
+		runs = append(runs, run)
+		if ctx.Err() != nil {
+			return runs, ctx.Err()
+		}
+	}
+	return runs, nil
+}
+
+// recommend returns the run with the fewest workers among those within
+// benchTolerance of the fastest one.
+func recommend(runs []benchRun) benchRun {
+	fastest := runs[0]
+	for _, run := range runs[1:] {
+		if run.wall < fastest.wall {
+			fastest = run
+		}
+	}
+	best := fastest
+	for _, run := range runs {
+		if run.workers < best.workers && run.wall.Seconds() <= fastest.wall.Seconds()*(1+benchTolerance) {
+			best = run
+		}
+	}
+	return best
+}
+
+// printBench writes a table of the runs and the recommended worker count.
+func printBench(w io.Writer, runs []benchRun) {
+	if len(runs) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Workers\tWall time\tCPU time\tCPU use\tSpeedup\tFailed")
+	for _, run := range runs {
+		speedup := runs[0].wall.Seconds() / run.wall.Seconds()
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%.0f%%\t%.2fx\t%d\n", run.workers, run.wall.Round(time.Millisecond),
+			run.cpu.Round(time.Millisecond), run.utilization()*100, speedup, run.failed)
+	}
+	tw.Flush()
+
+	best := recommend(runs)
+	fmt.Fprintf(w, "Recommended: -workers %d (the fewest within %.0f%% of the fastest wall time)\n", best.workers, benchTolerance*100)
+}
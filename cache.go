@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// cacheEntry records a target whose command succeeded, in a file of the
+// cache directory named after the target and the command.
+type cacheEntry struct {
+	Target  string    `json:"target"`
+	Command string    `json:"command"`
+	Hash    string    `json:"hash"`
+	Time    time.Time `json:"time"`
+}
+
+// resultCache remembers the contents of the targets after their command
+// succeeded, so that targets left unchanged since can be skipped. The
+// contents are hashed after the command ran, so the changes of commands
+// such as formatters don't count.
+type resultCache struct {
+	dir string
+
+	// commandOf returns the command run for a target, including how {} is
+	// rewritten for it
+	commandOf func(target string) string
+}
+
+// openCache opens the cache in dir, creating the directory if needed.
+func openCache(dir string, commandOf func(target string) string) (*resultCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &resultCache{dir: dir, commandOf: commandOf}, nil
+}
+
+// path returns the file of the cache entry for target.
+func (c *resultCache) path(target string) string {
+	sum := sha256.Sum256([]byte(c.commandOf(target) + "\x00" + absPath(target)))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// unchanged reports whether target has the contents it had when its command
+// last succeeded.
+func (c *resultCache) unchanged(target string) bool {
+	data, err := os.ReadFile(c.path(target))
+	if err != nil {
+		return false
+	}
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil {
+		return false
+	}
+	hash, err := hashTarget(target)
+	return err == nil && hash == entry.Hash
+}
+
+// handle is the executor event handler that records the targets whose
+// command succeeded.
+func (c *resultCache) handle(ev executor.Event) {
+	if ev.Type != executor.TaskFinished || ev.Result.Err != nil {
+		return
+	}
+	hash, err := hashTarget(ev.Target)
+	if err != nil {
+		// Targets that are not files are never cached
+		return
+	}
+
+	entry := cacheEntry{Target: absPath(ev.Target), Command: c.commandOf(ev.Target), Hash: hash, Time: time.Now()}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// Write atomically, as other runs may be reading the cache
+	path := c.path(ev.Target)
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		warnf(os.Stderr, "cannot write cache entry: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		warnf(os.Stderr, "cannot write cache entry: %v", err)
+	}
+}
+
+// hashTarget hashes the contents of the file or directory target. A
+// directory's hash covers the names, types and contents of everything in it
+// but .git directories.
+func hashTarget(target string) (string, error) {
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		sum, err := hashFile(target)
+		return hex.EncodeToString(sum), err
+	}
+
+	h := sha256.New()
+	err = filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" && path != target {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(target, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", filepath.ToSlash(rel), d.Type())
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			io.WriteString(h, link)
+		case d.Type().IsRegular():
+			sum, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			h.Write(sum)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFile returns the SHA-256 of the contents of the file at path.
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
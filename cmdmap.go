@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// commandMap assigns commands to targets by pattern.
+type commandMap struct {
+	patterns []string
+	commands []string
+}
+
+// loadCommandMap reads a file mapping path patterns to commands, in YAML (or
+// TOML for .toml files):
+//
+//	"*.go": gofmt -l {}
+//	"*.py": black {}
+//	"docs/**": markdownlint {}
+//
+// Patterns are tried in order and the first match wins.
+func loadCommandMap(path string) (*commandMap, error) {
+	return loadPatternMap(path, "command")
+}
+
+// loadPatternMap reads a file mapping path patterns to strings, such as the
+// commands of -cmd-map; what names those in errors.
+func loadPatternMap(path, what string) (*commandMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var root any
+	if strings.HasSuffix(path, ".toml") {
+		root, err = parseTOML(data)
+	} else {
+		root, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	doc, ok := root.(*confMap)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a mapping of patterns to %ss", path, what)
+	}
+
+	m := &commandMap{}
+	for _, pattern := range doc.keys {
+		command, ok := doc.values[pattern].(string)
+		if !ok || command == "" {
+			return nil, fmt.Errorf("%s: %s: expected a %s", path, pattern, what)
+		}
+		m.patterns = append(m.patterns, pattern)
+		m.commands = append(m.commands, command)
+	}
+	return m, nil
+}
+
+// command returns the command for target: that of the first pattern
+// matching its trailing path elements, so "*.go" matches Go files at any
+// depth and "/srv/**" only below /srv.
+func (m *commandMap) command(target string) (string, bool) {
+	name := splitPath(target)
+	for i, pattern := range m.patterns {
+		pat := patternSegments(pattern)
+		for j := range name {
+			if matchSegments(pat, name[j:]) {
+				return m.commands[i], true
+			}
+		}
+	}
+	return "", false
+}
+
+// commandsFor returns the command of each target, empty for those matching
+// no pattern.
+func (m *commandMap) commandsFor(targets []string) []string {
+	commands := make([]string, len(targets))
+	for i, target := range targets {
+		commands[i], _ = m.command(target)
+	}
+	return commands
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Values of the -color flag.
+const (
+	colorAuto   = "auto"   // color terminals
+	colorAlways = "always" // color even when piped
+	colorNever  = "never"
+)
+
+// ANSI colors of the parts of the output.
+const (
+	colorSuccess = "32" // green
+	colorFailure = "31" // red
+	colorWarning = "33" // yellow
+)
+
+// colorMode is the value of the -color flag. "true" and "false" are taken
+// for "always" and "never", as when the flag only colored -stream prefixes.
+type colorMode string
+
+func (m *colorMode) String() string { return string(*m) }
+
+func (m *colorMode) Set(value string) error {
+	switch value {
+	case "true":
+		value = colorAlways
+	case "false":
+		value = colorNever
+	case colorAuto, colorAlways, colorNever:
+	default:
+		return fmt.Errorf("expected %s, %s or %s", colorAuto, colorAlways, colorNever)
+	}
+	*m = colorMode(value)
+	return nil
+}
+
+// enabled reports whether output to f is colored. In auto mode that is when
+// f is a terminal, unless NO_COLOR is set (see https://no-color.org) or the
+// terminal is dumb.
+func (m colorMode) enabled(f *os.File) bool {
+	switch m {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	}
+	return isTerminal(f) && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
+
+// paint wraps s in the ANSI color code if on is set.
+func paint(code, s string, on bool) string {
+	if !on {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// warningColor is the -color mode for warnings.
+var warningColor colorMode = colorNever
+
+// warnf prints a warning to f, in yellow if it is colored.
+func warnf(f *os.File, format string, args ...any) {
+	fmt.Fprintln(f, paint(colorWarning, "Warning: "+fmt.Sprintf(format, args...), warningColor.enabled(f)))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// subcommands are the first arguments executor recognizes besides flags.
+var subcommands = []string{"run", "resume", "rerun-failed", "report", "ctl", "bench", "skip", "version", "self-update", "completion"}
+
+// completionShells are the shells "executor completion" writes scripts for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// writeCompletion writes a completion script for shell covering the
+// subcommands and the flags of fs.
+func writeCompletion(w io.Writer, shell string, fs *flag.FlagSet) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, fs)
+	case "zsh":
+		writeZshCompletion(w, fs)
+	case "fish":
+		writeFishCompletion(w, fs)
+	default:
+		return fmt.Errorf("unknown shell %q (expected %s)", shell, strings.Join(completionShells, ", "))
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, fs *flag.FlagSet) {
+	var flags []string
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, "-"+f.Name)
+	})
+
+	fmt.Fprintf(w, `# bash completion for executor
+_executor() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+    if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then
+        COMPREPLY=($(compgen -W %q -- "$cur"))
+    elif [[ $prev == completion ]]; then
+        COMPREPLY=($(compgen -W %q -- "$cur"))
+    elif [[ $cur == -* ]]; then
+        COMPREPLY=($(compgen -W %q -- "$cur"))
+    else
+        COMPREPLY=($(compgen -f -- "$cur"))
+    fi
+}
+complete -o filenames -F _executor executor
+`, strings.Join(subcommands, " "), strings.Join(completionShells, " "), strings.Join(flags, " "))
+}
+
+func writeZshCompletion(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "#compdef executor")
+	fmt.Fprintln(w, "_executor() {")
+	fmt.Fprintln(w, "    _arguments \\")
+	fs.VisitAll(func(f *flag.Flag) {
+		spec := fmt.Sprintf("-%s[%s]", f.Name, zshEscape(flagSummary(f)))
+		if !isBoolFlag(f) {
+			spec += ":" + f.Name + ":_files"
+		}
+		fmt.Fprintf(w, "        %s \\\n", shellQuote(spec))
+	})
+	fmt.Fprintf(w, "        '1:command:(%s)' \\\n", strings.Join(subcommands, " "))
+	fmt.Fprintln(w, "        '*:file:_files'")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `_executor "$@"`)
+}
+
+func writeFishCompletion(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "# fish completion for executor")
+	fmt.Fprintf(w, "complete -c executor -n __fish_use_subcommand -f -a %s\n", shellQuote(strings.Join(subcommands, " ")))
+	fmt.Fprintf(w, "complete -c executor -n '__fish_seen_subcommand_from completion' -f -a %s\n", shellQuote(strings.Join(completionShells, " ")))
+	fs.VisitAll(func(f *flag.Flag) {
+		line := fmt.Sprintf("complete -c executor -o %s -d %s", f.Name, shellQuote(flagSummary(f)))
+		if !isBoolFlag(f) {
+			line += " -r"
+		}
+		fmt.Fprintln(w, line)
+	})
+}
+
+// flagSummary shortens the usage of f to its first sentence or clause, to
+// fit the menus of the shells.
+func flagSummary(f *flag.Flag) string {
+	summary := f.Usage
+	for _, sep := range []string{"; ", " (", ", e.g."} {
+		if i := strings.Index(summary, sep); i > 0 {
+			summary = summary[:i]
+		}
+	}
+	return summary
+}
+
+// isBoolFlag reports whether f takes no value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// zshEscape escapes the characters special in the descriptions of
+// _arguments specs.
+func zshEscape(s string) string {
+	return strings.NewReplacer(`[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+}
+
+// shellQuote quotes s as a single word for sh, zsh and fish alike.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
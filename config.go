@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configNames are the configuration files looked for, in order, in the
+// current directory and then in the user configuration directory
+// (e.g. ~/.config/executor). EXECUTOR_CONFIG overrides the search.
+var configNames = []string{
+	"executor.yaml", "executor.yml", ".executor.yaml", ".executor.yml",
+	"executor.toml", ".executor.toml",
+}
+
+// settingAliases maps profile settings to the flags they set, where the
+// two are named differently.
+var settingAliases = map[string]string{
+	"command": "cmd",
+}
+
+// findConfig returns the path of the configuration file to use.
+func findConfig() (string, error) {
+	if path := os.Getenv("EXECUTOR_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dirs := []string{"."}
+	if configDir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(configDir, "executor"))
+	}
+	for _, dir := range dirs {
+		for _, name := range configNames {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no configuration file found (looked for %s)", strings.Join(configNames, ", "))
+}
+
+// loadProfiles reads the named profiles from the configuration file at path.
+// Profiles live in a "profiles" table, each mapping flag names to values:
+//
+//	profiles:
+//	  update-repos:
+//	    command: git pull --ff-only
+//	    pattern: ~/src/*
+//	    dirs-only: true
+//	    workers: 8
+//	    exclude: [archive, "*.old"]
+func loadProfiles(path string) (*confMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var root any
+	if strings.HasSuffix(path, ".toml") {
+		root, err = parseTOML(data)
+	} else {
+		root, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	doc, ok := root.(*confMap)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a mapping at the top level", path)
+	}
+	profiles, ok := doc.values["profiles"].(*confMap)
+	if !ok {
+		return nil, fmt.Errorf("%s: no profiles defined", path)
+	}
+	return profiles, nil
+}
+
+// applyProfile sets the flags of fs from the profile name found in the
+// configuration file, once the command line has been parsed into fs. Flags
+// given on the command line override the profile, leaving its settings for
+// them out entirely, so that a repeatable flag such as -exclude replaces the
+// values of the profile rather than adding to them.
+func applyProfile(fs *flag.FlagSet, name string) error {
+	path, err := findConfig()
+	if err != nil {
+		return err
+	}
+	profiles, err := loadProfiles(path)
+	if err != nil {
+		return err
+	}
+	profile, ok := profiles.values[name].(*confMap)
+	if !ok {
+		return fmt.Errorf("%s: no profile named %q (available: %s)", path, name, strings.Join(profiles.keys, ", "))
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, key := range profile.keys {
+		flagName := key
+		if alias, ok := settingAliases[key]; ok {
+			flagName = alias
+		}
+		if fs.Lookup(flagName) == nil {
+			return fmt.Errorf("%s: profile %q: unknown setting %q", path, name, key)
+		}
+		if given[flagName] {
+			continue
+		}
+
+		values, err := confStrings(profile.values[key])
+		if err != nil {
+			return fmt.Errorf("%s: profile %q: %s: %v", path, name, key, err)
+		}
+		for _, value := range values {
+			if err := fs.Set(flagName, value); err != nil {
+				return fmt.Errorf("%s: profile %q: %s: %v", path, name, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// listProfiles prints the profiles of the configuration file to stderr.
+func listProfiles() error {
+	path, err := findConfig()
+	if err != nil {
+		return err
+	}
+	profiles, err := loadProfiles(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Profiles in %s:\n", path)
+	for _, name := range profiles.keys {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+	return nil
+}
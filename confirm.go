@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// errQuit is the cancellation cause when the user quits at the prompt.
+var errQuit = errors.New("quit at the confirmation prompt")
+
+// errDeclined is the reason for skipping a target the user said no to.
+var errDeclined = errors.New("declined")
+
+// prompter asks before each command is run, like xargs -p. The answer can be
+// y(es), n(o), a(ll) to run the remaining commands without asking, or q(uit)
+// to stop starting new commands.
+type prompter struct {
+	mu      sync.Mutex
+	in      *bufio.Reader
+	out     io.Writer
+	all     bool
+	quitted bool
+	quit    func()
+}
+
+// newPrompter returns a prompter that reads the answers from the terminal,
+// or from stdin when there is none, and calls quit when the user quits.
+func newPrompter(quit func()) *prompter {
+	var in io.Reader = os.Stdin
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		in = tty
+	}
+	return &prompter{in: bufio.NewReader(in), out: os.Stderr, quit: quit}
+}
+
+// confirm is the executor.Executor.Confirm hook. Workers asking at the same
+// time are prompted one after the other.
+func (p *prompter) confirm(target, command string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for !p.all && !p.quitted {
+		fmt.Fprintf(p.out, "%s: %s ?[y/n/all/quit] ", target, command)
+		line, err := p.in.ReadString('\n')
+		if err != nil && line == "" {
+			// Nobody is left to answer
+			fmt.Fprintln(p.out)
+			p.quitted = true
+			break
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return nil
+		case "n", "no":
+			return errDeclined
+		case "a", "all":
+			p.all = true
+		case "q", "quit":
+			p.quitted = true
+		}
+	}
+
+	if p.quitted {
+		p.quit()
+		return errQuit
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// confMap is a mapping read from a configuration file. It keeps the order of
+// its keys, which matters where entries are tried first to last. Values are
+// strings, []any lists or nested *confMap.
+type confMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func newConfMap() *confMap {
+	return &confMap{values: make(map[string]any)}
+}
+
+// set adds or replaces key.
+func (m *confMap) set(key string, value any) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// child returns the mapping stored under key, creating it if needed. It fails
+// if key already holds something else.
+func (m *confMap) child(key string) (*confMap, error) {
+	switch v := m.values[key].(type) {
+	case nil:
+		c := newConfMap()
+		m.set(key, c)
+		return c, nil
+	case *confMap:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("%q is not a table", key)
+	}
+}
+
+// confStrings converts a scalar or a list of scalars to a slice of strings.
+func confStrings(value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("nested values are not supported in lists")
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("expected a value or a list of values")
+	}
+}
+
+// splitFlow splits the inside of a flow list such as `a, "b, c", 'd'` at
+// top-level commas, leaving quoted commas alone. A trailing comma is allowed.
+func splitFlow(s string) []string {
+	var (
+		items   []string
+		quote   rune
+		escaped bool
+		start   int
+	)
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	items = append(items, strings.TrimSpace(s[start:]))
+	if items[len(items)-1] == "" {
+		items = items[:len(items)-1]
+	}
+	return items
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// dumpConf writes a value read from a configuration file compactly, with
+// mappings in the order of their keys, for tests to compare.
+func dumpConf(value any) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = dumpConf(item)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case *confMap:
+		entries := make([]string, len(v.keys))
+		for i, key := range v.keys {
+			entries[i] = fmt.Sprintf("%q:%s", key, dumpConf(v.values[key]))
+		}
+		return "{" + strings.Join(entries, " ") + "}"
+	default:
+		return fmt.Sprintf("%T(%v)", v, v)
+	}
+}
+
+func TestConfMapChild(t *testing.T) {
+	m := newConfMap()
+	a, err := m.child("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.set("x", "1")
+	if again, err := m.child("a"); err != nil || again != a {
+		t.Errorf("child(a) again = %v, %v; want the same mapping", again, err)
+	}
+	m.set("s", "text")
+	if _, err := m.child("s"); err == nil {
+		t.Error("child(s) of a string succeeded")
+	}
+	m.set("a", "replaced")
+	if want := []string{"a", "s"}; !slices.Equal(m.keys, want) {
+		t.Errorf("keys = %q, want %q", m.keys, want)
+	}
+}
+
+func TestConfStrings(t *testing.T) {
+	tests := []struct {
+		value   any
+		want    []string
+		wantErr bool
+	}{
+		{value: "one", want: []string{"one"}},
+		{value: []any{}, want: []string{}},
+		{value: []any{"a", "b"}, want: []string{"a", "b"}},
+		{value: []any{"a", []any{"b"}}, wantErr: true},
+		{value: newConfMap(), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := confStrings(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("confStrings(%s) = %q, want an error", dumpConf(tt.value), got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("confStrings(%s) = %q, %v; want %q", dumpConf(tt.value), got, err, tt.want)
+		}
+	}
+}
+
+func TestSplitFlow(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a, b ,c", []string{"a", "b", "c"}},
+		{"a, b,", []string{"a", "b"}},
+		{`"a, b", 'c, d', e`, []string{`"a, b"`, "'c, d'", "e"}},
+		{`"say \", hi", x`, []string{`"say \", hi"`, "x"}},
+		{"a,,b", []string{"a", "", "b"}},
+	}
+	for _, tt := range tests {
+		if got := splitFlow(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("splitFlow(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// controlTimeout bounds an exchange over the control socket.
+const controlTimeout = 10 * time.Second
+
+// controlRequest is what executor ctl sends over the -control socket, as
+// one JSON object per connection.
+type controlRequest struct {
+	Command string `json:"command"` // status, pause, resume or workers
+	Workers int    `json:"workers,omitempty"`
+}
+
+// controlStatus is the reply to a controlRequest: the state of the run once
+// the command took effect.
+type controlStatus struct {
+	Error   string        `json:"error,omitempty"`
+	Paused  bool          `json:"paused"`
+	Held    bool          `json:"outside_run_window"`
+	Workers int           `json:"workers"`
+	Targets int           `json:"targets"`
+	Done    int           `json:"done"`
+	Failed  int           `json:"failed"`
+	Skipped int           `json:"skipped"`
+	Elapsed float64       `json:"elapsed_seconds"`
+	Running []controlTask `json:"running"`
+}
+
+// controlTask is a target in flight.
+type controlTask struct {
+	Target  string  `json:"target"`
+	Worker  int     `json:"worker"`
+	Elapsed float64 `json:"elapsed_seconds"`
+
+	start time.Time
+}
+
+// controlServer serves the -control socket, through which executor ctl
+// pauses, resumes and resizes a run and asks for its progress.
+type controlServer struct {
+	control  *executor.Control
+	workers  int
+	resize   func(workers int)
+	listener net.Listener
+
+	mu      sync.Mutex
+	targets int
+	done    int
+	failed  int
+	skipped int
+	start   time.Time
+	running map[int]controlTask // by target index
+}
+
+// serveControl starts serving the socket at path for a run of workers
+// workers controlled by control. resize, if not nil, is called with the new
+// number of workers. A socket left behind by a run that is gone is replaced.
+func serveControl(path string, control *executor.Control, workers int, resize func(int)) (*controlServer, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		if conn, dialErr := net.Dial("unix", path); dialErr == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another run", path)
+		}
+		os.Remove(path)
+		if listener, err = net.Listen("unix", path); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &controlServer{
+		control:  control,
+		workers:  workers,
+		resize:   resize,
+		listener: listener,
+		start:    time.Now(),
+		running:  make(map[int]controlTask),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// plan starts following a run of the given number of targets.
+func (s *controlServer) plan(targets int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets, s.done, s.failed, s.skipped = targets, 0, 0, 0
+	s.start = time.Now()
+}
+
+// handle is the executor event handler that follows the progress of the run.
+func (s *controlServer) handle(ev executor.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch ev.Type {
+	case executor.TaskStarted:
+		s.running[ev.Index] = controlTask{Target: ev.Target, Worker: ev.Worker, start: time.Now()}
+		return
+	case executor.TaskFinished:
+		if ev.Result.Failed() {
+			s.failed++
+		}
+	case executor.TaskSkipped:
+		s.skipped++
+	default:
+		return
+	}
+	s.done++
+	delete(s.running, ev.Index)
+}
+
+// serve answers the connections until the socket is closed.
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.answer(conn)
+	}
+}
+
+// answer carries out the request on conn and replies with the status.
+func (s *controlServer) answer(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var req controlRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	var failure error
+	switch req.Command {
+	case "status":
+	case "pause":
+		s.control.Pause()
+	case "resume":
+		s.control.Resume()
+	case "workers":
+		if failure = s.control.SetWorkers(req.Workers); failure == nil && s.resize != nil {
+			s.resize(req.Workers)
+		}
+	default:
+		failure = fmt.Errorf("unknown command %q", req.Command)
+	}
+
+	status := s.status()
+	if failure != nil {
+		status.Error = failure.Error()
+	}
+	json.NewEncoder(conn).Encode(status)
+}
+
+// status returns the current state of the run.
+func (s *controlServer) status() controlStatus {
+	paused, workers := s.control.State()
+	if workers == 0 {
+		workers = s.workers
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := controlStatus{
+		Paused:  paused,
+		Held:    s.control.Held(),
+		Workers: workers,
+		Targets: s.targets,
+		Done:    s.done,
+		Failed:  s.failed,
+		Skipped: s.skipped,
+		Elapsed: time.Since(s.start).Seconds(),
+		Running: []controlTask{},
+	}
+	for _, task := range s.running {
+		task.Elapsed = time.Since(task.start).Seconds()
+		status.Running = append(status.Running, task)
+	}
+	sort.Slice(status.Running, func(i, j int) bool {
+		return status.Running[i].Worker < status.Running[j].Worker
+	})
+	return status
+}
+
+// close stops serving the socket and removes it.
+func (s *controlServer) close() error {
+	return s.listener.Close()
+}
+
+// runCtl carries out "executor ctl SOCKET COMMAND [N]" against the run
+// serving SOCKET and prints its status.
+func runCtl(out io.Writer, path string, args []string) error {
+	var req controlRequest
+	switch {
+	case len(args) == 1 && (args[0] == "status" || args[0] == "pause" || args[0] == "resume"):
+		req.Command = args[0]
+	case len(args) == 2 && args[0] == "workers":
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid worker count %q", args[1])
+		}
+		req = controlRequest{Command: "workers", Workers: n}
+	default:
+		return errors.New("expected status, pause, resume or workers N")
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	var status controlStatus
+	if err := json.NewDecoder(conn).Decode(&status); err != nil {
+		return err
+	}
+	if status.Error != "" {
+		return errors.New(status.Error)
+	}
+	status.print(out)
+	return nil
+}
+
+// print writes the status for people.
+func (status controlStatus) print(w io.Writer) {
+	state := "Running"
+	switch {
+	case status.Paused && status.Held:
+		state = "Paused and outside the run window"
+	case status.Paused:
+		state = "Paused"
+	case status.Held:
+		state = "Outside the run window"
+	}
+	fmt.Fprintf(w, "%s with %d workers: %d/%d done, %d failed, %d skipped, elapsed %s\n",
+		state, status.Workers, status.Done, status.Targets, status.Failed, status.Skipped, seconds(status.Elapsed))
+	for _, task := range status.Running {
+		fmt.Fprintf(w, "Worker %d: %s (%s)\n", task.Worker, task.Target, seconds(task.Elapsed))
+	}
+}
+
+// seconds converts a number of seconds to a duration rounded to the second.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Second)
+}
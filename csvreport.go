@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// csvHeader names the columns of a -report-csv file.
+var csvHeader = []string{"target", "exit_code", "duration_ms", "retries", "stdout_bytes", "stderr_bytes"}
+
+// writeCSVReport writes one row per target of results to path. The exit
+// code is left empty for targets whose command never ran or was killed
+// without one.
+func writeCSVReport(path string, results executor.Results) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write(csvHeader)
+	for _, result := range results {
+		exitCode := ""
+		if !result.Skipped && result.ExitCode >= 0 {
+			exitCode = strconv.Itoa(result.ExitCode)
+		}
+		w.Write([]string{
+			label(&result),
+			exitCode,
+			strconv.FormatInt(result.Duration.Milliseconds(), 10),
+			strconv.Itoa(max(result.Attempts-1, 0)),
+			strconv.Itoa(len(result.Stdout)),
+			strconv.Itoa(len(result.Stderr)),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
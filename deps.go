@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadDeps reads a dependency file mapping targets to their prerequisites,
+// in YAML (or TOML for .toml files):
+//
+//	services/api: [libs/core, libs/auth]
+//	libs/auth: libs/core
+//
+// Paths are compared with the targets by their absolute form. The result is
+// keyed by target as matched; entries for paths that are not targets are
+// dropped.
+func loadDeps(path string, targets []string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var root any
+	if strings.HasSuffix(path, ".toml") {
+		root, err = parseTOML(data)
+	} else {
+		root, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	doc, ok := root.(*confMap)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a mapping of targets to prerequisites", path)
+	}
+
+	byPath := make(map[string]string, len(targets))
+	for _, target := range targets {
+		byPath[absPath(target)] = target
+	}
+
+	deps := make(map[string][]string)
+	for _, key := range doc.keys {
+		target, ok := byPath[absPath(key)]
+		if !ok {
+			continue
+		}
+		prereqs, err := confStrings(doc.values[key])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, key, err)
+		}
+		for _, prereq := range prereqs {
+			if p, ok := byPath[absPath(prereq)]; ok {
+				deps[target] = append(deps[target], p)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// parentDeps makes every target depend on the nearest target among its
+// ancestor directories, so parents run before their children.
+func parentDeps(targets []string) map[string][]string {
+	byPath := make(map[string]string, len(targets))
+	for _, target := range targets {
+		byPath[absPath(target)] = target
+	}
+
+	deps := make(map[string][]string)
+	for _, target := range targets {
+		for dir := filepath.Dir(absPath(target)); ; dir = filepath.Dir(dir) {
+			if parent, ok := byPath[dir]; ok {
+				deps[target] = []string{parent}
+				break
+			}
+			if filepath.Dir(dir) == dir {
+				break
+			}
+		}
+	}
+	return deps
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// requireFreeSpace returns the executor.Executor.CheckSpace hook finding no
+// room for a task while the filesystem of its target has less than min bytes
+// available. Targets that don't exist yet are checked on the filesystem of
+// their closest existing parent. Targets that are URLs, which urlKey
+// recognizes, and readings that cannot be taken at all never count as a lack
+// of room.
+func requireFreeSpace(min int64) func(target string) error {
+	return func(target string) error {
+		if _, ok := urlKey(target); ok {
+			return nil
+		}
+		path := target
+		for {
+			free, err := freeSpace(path)
+			if err == nil {
+				if free < min {
+					return fmt.Errorf("only %s free on the filesystem of the target, -require-free-space is %s", formatSize(free), formatSize(min))
+				}
+				return nil
+			}
+			parent := filepath.Dir(path)
+			if parent == path {
+				return nil
+			}
+			path = parent
+		}
+	}
+}
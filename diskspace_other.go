@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// freeSpaceSupported is false, as freeSpace relies on statfs(2).
+const freeSpaceSupported = false
+
+// freeSpace returns the number of bytes available to unprivileged users on
+// the filesystem of path.
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("not supported on this system")
+}
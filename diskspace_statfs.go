@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// freeSpaceSupported reports whether freeSpace can take readings.
+const freeSpaceSupported = true
+
+// freeSpace returns the number of bytes available to unprivileged users on
+// the filesystem of path.
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
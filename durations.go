@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// histogramBounds are the upper bounds of the duration histogram buckets;
+// the last bucket holds everything longer.
+var histogramBounds = []time.Duration{
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+}
+
+// histogramWidth is the length of the bar of the fullest bucket.
+const histogramWidth = 40
+
+// durationStats describes how long the commands of a run took.
+type durationStats struct {
+	Count     int               `json:"count"`
+	MinMS     int64             `json:"min_ms"`
+	MaxMS     int64             `json:"max_ms"`
+	MeanMS    int64             `json:"mean_ms"`
+	P95MS     int64             `json:"p95_ms"`
+	Histogram []histogramBucket `json:"histogram"`
+
+	min, max, mean, p95 time.Duration
+}
+
+// histogramBucket counts the durations up to a bound; the bucket without a
+// bound holds the rest.
+type histogramBucket struct {
+	UpToMS int64 `json:"up_to_ms,omitempty"`
+	Count  int   `json:"count"`
+
+	upTo time.Duration
+}
+
+// newDurationStats computes the statistics over the targets whose command
+// ran to completion, or returns nil if there are none.
+func newDurationStats(results executor.Results) *durationStats {
+	var durations []time.Duration
+	for _, result := range results {
+		if !result.Skipped && !result.Cancelled {
+			durations = append(durations, result.Duration)
+		}
+	}
+	if len(durations) == 0 {
+		return nil
+	}
+	slices.Sort(durations)
+
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	s := &durationStats{
+		Count: len(durations),
+		min:   durations[0],
+		max:   durations[len(durations)-1],
+		mean:  total / time.Duration(len(durations)),
+		p95:   durations[(len(durations)*95+99)/100-1],
+	}
+	s.MinMS, s.MaxMS = round(s.min).Milliseconds(), round(s.max).Milliseconds()
+	s.MeanMS, s.P95MS = round(s.mean).Milliseconds(), round(s.p95).Milliseconds()
+
+	// Keep the buckets from the shortest duration to the longest
+	buckets := make([]histogramBucket, len(histogramBounds)+1)
+	for i, bound := range histogramBounds {
+		buckets[i] = histogramBucket{UpToMS: bound.Milliseconds(), upTo: bound}
+	}
+	first, last := len(buckets), 0
+	for _, d := range durations {
+		i, _ := slices.BinarySearch(histogramBounds, d)
+		buckets[i].Count++
+		first, last = min(first, i), max(last, i)
+	}
+	s.Histogram = buckets[first : last+1]
+	return s
+}
+
+// print writes the statistics with the histogram as bars.
+func (s *durationStats) print(w io.Writer) {
+	fmt.Fprintf(w, "Durations: min %s, mean %s, p95 %s, max %s\n",
+		round(s.min), round(s.mean), round(s.p95), round(s.max))
+
+	most := 0
+	for _, bucket := range s.Histogram {
+		most = max(most, bucket.Count)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, bucket := range s.Histogram {
+		bound := "longer"
+		if bucket.upTo > 0 {
+			bound = "<= " + bucket.upTo.String()
+		}
+		bar := strings.Repeat("#", (bucket.Count*histogramWidth+most-1)/most)
+		fmt.Fprintf(tw, "  %s\t%s\t%d\n", bound, bar, bucket.Count)
+	}
+	tw.Flush()
+}
+
+// round shortens a duration for display.
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
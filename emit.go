@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// emitPlan writes tasks with write to the file at path, created with perm,
+// for -emit-script or -emit-make, instead of running them.
+func emitPlan(path string, perm os.FileMode, write func(io.Writer, []executor.Task, []string, []string) error, tasks []executor.Task, env, shell []string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	err = write(w, tasks, env, shell)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// planShell returns how a command of a task runs in a POSIX shell: as it is
+// for the default shell unless it must fit on one line, or else passed to
+// the default shell or the one set with -shell.
+func planShell(command string, shell []string, oneLine bool) string {
+	if len(shell) == 0 {
+		shell = []string{"sh", "-c"}
+	}
+	if !oneLine && slices.Equal(shell, []string{"sh", "-c"}) {
+		return command
+	}
+	return strings.Join(append(slices.Clone(shell), shellQuote(command)), " ")
+}
+
+// planTask returns the shell commands running task: in its directory, only
+// if its test succeeds and each command only if the ones before did, on
+// several lines unless oneLine is set.
+func planTask(task executor.Task, shell []string, oneLine bool) string {
+	sep := "\n"
+	if oneLine {
+		sep = "; "
+	}
+	commands := make([]string, len(task.Commands))
+	for i, command := range task.Commands {
+		commands[i] = planShell(command, shell, oneLine)
+		// Group the lines of a command, for the one after to depend on it
+		// as a whole rather than on its last line only
+		if task.Stdin != "" || strings.Contains(commands[i], "\n") {
+			commands[i] = "{ " + commands[i] + sep + "}"
+		}
+		if task.Stdin != "" {
+			commands[i] += " < " + shellQuote(task.Stdin)
+		}
+	}
+	line := strings.Join(commands, " && ")
+	if task.Test != "" {
+		line = "if " + planShell(task.Test, shell, oneLine) + sep + "then " + line + sep + "fi"
+	}
+	if task.Dir != "" {
+		line = "cd " + shellQuote(task.Dir) + " && " + line
+	}
+	return line
+}
+
+// planComment describes the targets of task on a comment line.
+func planComment(task executor.Task) string {
+	return "# " + strings.ReplaceAll(strings.Join(task.Targets, " "), "\n", " ")
+}
+
+// writeScript writes tasks as a shell script that runs them one after
+// another, each in a subshell, and tells whether any failed by its exit
+// status. Tasks whose prerequisites failed don't run.
+func writeScript(w io.Writer, tasks []executor.Task, env, shell []string) error {
+	deps := slices.ContainsFunc(tasks, func(task executor.Task) bool { return len(task.After) > 0 })
+
+	fmt.Fprintln(w, "#!/bin/sh")
+	fmt.Fprintf(w, "# The %d tasks planned by executor, run one after another\n", len(tasks))
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(w, "export %s=%s\n", key, shellQuote(value))
+	}
+	fmt.Fprintln(w, "failed=0")
+	for i, task := range tasks {
+		fmt.Fprintf(w, "\n%s\n", planComment(task))
+		run := "(" + planTask(task, shell, false) + "\n)"
+		if deps {
+			run += fmt.Sprintf(" && ok%d=1", i)
+		}
+		run += " || failed=1"
+		if len(task.After) > 0 {
+			conditions := make([]string, len(task.After))
+			for j, k := range task.After {
+				conditions[j] = fmt.Sprintf(`[ -n "$ok%d" ]`, k)
+			}
+			run = "if " + strings.Join(conditions, " && ") + "; then\n\t" + run + "\nfi"
+		}
+		fmt.Fprintln(w, run)
+	}
+	fmt.Fprintln(w, "\nexit $failed")
+	return nil
+}
+
+// writeMakefile writes tasks as a makefile with a rule for each, named
+// after its position, so that make can run them in parallel with -j and
+// keep going past failures with -k. Rules depend on those of the
+// prerequisites of their task. Commands must fit on one line, as make would
+// join their lines.
+func writeMakefile(w io.Writer, tasks []executor.Task, env, shell []string) error {
+	for _, task := range tasks {
+		if slices.ContainsFunc(slices.Concat(task.Commands, []string{task.Test}), func(command string) bool { return strings.Contains(command, "\n") }) {
+			return fmt.Errorf("the command for %s spans several lines, which a makefile cannot hold; use -emit-script", task.Targets[0])
+		}
+	}
+	escape := strings.NewReplacer("$", "$$", "\n", " ")
+	names := make([]string, len(tasks))
+	for i := range tasks {
+		names[i] = fmt.Sprintf("task%d", i+1)
+	}
+
+	fmt.Fprintf(w, "# The %d tasks planned by executor; 'make -k -j N' runs N at a time\n", len(tasks))
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(w, "export %s := %s\n", key, escape.Replace(value))
+	}
+	fmt.Fprintf(w, "\n.PHONY: all %s\nall: %s\n", strings.Join(names, " "), strings.Join(names, " "))
+	for i, task := range tasks {
+		prereqs := make([]string, len(task.After))
+		for j, k := range task.After {
+			prereqs[j] = names[k]
+		}
+		fmt.Fprintf(w, "\n%s\n%s:", planComment(task), names[i])
+		if len(prereqs) > 0 {
+			fmt.Fprint(w, " "+strings.Join(prereqs, " "))
+		}
+		fmt.Fprintf(w, "\n\t%s\n", escape.Replace(planTask(task, shell, true)))
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// encodings lists the -output-encoding values besides "auto" and "none".
+var encodings = []string{"utf-8", "utf-16le", "utf-16be", "iso-8859-1", "iso-8859-15", "windows-1252", "cp437"}
+
+// encodingAliases maps other names of encodings to those in encodings.
+var encodingAliases = map[string]string{
+	"utf8":    "utf-8",
+	"latin1":  "iso-8859-1",
+	"latin9":  "iso-8859-15",
+	"cp1252":  "windows-1252",
+	"ibm437":  "cp437",
+	"utf16le": "utf-16le",
+	"utf16be": "utf-16be",
+}
+
+// windows1252 holds the characters of bytes 0x80 to 0x9F in Windows-1252,
+// which are control characters in ISO 8859-1. The five bytes it leaves
+// undefined keep those.
+var windows1252 = []rune("€\u0081‚ƒ„…†‡ˆ‰Š‹Œ\u008DŽ\u008F\u0090‘’“”•–—˜™š›œ\u009DžŸ")
+
+// cp437 holds the characters of bytes 0x80 to 0xFF in code page 437, that
+// of the console of Windows and DOS in the US.
+var cp437 = []rune("ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒáíóúñÑªº¿⌐¬½¼¡«»" +
+	"░▒▓│┤╡╢╖╕╣║╗╝╜╛┐└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+	"αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■\u00A0")
+
+// latin9 holds the characters by which ISO 8859-15 differs from ISO 8859-1.
+var latin9 = map[byte]rune{0xA4: '€', 0xA6: 'Š', 0xA8: 'š', 0xB4: 'Ž', 0xB8: 'ž', 0xBC: 'Œ', 0xBD: 'œ', 0xBE: 'Ÿ'}
+
+// parseEncoding returns the executor.Executor.Decode hook converting output
+// from the -output-encoding name to UTF-8: "auto" detects the encoding of
+// output that isn't valid UTF-8 already, and "none" keeps the output as
+// written, returning nil.
+func parseEncoding(name string) (func([]byte) []byte, error) {
+	name = strings.ToLower(name)
+	if alias, ok := encodingAliases[name]; ok {
+		name = alias
+	}
+	switch {
+	case name == "none":
+		return nil, nil
+	case name == "auto":
+		return decodeAuto, nil
+	case slices.Contains(encodings, name):
+		return func(output []byte) []byte { return decodeAs(name, output) }, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q (expected auto, none or one of %s)", name, strings.Join(encodings, ", "))
+}
+
+// decodeAuto converts output to UTF-8 from the encoding it seems to be in:
+// UTF-16 when it starts with a byte order mark or most of its characters
+// have a zero byte, UTF-8 when it is valid as such, and Windows-1252, the
+// most common legacy encoding, otherwise.
+func decodeAuto(output []byte) []byte {
+	if name := detectUTF16(output); name != "" {
+		return decodeAs(name, output)
+	}
+	if validUTF8(output) {
+		return output
+	}
+	return decodeAs("windows-1252", output)
+}
+
+// detectUTF16 returns "utf-16le" or "utf-16be" if output seems to be in
+// that encoding, and "" otherwise.
+func detectUTF16(output []byte) string {
+	switch {
+	case bytes.HasPrefix(output, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(output, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	case len(output) < 2:
+		return ""
+	}
+
+	// Text mostly in ASCII has a zero byte in every other place
+	var even, odd int
+	for i, b := range output {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	pairs := len(output) / 2
+	switch {
+	case odd > pairs/2 && even <= pairs/10:
+		return "utf-16le"
+	case even > pairs/2 && odd <= pairs/10:
+		return "utf-16be"
+	}
+	return ""
+}
+
+// validUTF8 reports whether output is valid UTF-8, but for a character cut
+// at either end, as -max-output-bytes may leave it. Bytes at the ends only
+// pass for a cut character if the rest holds other characters beyond ASCII,
+// rather than none like a legacy encoding would.
+func validUTF8(output []byte) bool {
+	if utf8.Valid(output) {
+		return true
+	}
+	trimmed := output
+	for i := 0; i < utf8.UTFMax-1 && len(trimmed) > 0 && !utf8.RuneStart(trimmed[0]); i++ {
+		trimmed = trimmed[1:]
+	}
+	for i := 1; i < utf8.UTFMax && i <= len(trimmed); i++ {
+		if utf8.RuneStart(trimmed[len(trimmed)-i]) {
+			if !utf8.FullRune(trimmed[len(trimmed)-i:]) {
+				trimmed = trimmed[:len(trimmed)-i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(trimmed) && utf8.RuneCount(trimmed) < len(trimmed)
+}
+
+// decodeAs converts output from the named encoding, one of encodings, to
+// UTF-8. Invalid input becomes U+FFFD.
+func decodeAs(name string, output []byte) []byte {
+	switch name {
+	case "utf-8":
+		return bytes.ToValidUTF8(output, []byte("�"))
+	case "utf-16le", "utf-16be":
+		var order binary.ByteOrder = binary.LittleEndian
+		if name == "utf-16be" {
+			order = binary.BigEndian
+		}
+		units := make([]uint16, 0, len(output)/2)
+		for i := 0; i+1 < len(output); i += 2 {
+			units = append(units, order.Uint16(output[i:]))
+		}
+		if len(units) > 0 && units[0] == 0xFEFF {
+			units = units[1:]
+		}
+		var buf []byte
+		for _, r := range utf16.Decode(units) {
+			buf = utf8.AppendRune(buf, r)
+		}
+		return buf
+	}
+
+	buf := make([]byte, 0, len(output))
+	for _, b := range output {
+		r := rune(b)
+		switch {
+		case b < 0x80:
+		case name == "cp437":
+			r = cp437[b-0x80]
+		case name == "windows-1252" && b < 0xA0:
+			r = windows1252[b-0x80]
+		case name == "iso-8859-15" && latin9[b] != 0:
+			r = latin9[b]
+		}
+		buf = utf8.AppendRune(buf, r)
+	}
+	return buf
+}
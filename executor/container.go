@@ -0,0 +1,74 @@
+package executor
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+)
+
+// Container describes the container that commands run in when
+// Executor.Container is set. Each command gets a fresh container of Image,
+// removed when it exits, with the target bind-mounted at its absolute path.
+type Container struct {
+	// Image is the image to run.
+	Image string
+
+	// Mounts lists extra bind mounts in docker's "source:target[:options]"
+	// form.
+	Mounts []string
+
+	// Network is the network mode, e.g. "none" or "host". Empty uses the
+	// default network.
+	Network string
+
+	// CPUs and Memory limit the resources of each container, e.g. "1.5"
+	// and "512m". Empty means no limit.
+	CPUs   string
+	Memory string
+
+	// Runtime is the container CLI, "docker" if empty. Any command that
+	// accepts docker's run flags, such as podman, can be used.
+	Runtime string
+}
+
+// containerCommand builds the process that runs args in a fresh container
+// for the target at index, in dir unless it is empty.
+func (r *run) containerCommand(ctx context.Context, args []string, worker, index int, dir string) *exec.Cmd {
+	c := r.Container
+	runtime := c.Runtime
+	if runtime == "" {
+		runtime = "docker"
+	}
+
+	target := r.results[index].Target
+	if abs, err := filepath.Abs(target); err == nil {
+		target = abs
+	}
+	run := []string{"run", "--rm", "--init", "-i", "-v", target + ":" + target}
+	if r.scratch != nil {
+		run = append(run, "-v", r.scratch[index]+":"+r.scratch[index])
+	}
+	for _, mount := range c.Mounts {
+		run = append(run, "-v", mount)
+	}
+	if dir != "" {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		run = append(run, "-w", dir)
+	}
+	if c.Network != "" {
+		run = append(run, "--network", c.Network)
+	}
+	if c.CPUs != "" {
+		run = append(run, "--cpus", c.CPUs)
+	}
+	if c.Memory != "" {
+		run = append(run, "--memory", c.Memory)
+	}
+	for _, kv := range r.taskEnv(worker, index) {
+		run = append(run, "-e", kv)
+	}
+	run = append(run, c.Image)
+	return exec.CommandContext(ctx, runtime, append(run, args...)...)
+}
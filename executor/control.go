@@ -0,0 +1,100 @@
+package executor
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Control pauses, resumes and resizes the runs of an Executor while they
+// run, such as on behalf of a control socket. It is safe for concurrent
+// use. The zero value lets runs start tasks as Executor.Workers allows.
+type Control struct {
+	mu      sync.Mutex
+	paused  bool
+	held    bool
+	workers int
+	changed chan struct{} // closed and replaced on every change
+}
+
+// Pause stops runs from starting tasks. Tasks already started carry on.
+func (c *Control) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+	c.notify()
+}
+
+// Resume lets runs start tasks again after Pause, unless they are held.
+func (c *Control) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+	c.notify()
+}
+
+// Hold stops runs from starting tasks on behalf of a schedule, such as a time
+// window, rather than of a person: runs only start tasks when neither held
+// nor paused, so that Resume doesn't override a schedule and Release doesn't
+// override Pause.
+func (c *Control) Hold() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held = true
+	c.notify()
+}
+
+// Release lets runs start tasks again after Hold, unless they are paused.
+func (c *Control) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held = false
+	c.notify()
+}
+
+// Held reports whether runs are held by Hold.
+func (c *Control) Held() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.held
+}
+
+// SetWorkers changes the number of tasks run concurrently, overriding
+// Executor.Workers. When it shrinks, tasks in flight are not stopped but no
+// new ones start until fewer are running.
+func (c *Control) SetWorkers(n int) error {
+	if n < 1 {
+		return fmt.Errorf("invalid worker count %d", n)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers = n
+	c.notify()
+	return nil
+}
+
+// State reports whether runs are paused by Pause and the number of workers
+// set by SetWorkers, or zero if it was never called.
+func (c *Control) State() (paused bool, workers int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused, c.workers
+}
+
+// watch returns the state, paused when either paused or held, along with a
+// channel closed on the next change.
+func (c *Control) watch() (paused bool, workers int, changed <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.changed == nil {
+		c.changed = make(chan struct{})
+	}
+	return c.paused || c.held, c.workers, c.changed
+}
+
+// notify wakes up the runs watching c. c.mu must be held.
+func (c *Control) notify() {
+	if c.changed != nil {
+		close(c.changed)
+		c.changed = nil
+	}
+}
@@ -0,0 +1,56 @@
+package executor
+
+import "time"
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+const (
+	// TaskStarted is emitted when a worker picks up a target.
+	TaskStarted EventType = iota
+
+	// TaskRetrying is emitted after a failed attempt that will be retried.
+	TaskRetrying
+
+	// TaskFinished is emitted once a target has been fully processed.
+	TaskFinished
+
+	// TaskSkipped is emitted when a target will not run because one of its
+	// dependencies did not succeed, with Worker -1, because Executor.Confirm
+	// declined it or Executor.CheckSpace found no room for it, before any
+	// TaskStarted from its worker, or, after TaskStarted, because its test
+	// command failed.
+	TaskSkipped
+
+	// TaskOutput is emitted for each line a command writes when
+	// Executor.Stream is set.
+	TaskOutput
+)
+
+// Event reports progress of a run to Executor.OnEvent.
+type Event struct {
+	Type EventType
+
+	// Worker is the index of the worker handling the target, which may not
+	// have sent TaskStarted yet, or -1 for a TaskSkipped without one.
+	Worker int
+
+	// Target is the target the event refers to, and Index its position in
+	// the targets passed to Run.
+	Target string
+	Index  int
+
+	// Attempt, Delay and Err describe the failed attempt of a TaskRetrying
+	// event and the delay before the next one.
+	Attempt int
+	Delay   time.Duration
+	Err     error
+
+	// Line is the line of a TaskOutput event without its line ending, and
+	// Stderr tells whether the command wrote it to stderr.
+	Line   []byte
+	Stderr bool
+
+	// Result is set for TaskFinished and TaskSkipped events.
+	Result *Result
+}
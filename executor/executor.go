@@ -0,0 +1,970 @@
+// Package executor runs a shell command against many targets in parallel.
+//
+// An Executor distributes targets over a pool of workers, retries failed
+// commands and stops early once too many of them fail. Instead of a command,
+// it can also call a Go function for each target. Callers observe the run
+// through the OnEvent hook, can pause or resize it through a Control, and
+// receive one Result per target when it ends.
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWorkers is the number of workers used when Executor.Workers is zero.
+const DefaultWorkers = 4
+
+// Values of Executor.WorkDir selecting a working directory relative to the
+// target.
+const (
+	WorkDirTarget  = "target" // the target if it is a directory, else its parent
+	WorkDirParent  = "parent" // the parent directory of the target
+	WorkDirCurrent = "cwd"    // the current directory of the process
+)
+
+// ErrAborted is returned by Run when the failure limit stopped the run early.
+var ErrAborted = errors.New("run aborted after too many failures")
+
+// Executor runs a command for each target on a pool of workers. The zero
+// value is ready to use and runs DefaultWorkers commands at a time.
+type Executor struct {
+	// Workers is the number of commands run concurrently.
+	Workers int
+
+	// Retries is how many times a failed command is re-run before its
+	// target is reported as failed.
+	Retries int
+
+	// RetryDelay is the delay before the first retry. It doubles for each
+	// further attempt.
+	RetryDelay time.Duration
+
+	// Timeout stops each attempt of a command that runs longer than this,
+	// which then fails and may be retried. Zero means no limit.
+	Timeout time.Duration
+
+	// Overloaded, if set, is consulted before each task is started. While
+	// it reports true, workers wait before starting new tasks, unless no
+	// task is running at all. It must be safe for concurrent use.
+	Overloaded func() bool
+
+	// CheckSpace, if set, is called before each task is started with its
+	// target, the first one for a batch, and returns an error while there
+	// is not enough room for the task, such as on the filesystem of the
+	// target. Workers then wait for the running tasks to end, and skip the
+	// targets with NoSpace set and the error as the reason if there is
+	// still no room once none is running. It must be safe for concurrent
+	// use.
+	CheckSpace func(target string) error
+
+	// Acquire, if set, is called before each task is started to take a slot
+	// from a budget shared with other processes, such as a jobserver. The
+	// task starts once it returns, and calls release when it is done. It
+	// returns an error if ctx is cancelled first. It must be safe for
+	// concurrent use.
+	Acquire func(ctx context.Context) (release func(), err error)
+
+	// Control, if set, pauses, resumes and resizes the run from another
+	// goroutine: its workers replace Workers once set, and more workers are
+	// started as needed.
+	Control *Control
+
+	// Rate limits how many tasks are launched per second across all
+	// workers, independent of Workers. Zero means no limit.
+	Rate float64
+
+	// Jitter delays each launch by a random duration up to this value, so
+	// launches don't hit shared resources in lockstep.
+	Jitter time.Duration
+
+	// Seed, if not zero, seeds the random delays of Jitter, so that runs
+	// with the same seed delay their n-th launches alike.
+	Seed uint64
+
+	// Shell is the interpreter and its arguments used to run commands; the
+	// expanded command is appended as the last argument. Defaults to
+	// DefaultShell.
+	Shell []string
+
+	// Direct runs commands without a shell. The command is split into
+	// words like sh would split it and the first word is executed with the
+	// others as arguments, so target paths can never be interpreted as shell
+	// syntax.
+	Direct bool
+
+	// MaxFailures stops the run once this many targets have failed,
+	// killing in-flight commands and skipping queued ones. Zero means no
+	// limit.
+	MaxFailures int
+
+	// Batch runs the command once for up to this many consecutive targets,
+	// like xargs: each placeholder is replaced with its values for all of
+	// them, separated by spaces. Batched commands run in the current
+	// directory unless WorkDir is set, which then applies to the first
+	// target of the batch, and every target of a batch shares its result.
+	// Batching cannot be combined with Deps or template commands.
+	Batch int
+
+	// Stages, if set, make a pipeline of the commands to run for each target
+	// instead of a single command: every target goes through the stages in
+	// order, with the retries and timeout applying to each stage, and the
+	// first stage that fails ends the pipeline of that target, its error
+	// naming the stage. Result.Stages records the stages that ran. Stages
+	// cannot be combined with a function, commands per target, batching or
+	// Verify.
+	Stages []Stage
+
+	// Deps maps a target to the targets that must succeed before it runs.
+	// Targets whose prerequisites fail are skipped. Prerequisites that are
+	// not among the targets passed to Run are ignored.
+	Deps map[string][]string
+
+	// Partitions, if set, holds a key for each target by index, such as the
+	// repository it is in. Targets with the same non-empty key run one
+	// after another, in order, so that their commands never race; the others
+	// still run in parallel. It cannot be combined with batching.
+	Partitions []string
+
+	// Groups, if set, holds the concurrency group of each target by index,
+	// and GroupLimits the most tasks of each group that run at once, such
+	// as 2 for the targets on a network mount. Groups without a limit, like
+	// targets in group "", are only held back by Workers. It cannot be
+	// combined with batching.
+	Groups      []string
+	GroupLimits map[string]int
+
+	// WorkDir selects the working directory of the commands: WorkDirTarget
+	// (the default), WorkDirParent, WorkDirCurrent, or a path in which the
+	// placeholders described by Expand are replaced for the target.
+	WorkDir string
+
+	// Input is fed to the standard input of every command. With
+	// PipeTarget, the contents of the target file are fed instead, which
+	// cannot be combined with batching. Otherwise commands read no input.
+	Input      []byte
+	PipeTarget bool
+
+	// Commands, if set, holds the command for each target by index, which
+	// replaces the command passed to Run unless it is empty. It cannot be
+	// combined with batching.
+	Commands []string
+
+	// Paths, if set, holds for each target by index the value substituted
+	// for {} and .Path in its place, such as the target rewritten into the
+	// form another tool expects. The other placeholders still describe the
+	// target.
+	Paths []string
+
+	// Columns holds extra values for each target, by target index, such as
+	// the fields of a CSV row. Commands can refer to them as {1}, {2}, ...
+	// and, for the names in ColumnNames, as {NAME}; templates as .Columns
+	// and .Column.
+	Columns     [][]string
+	ColumnNames []string
+
+	// Hosts, if set, holds the remote host for each target by index, such
+	// as "user@example.com". Commands then run there over SSH, by the remote
+	// user's shell in the home directory unless WorkDir names another. Shell
+	// doesn't apply to them, but the environment variables do.
+	Hosts []string
+
+	// SSH is the ssh client command and its options used to reach Hosts.
+	// Defaults to DefaultSSH.
+	SSH []string
+
+	// Nice, IdleIO, CPULimit and MemoryLimit constrain the commands run on
+	// this machine: Nice adjusts their scheduling priority like nice(1),
+	// IdleIO lets them use the disks only when nothing else does, CPULimit
+	// caps the processor time of each process and MemoryLimit its address
+	// space in bytes. Zero leaves them unchanged. They are not supported on
+	// Windows, and IdleIO only on Linux and macOS.
+	Nice        int
+	IdleIO      bool
+	CPULimit    time.Duration
+	MemoryLimit int64
+
+	// Sandbox runs the commands, including those of Test and Verify, in
+	// Linux namespaces of their own: without network access, on a
+	// filesystem that is read-only except for their targets and scratch
+	// directory, and without any capabilities, even as root. It needs
+	// unprivileged user namespaces and Linux 5.12, and programs using it
+	// must call SandboxInit at the start of main. It cannot be combined
+	// with remote hosts, containers or a function.
+	Sandbox bool
+
+	// Scratch gives each task a fresh temporary directory, which its
+	// commands find as {tmp} (.Tmp in templates) and in EXECUTOR_TMP, and
+	// which is removed when the task ends. With KeepFailedScratch, that of
+	// a failed task is kept and named in its result instead. Scratch
+	// directories cannot be combined with Hosts.
+	Scratch           bool
+	KeepFailedScratch bool
+
+	// Container, if set, runs each command in a fresh container instead of
+	// on this machine, by /bin/sh unless Direct is set. Shell doesn't apply
+	// to it, but the environment variables do.
+	Container *Container
+
+	// Env lists extra "KEY=value" environment variables for the commands.
+	// Commands also receive EXECUTOR_TARGET, EXECUTOR_INDEX (counting from
+	// 1), EXECUTOR_TOTAL and EXECUTOR_WORKER describing their task.
+	Env []string
+
+	// GracePeriod is how long running commands are given to exit after the
+	// run is stopped, before they are killed. Defaults to
+	// DefaultGracePeriod.
+	GracePeriod time.Duration
+
+	// MaxOutput, if positive, limits the output kept in each of
+	// Result.Output, Stdout and Stderr to this many bytes: the first ones, or
+	// the last ones with KeepOutputTail. How much was left out is recorded in
+	// Result.StdoutDropped and StderrDropped.
+	MaxOutput      int
+	KeepOutputTail bool
+
+	// SaveOutput, if set, is called before each attempt of a command with
+	// the target and its index, the first ones for a batch, and returns
+	// writers that receive the complete stdout and stderr of the attempt,
+	// such as log files. Either can be nil. They are closed when the attempt
+	// ends. It must be safe for concurrent use.
+	SaveOutput func(target string, index int) (stdout, stderr io.WriteCloser)
+
+	// Decode, if set, converts the output of the commands to UTF-8 before it
+	// is kept in results or emitted in TaskOutput events, such as from the
+	// legacy encoding a tool writes. SaveOutput still receives the output as
+	// written. It must be safe for concurrent use.
+	Decode func(output []byte) []byte
+
+	// Metadata reads the Metadata of each target when its task starts into
+	// its result. Commands written as templates get it either way.
+	Metadata bool
+
+	// Stream emits a TaskOutput event for each line of output as soon as a
+	// command writes it, in addition to collecting the output in the
+	// result.
+	Stream bool
+
+	// Test is a command run for each target before the main one, expanded
+	// the same way. The main command only runs if the test succeeds;
+	// otherwise the target is skipped and marked with TestFailed. Test
+	// cannot be combined with batching.
+	Test string
+
+	// Verify is a command run for each target after each attempt of the
+	// main one that succeeded, expanded the same way. The attempt only
+	// succeeds if Verify does too; otherwise it fails with VerifyFailed set
+	// and may be retried.
+	Verify string
+
+	// Confirm, if set, is called with the target and its expanded command
+	// before the command is started. Returning an error skips the target,
+	// with the error as the SkipReason of its result. For a batch, target is
+	// its first target. It is called from the worker goroutines and must be
+	// safe for concurrent use.
+	Confirm func(target, command string) error
+
+	// Func, if set, is called for each target in place of a command, to
+	// process targets in-process with the same workers, retries, timeout
+	// and results. The command passed to Run is then ignored and can be
+	// empty; the error returned is the result's Err. Func is called from the
+	// worker goroutines and must be safe for concurrent use, and it must
+	// return once ctx is done, as it cannot be killed like a process. It
+	// cannot be combined with batching, Commands, Hosts, Container,
+	// PipeTarget or Scratch.
+	Func func(ctx context.Context, target string) error
+
+	// OnEvent, if set, is called for every task lifecycle event. It is
+	// called from the worker goroutines and must be safe for concurrent use.
+	OnEvent func(Event)
+}
+
+// run holds the state of a single call to Run.
+type run struct {
+	*Executor
+	ctx      context.Context
+	cancel   context.CancelFunc
+	shell    []string
+	command  *commandLine
+	commands []*commandLine
+	stages   []*commandLine
+	test     *commandLine
+	verify   *commandLine
+	scratch  []string
+	quote    func(string) string
+	columns  *columnTable
+	limiter  *launchLimiter
+	results  Results
+	failures atomic.Int32
+	running  atomic.Int32
+}
+
+// Run executes command once per target and returns the results in the order
+// of targets. Placeholders in command are expanded for each target as
+// described by Expand, with the values quoted for the shell. A command
+// containing "{{" is instead executed as a text/template with TemplateData,
+// with a quote function for shell quoting and relpath for relative paths.
+// With Executor.Func, that function is called instead and command is ignored,
+// as it is with Executor.Stages.
+//
+// Run returns ErrAborted if MaxFailures stopped the run, or the context's
+// error if ctx was cancelled. Targets that were not processed because of
+// either, or because a dependency failed, are marked as skipped in the
+// results.
+func (e *Executor) Run(ctx context.Context, targets []string, command string) (Results, error) {
+	workers := e.Workers
+	if workers == 0 {
+		workers = DefaultWorkers
+	}
+	if workers < 0 {
+		return nil, fmt.Errorf("invalid worker count %d", workers)
+	}
+
+	// Cancelling the context stops in-flight commands and skips queued ones
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r, err := e.newRun(ctx, cancel, targets, command)
+	if err != nil {
+		return nil, err
+	}
+
+	// When batching, the tasks are batch numbers rather than target indexes
+	var graph *depGraph
+	if e.Batch > 1 {
+		graph = emptyDepGraph((len(targets) + e.Batch - 1) / e.Batch)
+	} else {
+		if graph, err = newDepGraph(targets, e.Deps); err != nil {
+			return nil, err
+		}
+	}
+
+	// Hand out tasks to the workers as their dependencies allow
+	tasks := make(chan int)
+	done := make(chan int, len(targets))
+	var wg sync.WaitGroup
+	spawned := 0
+	spawn := func(n int) {
+		for ; spawned < n; spawned++ {
+			wg.Add(1)
+			go r.worker(spawned, tasks, done, &wg)
+		}
+	}
+	r.schedule(graph, workers, spawn, tasks, done)
+	wg.Wait()
+
+	for i := range r.results {
+		if r.results[i].Skipped && r.results[i].SkipReason == "" {
+			r.results[i].SkipReason = "run aborted"
+		}
+	}
+
+	if e.MaxFailures > 0 && int(r.failures.Load()) >= e.MaxFailures {
+		return r.results, ErrAborted
+	}
+	return r.results, ctx.Err()
+}
+
+// newRun checks the settings of the executor and prepares a run of command
+// for targets with ctx, which cancel cancels.
+func (e *Executor) newRun(ctx context.Context, cancel context.CancelFunc, targets []string, command string) (*run, error) {
+	if e.Retries < 0 {
+		return nil, fmt.Errorf("invalid retry count %d", e.Retries)
+	}
+	if e.Rate < 0 || e.Jitter < 0 {
+		return nil, errors.New("rate and jitter cannot be negative")
+	}
+	if e.Timeout < 0 {
+		return nil, errors.New("timeout cannot be negative")
+	}
+	if e.MaxOutput < 0 {
+		return nil, errors.New("maximum output size cannot be negative")
+	}
+	if e.Batch < 0 {
+		return nil, fmt.Errorf("invalid batch size %d", e.Batch)
+	}
+	if e.Batch > 1 && len(e.Deps) > 0 {
+		return nil, errors.New("dependencies cannot be combined with batching")
+	}
+	if e.Batch > 1 && e.Test != "" {
+		return nil, errors.New("a test command cannot be combined with batching")
+	}
+	if e.Batch > 1 && e.PipeTarget {
+		return nil, errors.New("piping targets cannot be combined with batching")
+	}
+	if e.Batch > 1 && e.Commands != nil {
+		return nil, errors.New("commands per target cannot be combined with batching")
+	}
+	if e.Commands != nil && len(e.Commands) != len(targets) {
+		return nil, fmt.Errorf("got %d commands for %d targets", len(e.Commands), len(targets))
+	}
+	if e.Stages != nil && (e.Func != nil || e.Commands != nil || e.Batch > 1 || e.Verify != "") {
+		return nil, errors.New("stages cannot be combined with a function, commands per target, batching or a verify command")
+	}
+	if e.Batch > 1 && e.Partitions != nil {
+		return nil, errors.New("partitions cannot be combined with batching")
+	}
+	if e.Partitions != nil && len(e.Partitions) != len(targets) {
+		return nil, fmt.Errorf("got %d partitions for %d targets", len(e.Partitions), len(targets))
+	}
+	if e.Batch > 1 && e.Groups != nil {
+		return nil, errors.New("concurrency groups cannot be combined with batching")
+	}
+	if e.Groups != nil && len(e.Groups) != len(targets) {
+		return nil, fmt.Errorf("got %d groups for %d targets", len(e.Groups), len(targets))
+	}
+	for group, limit := range e.GroupLimits {
+		if limit < 1 {
+			return nil, fmt.Errorf("invalid limit %d for group %q", limit, group)
+		}
+	}
+	if e.Paths != nil && len(e.Paths) != len(targets) {
+		return nil, fmt.Errorf("got %d paths for %d targets", len(e.Paths), len(targets))
+	}
+	if err := validColumnNames(e.ColumnNames); err != nil {
+		return nil, err
+	}
+	if e.Hosts != nil && len(e.Hosts) != len(targets) {
+		return nil, fmt.Errorf("got %d hosts for %d targets", len(e.Hosts), len(targets))
+	}
+	if e.Hosts != nil && e.Scratch {
+		return nil, errors.New("remote hosts cannot be combined with scratch directories")
+	}
+	if e.Hosts != nil && e.Container != nil {
+		return nil, errors.New("remote hosts cannot be combined with containers")
+	}
+	if (e.Nice != 0 || e.CPULimit != 0 || e.MemoryLimit != 0) && !limitsSupported {
+		return nil, errors.New("resource limits are not supported on this platform")
+	}
+	if e.Sandbox && !sandboxSupported {
+		return nil, errors.New("sandboxing is only supported on Linux")
+	}
+	if e.Sandbox && (e.Hosts != nil || e.Container != nil || e.Func != nil) {
+		return nil, errors.New("a sandbox cannot be combined with remote hosts, containers or a function")
+	}
+	if e.IdleIO && idleIOCommand() == nil {
+		return nil, errors.New("idle IO priority is not supported on this platform")
+	}
+	if e.CPULimit < 0 || e.MemoryLimit < 0 {
+		return nil, errors.New("resource limits cannot be negative")
+	}
+	if e.Container != nil && e.Container.Image == "" {
+		return nil, errors.New("container image is missing")
+	}
+	if e.Func != nil && (e.Batch > 1 || e.Commands != nil || e.Hosts != nil || e.Container != nil || e.PipeTarget || e.Scratch) {
+		return nil, errors.New("a function cannot be combined with batching, commands per target, remote hosts, containers, piping targets or scratch directories")
+	}
+
+	shell := e.Shell
+	if len(shell) == 0 {
+		shell = DefaultShell()
+	}
+
+	r := &run{
+		Executor: e,
+		ctx:      ctx,
+		cancel:   cancel,
+		shell:    shell,
+		limiter:  newLaunchLimiter(e.Rate, e.Jitter, e.Seed),
+		results:  make(Results, len(targets)),
+		quote:    shellQuoter(shell),
+	}
+	if e.Hosts != nil || e.Container != nil {
+		// Remote and containerised commands are run by a POSIX shell
+		r.quote = posixQuote
+	}
+	if e.Columns != nil {
+		r.columns = &columnTable{names: e.ColumnNames, rows: e.Columns}
+	}
+	if e.Scratch {
+		r.scratch = make([]string, len(targets))
+	}
+	for i, target := range targets {
+		r.results[i] = Result{Target: target, Skipped: true}
+	}
+	var err error
+	if e.Func == nil && e.Stages == nil {
+		if r.command, err = r.prepareCommand(command); err != nil {
+			return nil, fmt.Errorf("invalid command: %w", err)
+		}
+	}
+	for _, stage := range e.Stages {
+		line, err := r.prepareCommand(stage.Command)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command of stage %s: %w", stage.Name, err)
+		}
+		r.stages = append(r.stages, line)
+	}
+	if e.Commands != nil {
+		r.commands = make([]*commandLine, len(targets))
+		prepared := make(map[string]*commandLine)
+		for i, text := range e.Commands {
+			if text == "" {
+				continue
+			}
+			line, ok := prepared[text]
+			if !ok {
+				if line, err = r.prepareCommand(text); err != nil {
+					return nil, fmt.Errorf("invalid command for %s: %w", targets[i], err)
+				}
+				prepared[text] = line
+			}
+			r.commands[i] = line
+		}
+	}
+	if e.Test != "" {
+		if r.test, err = r.prepareCommand(e.Test); err != nil {
+			return nil, fmt.Errorf("invalid test command: %w", err)
+		}
+	}
+	if e.Verify != "" {
+		if r.verify, err = r.prepareCommand(e.Verify); err != nil {
+			return nil, fmt.Errorf("invalid verify command: %w", err)
+		}
+	}
+	return r, nil
+}
+
+func (r *run) worker(id int, tasks <-chan int, done chan<- int, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for i := range tasks {
+		lo, hi := i, i+1
+		if r.Batch > 1 {
+			lo, hi = i*r.Batch, min((i+1)*r.Batch, len(r.results))
+		}
+		r.process(id, lo, hi)
+		done <- i
+	}
+}
+
+// process runs the targets from index lo up to hi, a single target unless
+// batching, on worker id unless the run is aborted first, including while
+// waiting for a launch slot.
+func (r *run) process(id, lo, hi int) {
+	if r.limiter.wait(r.ctx) != nil || r.throttle() != nil || !r.checkSpace(id, lo, hi) {
+		return
+	}
+
+	if !r.confirm(id, lo, hi) || r.ctx.Err() != nil {
+		return
+	}
+	release := func() {}
+	if r.Acquire != nil {
+		var err error
+		if release, err = r.Acquire(r.ctx); err != nil {
+			return
+		}
+	}
+
+	for i := lo; i < hi; i++ {
+		r.results[i].Skipped = false
+		if r.Metadata {
+			if m, err := ReadMetadata(r.ctx, r.results[i].Target); err == nil {
+				r.results[i].Metadata = &m
+			}
+		}
+		r.emit(Event{Type: TaskStarted, Worker: id, Target: r.results[i].Target, Index: i})
+	}
+	r.running.Add(1)
+	r.execute(id, lo, hi)
+	r.running.Add(-1)
+	release()
+
+	if result := &r.results[lo]; result.TestFailed {
+		r.emit(Event{Type: TaskSkipped, Worker: id, Target: result.Target, Index: lo, Result: result})
+		return
+	}
+
+	// Commands killed by an abort don't count towards the failure limit
+	result := &r.results[lo]
+	result.Cancelled = result.Err != nil && r.ctx.Err() != nil
+	if r.Batch > 1 {
+		for i := lo; i < hi; i++ {
+			result.Batch = append(result.Batch, r.results[i].Target)
+		}
+	}
+	for i := lo; i < hi; i++ {
+		if i > lo {
+			meta := r.results[i].Metadata
+			r.results[i] = *result
+			r.results[i].Target, r.results[i].Metadata = result.Batch[i-lo], meta
+		}
+		if result.Err != nil && !result.Cancelled {
+			r.recordFailure()
+		}
+		r.emit(Event{Type: TaskFinished, Worker: id, Target: r.results[i].Target, Index: i, Result: &r.results[i]})
+	}
+}
+
+// confirm asks Executor.Confirm whether to run the targets from index lo up
+// to hi, and marks them as skipped if not. Commands that cannot be expanded
+// are not asked about, so that execute reports their error.
+func (r *run) confirm(id, lo, hi int) bool {
+	if r.Confirm == nil {
+		return true
+	}
+	var cmdStr string
+	var err error
+	switch {
+	case r.stages != nil:
+		// Every stage is confirmed at once
+		var stages []string
+		for i, line := range r.stages {
+			var stage string
+			if _, stage, err = r.newCommand(r.ctx, line, id, lo, hi, ""); err != nil {
+				break
+			}
+			stages = append(stages, r.Stages[i].Name+": "+stage)
+		}
+		cmdStr = strings.Join(stages, "; ")
+	case r.Func == nil:
+		_, cmdStr, err = r.newCommand(r.ctx, r.commandOf(lo), id, lo, hi, "")
+	}
+	if err != nil {
+		return true
+	}
+	declined := r.Confirm(r.results[lo].Target, cmdStr)
+	if declined == nil || r.ctx.Err() != nil {
+		// Targets of a run aborted in the meantime are not declined
+		return true
+	}
+
+	for i := lo; i < hi; i++ {
+		skipped := &r.results[i]
+		skipped.SkipReason = declined.Error()
+		r.emit(Event{Type: TaskSkipped, Worker: id, Target: skipped.Target, Index: i, Result: skipped})
+	}
+	return false
+}
+
+// execute runs the command for the targets from index lo up to hi, retrying
+// failed attempts with exponential backoff, and fills in the result of the
+// first.
+func (r *run) execute(id, lo, hi int) {
+	result := &r.results[lo]
+	result.ExitCode = -1
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	result.Host = r.host(lo)
+	var dir string
+	var err error
+	if r.Func == nil || r.test != nil || r.verify != nil {
+		// Functions need no working directory, nor their targets be paths
+		if dir, err = r.workDir(result.Target); err != nil {
+			result.Err = err
+			return
+		}
+	}
+	if r.scratch != nil {
+		if r.scratch[lo], err = os.MkdirTemp("", "executor-task-"); err != nil {
+			result.Err = fmt.Errorf("cannot create scratch directory: %w", err)
+			return
+		}
+		defer r.removeScratch(lo, result)
+	}
+	if r.test != nil && !r.runTest(id, lo, dir, result) {
+		return
+	}
+	if r.stages != nil {
+		r.runStages(id, lo, dir, result)
+		return
+	}
+	r.retry(id, lo, hi, dir, r.commandOf(lo), result)
+}
+
+// runStages runs the stages of the pipeline in order for the target at
+// index in dir until one fails, recording each in result, whose output
+// becomes that of all of them.
+func (r *run) runStages(id, index int, dir string, result *Result) {
+	var output, stdout, stderr []byte
+	var stdoutDropped, stderrDropped int64
+	attempts := 0
+	defer func() {
+		result.Output, result.Stdout, result.Stderr = output, stdout, stderr
+		result.StdoutDropped, result.StderrDropped = stdoutDropped, stderrDropped
+		result.Attempts = attempts
+	}()
+
+	for i, line := range r.stages {
+		name := r.Stages[i].Name
+		start := time.Now()
+		result.Err, result.Attempts = nil, 0
+		started := r.retry(id, index, index+1, dir, line, result)
+
+		output = append(output, result.Output...)
+		stdout = append(stdout, result.Stdout...)
+		stderr = append(stderr, result.Stderr...)
+		stdoutDropped += result.StdoutDropped
+		stderrDropped += result.StderrDropped
+		attempts += result.Attempts
+		result.Stages = append(result.Stages, StageResult{
+			Name:     name,
+			Command:  result.Command,
+			Err:      result.Err,
+			ExitCode: result.ExitCode,
+			TimedOut: result.TimedOut,
+			Attempts: result.Attempts,
+			Duration: time.Since(start),
+		})
+		if !started || result.Err != nil {
+			if result.Err != nil {
+				result.Err = fmt.Errorf("stage %s: %w", name, result.Err)
+			}
+			return
+		}
+	}
+}
+
+// retry runs line for the targets from index lo up to hi in dir, recording
+// the outcome in result, and runs it again while it fails and
+// Executor.Retries allows. It returns false if the command could not be
+// started.
+func (r *run) retry(id, lo, hi int, dir string, line *commandLine, result *Result) bool {
+	for attempt := 1; ; attempt++ {
+		if !r.attempt(id, lo, hi, dir, line, result) {
+			return false
+		}
+		result.Attempts = attempt
+		if result.Err == nil || attempt > r.Retries || r.ctx.Err() != nil {
+			return true
+		}
+
+		delay := r.RetryDelay << (attempt - 1)
+		for i := lo; i < hi; i++ {
+			r.emit(Event{Type: TaskRetrying, Worker: id, Target: r.results[i].Target, Index: i, Attempt: attempt, Delay: delay, Err: result.Err})
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.ctx.Done():
+		}
+	}
+}
+
+// removeScratch removes the scratch directory of the task at index, unless
+// it is to be kept because the task failed.
+func (r *run) removeScratch(index int, result *Result) {
+	if r.KeepFailedScratch && result.Err != nil && r.ctx.Err() == nil {
+		result.Scratch = r.scratch[index]
+		return
+	}
+	os.RemoveAll(r.scratch[index])
+}
+
+// commandOf returns the command to run for the target at index.
+func (r *run) commandOf(index int) *commandLine {
+	if r.commands != nil && r.commands[index] != nil {
+		return r.commands[index]
+	}
+	return r.command
+}
+
+// workDir returns the working directory for the command of target as
+// selected by Executor.WorkDir.
+func (r *run) workDir(target string) (string, error) {
+	if r.Hosts != nil {
+		// Remote targets can't be examined locally, so their commands stay
+		// in the home directory by default
+		switch r.WorkDir {
+		case "", WorkDirTarget, WorkDirCurrent:
+			return "", nil
+		}
+	}
+
+	switch r.WorkDir {
+	case WorkDirCurrent:
+		return "", nil
+	case WorkDirParent:
+		return filepath.Dir(target), nil
+	case "", WorkDirTarget:
+		if r.WorkDir == "" && r.Batch > 1 {
+			return "", nil
+		}
+	default:
+		return expand(r.WorkDir, target, nil), nil
+	}
+
+	// If target is a directory, set working directory
+	// If target is a file, set working directory to its parent
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot stat %s: %w", target, err)
+	}
+	if !info.IsDir() {
+		return filepath.Dir(target), nil
+	}
+	return target, nil
+}
+
+// runTest runs the test command for the target at index in dir. It returns
+// whether the main command should run; if not, result records why.
+func (r *run) runTest(id, index int, dir string, result *Result) bool {
+	cmd, cmdStr, err := r.newCommand(r.ctx, r.test, id, index, index+1, dir)
+	if err != nil {
+		result.Err = fmt.Errorf("cannot expand test command: %w", err)
+		return false
+	}
+
+	var test Result
+	r.runProcess(r.ctx, cmd, &test, nil)
+	var exitErr *exec.ExitError
+	switch {
+	case test.Err == nil:
+		return true
+	case errors.As(test.Err, &exitErr) && r.ctx.Err() == nil:
+		result.Command = cmdStr
+		result.Skipped = true
+		result.SkipReason = "test failed: " + test.Err.Error()
+		result.TestFailed = true
+	default:
+		result.Err = fmt.Errorf("test command: %w", test.Err)
+	}
+	return false
+}
+
+// attempt runs line, or Executor.Func, once for the targets from index lo up
+// to hi and records the outcome in result, enforcing Executor.Timeout. It
+// returns false if the command could not be built or its input opened.
+func (r *run) attempt(id, lo, hi int, dir string, line *commandLine, result *Result) bool {
+	ctx, cancel := r.ctx, context.CancelFunc(func() {})
+	if r.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(r.ctx, r.Timeout)
+	}
+	defer cancel()
+
+	if r.Func != nil {
+		r.call(ctx, result)
+	} else if !r.runCommand(ctx, id, lo, hi, dir, line, result) {
+		return false
+	}
+
+	result.TimedOut = ctx.Err() == context.DeadlineExceeded && r.ctx.Err() == nil
+	if result.TimedOut {
+		result.Err = fmt.Errorf("timed out after %s", r.Timeout)
+	}
+	result.VerifyFailed = false
+	if result.Err == nil && r.verify != nil {
+		r.runVerify(id, lo, hi, dir, result)
+	}
+	return true
+}
+
+// runVerify runs the verify command for the targets from index lo up to hi
+// in dir, and fails result if it does.
+func (r *run) runVerify(id, lo, hi int, dir string, result *Result) {
+	cmd, _, err := r.newCommand(r.ctx, r.verify, id, lo, hi, dir)
+	if err != nil {
+		result.Err = fmt.Errorf("cannot expand verify command: %w", err)
+		return
+	}
+
+	var verify Result
+	r.runProcess(r.ctx, cmd, &verify, nil)
+	if verify.Err != nil {
+		result.Err = fmt.Errorf("verification failed: %w", verify.Err)
+		result.VerifyFailed = r.ctx.Err() == nil
+	}
+}
+
+// call runs Executor.Func for the target of result with ctx. A panic is
+// recorded as its error rather than bringing down the run.
+func (r *run) call(ctx context.Context, result *Result) {
+	defer func() {
+		if p := recover(); p != nil {
+			result.Err = fmt.Errorf("panic: %v", p)
+			result.ExitCode = -1
+		}
+	}()
+	result.Err = r.Func(ctx, result.Target)
+	result.ExitCode = 0
+	if result.Err != nil {
+		result.ExitCode = -1
+	}
+}
+
+// runCommand runs line for the targets from index lo up to hi with ctx and
+// records its outcome in result. It returns false if the command could not
+// be built or its input opened.
+func (r *run) runCommand(ctx context.Context, id, lo, hi int, dir string, line *commandLine, result *Result) bool {
+	cmd, cmdStr, err := r.newCommand(ctx, line, id, lo, hi, dir)
+	if err != nil {
+		result.Err = fmt.Errorf("cannot expand command: %w", err)
+		return false
+	}
+	result.Command = cmdStr
+
+	switch {
+	case r.PipeTarget:
+		file, err := openInput(result.Target)
+		if err != nil {
+			result.Err = fmt.Errorf("cannot pipe target: %w", err)
+			return false
+		}
+		defer file.Close()
+		cmd.Stdin = file
+	case r.Input != nil:
+		cmd.Stdin = bytes.NewReader(r.Input)
+	}
+
+	if r.SaveOutput != nil {
+		stdout, stderr := r.SaveOutput(result.Target, lo)
+		if stdout != nil {
+			defer stdout.Close()
+			cmd.Stdout = stdout
+		}
+		if stderr != nil {
+			defer stderr.Close()
+			cmd.Stderr = stderr
+		}
+	}
+
+	var stream func(line []byte, stderr bool)
+	if r.Stream {
+		stream = func(line []byte, stderr bool) {
+			r.emit(Event{Type: TaskOutput, Worker: id, Target: result.Target, Index: lo, Line: r.decode(line), Stderr: stderr})
+		}
+	}
+	r.runProcess(ctx, cmd, result, stream)
+	return true
+}
+
+// openInput opens the file at path to be piped to a command.
+func openInput(path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		file.Close()
+		if err == nil {
+			err = fmt.Errorf("%s is a directory", path)
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// recordFailure counts a failed target and cancels the run if the failure
+// limit is reached.
+func (r *run) recordFailure() {
+	failures := r.failures.Add(1)
+	if r.MaxFailures > 0 && int(failures) >= r.MaxFailures {
+		r.cancel()
+	}
+}
+
+func (r *run) emit(ev Event) {
+	if r.OnEvent != nil {
+		r.OnEvent(ev)
+	}
+}
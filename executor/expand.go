@@ -0,0 +1,277 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Expand substitutes the path placeholders in command for target:
+//
+//	{}      the target path as matched
+//	{dir}   the parent directory of the target
+//	{base}  the last element of the target path
+//	{name}  the last element without its extension
+//	{ext}   the extension including the leading dot (empty if none)
+//	{abs}   the absolute target path
+//	{raw}   the target path as matched, never quoted
+//
+// The values are substituted verbatim. Run quotes all of them but {raw} for
+// the shell.
+func Expand(command, target string) string {
+	return expand(command, target, nil)
+}
+
+// expand substitutes the placeholders in command, passing each value through
+// quote if it is not nil.
+func expand(command, target string, quote func(string) string) string {
+	return expandBatch(command, []TemplateData{newTemplateData(target, 0, 0)}, quote)
+}
+
+// expandBatch substitutes the placeholders in command for several targets at
+// once: each placeholder is replaced with its values for all targets,
+// separated by spaces and, but for {raw}, passed through quote if it is not
+// nil.
+func expandBatch(command string, data []TemplateData, quote func(string) string) string {
+	if quote == nil {
+		quote = func(s string) string { return s }
+	}
+	values := make(map[string][]string)
+	for _, d := range data {
+		for placeholder, value := range d.placeholders() {
+			if placeholder != "{raw}" {
+				value = quote(value)
+			}
+			values[placeholder] = append(values[placeholder], value)
+		}
+	}
+
+	// Placeholders are delimited by braces, so none is a prefix of another
+	// and the order of replacement doesn't matter
+	var oldnew []string
+	for placeholder, list := range values {
+		oldnew = append(oldnew, placeholder, strings.Join(list, " "))
+	}
+	return strings.NewReplacer(oldnew...).Replace(command)
+}
+
+// hasPlaceholder reports whether text contains any of the placeholders of d.
+func hasPlaceholder(text string, d TemplateData) bool {
+	for placeholder := range d.placeholders() {
+		if strings.Contains(text, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// TemplateData is the data available to commands written as Go templates,
+// e.g. "convert {{.Path}} {{.Name}}-{{.Index}}.png". Its methods give the
+// Metadata of the target, read when a template first asks for it, such as
+// "git -C {{quote .Path}} push origin {{.GitBranch}}".
+type TemplateData struct {
+	Path  string // the target path as matched
+	Dir   string // the parent directory of the target
+	Base  string // the last element of the target path
+	Name  string // the last element without its extension
+	Ext   string // the extension including the leading dot
+	Abs   string // the absolute target path
+	Index int    // the position of the target, counting from 1
+	Total int    // the number of targets in the run
+	Tmp   string // the scratch directory of the task with Executor.Scratch
+
+	// Columns holds the extra values of the target from Executor.Columns,
+	// and Column the same values by their Executor.ColumnNames.
+	Columns []string
+	Column  map[string]string
+
+	// meta gives the metadata of the target, for the methods that templates
+	// call as {{.Size}}, {{.GitBranch}} and so on; nil leaves them empty.
+	meta func() Metadata
+}
+
+// metadata returns the metadata of the target, or none without d.meta.
+func (d TemplateData) metadata() Metadata {
+	if d.meta == nil {
+		return Metadata{}
+	}
+	return d.meta()
+}
+
+// Size returns the size of the target in bytes.
+func (d TemplateData) Size() int64 { return d.metadata().Size }
+
+// ModTime returns when the target was last modified.
+func (d TemplateData) ModTime() time.Time { return d.metadata().ModTime }
+
+// Owner returns the name of the user owning the target.
+func (d TemplateData) Owner() string { return d.metadata().Owner }
+
+// GitBranch returns the branch checked out when the target is a git
+// repository, and "" otherwise.
+func (d TemplateData) GitBranch() string { return d.metadata().GitBranch }
+
+// GitDirty reports whether the target is a git repository with uncommitted
+// changes.
+func (d TemplateData) GitDirty() bool { return d.metadata().GitDirty }
+
+func newTemplateData(target string, index, total int) TemplateData {
+	base := filepath.Base(target)
+	ext := filepath.Ext(base)
+
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		abs = target
+	}
+
+	return TemplateData{
+		Path:  target,
+		Dir:   filepath.Dir(target),
+		Base:  base,
+		Name:  strings.TrimSuffix(base, ext),
+		Ext:   ext,
+		Abs:   abs,
+		Index: index + 1,
+		Total: total,
+	}
+}
+
+// builtinPlaceholders lists the placeholders defined by executor itself.
+var builtinPlaceholders = []string{"{}", "{dir}", "{base}", "{name}", "{ext}", "{abs}", "{raw}", "{tmp}"}
+
+// placeholders returns the placeholders of the target with their values:
+// the built-in ones, and its columns as {1}, {2}, ... and by name.
+func (d TemplateData) placeholders() map[string]string {
+	values := map[string]string{
+		"{}":     d.Path,
+		"{dir}":  d.Dir,
+		"{base}": d.Base,
+		"{name}": d.Name,
+		"{ext}":  d.Ext,
+		"{abs}":  d.Abs,
+		"{raw}":  d.Path,
+	}
+	if d.Tmp != "" {
+		values["{tmp}"] = d.Tmp
+	}
+	for i, value := range d.Columns {
+		values["{"+strconv.Itoa(i+1)+"}"] = value
+	}
+	for name, value := range d.Column {
+		values["{"+name+"}"] = value
+	}
+	return values
+}
+
+// columnTable holds the extra values of the targets of a run.
+type columnTable struct {
+	names []string
+	rows  [][]string
+}
+
+// data returns the template data for the target at index, including its
+// columns. A nil table has no columns.
+func (c *columnTable) data(target string, index, total int) TemplateData {
+	d := newTemplateData(target, index, total)
+	if c == nil || index >= len(c.rows) {
+		return d
+	}
+
+	d.Columns = c.rows[index]
+	if len(c.names) > 0 {
+		d.Column = make(map[string]string, len(c.names))
+		for i, name := range c.names {
+			if i < len(d.Columns) {
+				d.Column[name] = d.Columns[i]
+			}
+		}
+	}
+	return d
+}
+
+// validColumnNames checks that names can be used as placeholders.
+func validColumnNames(names []string) error {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		placeholder := "{" + name + "}"
+		_, numeric := strconv.Atoi(name)
+		switch {
+		case name == "" || strings.ContainsAny(name, "{}"):
+			return fmt.Errorf("invalid column name %q", name)
+		case numeric == nil || slices.Contains(builtinPlaceholders, placeholder):
+			return fmt.Errorf("column name %q clashes with the %s placeholder", name, placeholder)
+		case seen[name]:
+			return fmt.Errorf("duplicate column name %q", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// expander produces the command text for a target.
+type expander func(d TemplateData) (string, error)
+
+// newExpander prepares text for expansion. Text containing "{{" is a Go
+// template executed with TemplateData; its values are inserted verbatim and
+// can be quoted with the quote function. Other text uses the {} placeholders
+// with every value passed through quote. A nil quote means no quoting.
+func newExpander(text string, quote func(string) string) (expander, error) {
+	if !strings.Contains(text, "{{") {
+		return func(d TemplateData) (string, error) {
+			return expandBatch(text, []TemplateData{d}, quote), nil
+		}, nil
+	}
+
+	if quote == nil {
+		quote = posixQuote
+	}
+	tmpl, err := template.New("command").Funcs(template.FuncMap{
+		"quote":   quote,
+		"relpath": relpath,
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(d TemplateData) (string, error) {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, d); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}, nil
+}
+
+// relpath is the template function that makes a path relative: to the
+// current directory when called with one argument, or to the first argument
+// when called with two.
+func relpath(paths ...string) (string, error) {
+	var base, target string
+	switch len(paths) {
+	case 1:
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		base, target = wd, paths[0]
+	case 2:
+		base, target = paths[0], paths[1]
+	default:
+		return "", fmt.Errorf("relpath takes 1 or 2 arguments, got %d", len(paths))
+	}
+
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absBase, absTarget)
+}
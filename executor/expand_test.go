@@ -0,0 +1,117 @@
+package executor
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandQuotesPlaceholders(t *testing.T) {
+	tests := []struct {
+		target string
+		quote  func(string) string
+		want   string
+	}{
+		{"plain.txt", posixQuote, "cat plain.txt # plain.txt"},
+		{"with space.txt", posixQuote, "cat 'with space.txt' # with space.txt"},
+		{"it's.txt", posixQuote, `cat 'it'\''s.txt' # it's.txt`},
+		{`say "hi".txt`, posixQuote, `cat 'say "hi".txt' # say "hi".txt`},
+		{"$(touch pwned).txt", posixQuote, "cat '$(touch pwned).txt' # $(touch pwned).txt"},
+		{"`touch pwned`.txt", posixQuote, "cat '`touch pwned`.txt' # `touch pwned`.txt"},
+		{"line\nbreak.txt", posixQuote, "cat 'line\nbreak.txt' # line\nbreak.txt"},
+		{"!PATH!.txt", posixQuote, "cat '!PATH!.txt' # !PATH!.txt"},
+		{"@list.txt", posixQuote, "cat @list.txt # @list.txt"},
+		{"-rf", posixQuote, "cat -rf # -rf"},
+		{"with space.txt", cmdQuote, `cat "with space.txt" # with space.txt`},
+		{`say "hi".txt`, cmdQuote, `cat "say ""hi"".txt" # say "hi".txt`},
+		{"%PATH%.txt", cmdQuote, `cat ^%"PATH"^%".txt" # %PATH%.txt`},
+		{"%COMSPEC%", cmdQuote, `cat ^%"COMSPEC"^% # %COMSPEC%`},
+		{"!PATH!.txt", cmdQuote, `cat ^!"PATH"^!".txt" # !PATH!.txt`},
+		{"it's.txt", powershellQuote, "cat 'it''s.txt' # it's.txt"},
+		{"$(touch pwned).txt", powershellQuote, "cat '$(touch pwned).txt' # $(touch pwned).txt"},
+		{"@list.txt", powershellQuote, "cat '@list.txt' # @list.txt"},
+		{"%PATH%.txt", powershellQuote, "cat '%PATH%.txt' # %PATH%.txt"},
+	}
+	for _, tt := range tests {
+		if got := expand("cat {} # {raw}", tt.target, tt.quote); got != tt.want {
+			t.Errorf("expand(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	abs, err := filepath.Abs(filepath.Join("some dir", "my file.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join("some dir", "my file.tar.gz")
+	tests := []struct {
+		command, want string
+	}{
+		{"{}", target},
+		{"{raw}", target},
+		{"{dir}", "some dir"},
+		{"{base}", "my file.tar.gz"},
+		{"{name}", "my file.tar"},
+		{"{ext}", ".gz"},
+		{"{abs}", abs},
+		{"{base}{ext}", "my file.tar.gz.gz"},
+		{"{{}}", "{" + target + "}"},
+		{"{unknown}", "{unknown}"},
+	}
+	for _, tt := range tests {
+		if got := Expand(tt.command, target); got != tt.want {
+			t.Errorf("Expand(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestExpandEmptyTarget(t *testing.T) {
+	if got, want := expand("touch {} {raw}.", "", posixQuote), "touch '' ."; got != want {
+		t.Errorf("expand with an empty target = %q, want %q", got, want)
+	}
+	if got, want := expand("touch {}", "", cmdQuote), `touch ""`; got != want {
+		t.Errorf("expand with an empty target = %q, want %q", got, want)
+	}
+}
+
+func TestExpandBatch(t *testing.T) {
+	data := []TemplateData{
+		newTemplateData("a b.txt", 0, 2),
+		newTemplateData("$(x).txt", 1, 2),
+	}
+	want := "cat 'a b.txt' '$(x).txt' # a b.txt $(x).txt"
+	if got := expandBatch("cat {} # {raw}", data, posixQuote); got != want {
+		t.Errorf("expandBatch = %q, want %q", got, want)
+	}
+}
+
+func TestExpanderTemplate(t *testing.T) {
+	tests := []struct {
+		text   string
+		target string
+		quote  func(string) string
+		want   string
+	}{
+		{"cat {{quote .Path}}", "it's.txt", posixQuote, `cat 'it'\''s.txt'`},
+		{"cat {{quote .Path}}", "%PATH%.txt", cmdQuote, `cat ^%"PATH"^%".txt"`},
+		{"cat {{quote .Path}}", "$(x).txt", powershellQuote, "cat '$(x).txt'"},
+		{"cat {{quote .Path}}", "a b", nil, "cat 'a b'"},
+		{"cat {{.Path}}", "a b", posixQuote, "cat a b"},
+		{"cat {{.Base}} {{.Index}}/{{.Total}}", "dir/a.txt", posixQuote, "cat a.txt 1/1"},
+	}
+	for _, tt := range tests {
+		exp, err := newExpander(tt.text, tt.quote)
+		if err != nil {
+			t.Errorf("newExpander(%q): %v", tt.text, err)
+			continue
+		}
+		got, err := exp(newTemplateData(tt.target, 0, 1))
+		if err != nil {
+			t.Errorf("expanding %q for %q: %v", tt.text, tt.target, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expanding %q for %q = %q, want %q", tt.text, tt.target, got, tt.want)
+		}
+	}
+}
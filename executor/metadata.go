@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metadata describes a target beyond its path, for commands written as
+// templates and, with Executor.Metadata, for the results.
+type Metadata struct {
+	Size    int64     // the size of the target in bytes, as stat(2) reports it
+	ModTime time.Time // when the target was last modified
+	Owner   string    // the name of the user owning the target, or its ID
+
+	// GitBranch is the branch checked out when the target is the root of a
+	// git repository, or the commit for a detached HEAD, and GitDirty is set
+	// if the repository has changes that aren't committed.
+	GitBranch string
+	GitDirty  bool
+}
+
+// ReadMetadata returns the metadata of target, running git to find out
+// whether a repository is dirty. It fails if target cannot be stat'ed;
+// failures to read the git state only leave it out.
+func ReadMetadata(ctx context.Context, target string) (Metadata, error) {
+	info, err := os.Stat(target)
+	if err != nil {
+		return Metadata{}, err
+	}
+	m := Metadata{Size: info.Size(), ModTime: info.ModTime(), Owner: fileOwner(info)}
+	if info.IsDir() {
+		m.GitBranch = gitBranch(target)
+	}
+	if m.GitBranch != "" {
+		cmd := exec.CommandContext(ctx, "git", "status", "--porcelain", "--untracked-files=no")
+		cmd.Dir = target
+		if out, err := cmd.Output(); err == nil {
+			m.GitDirty = len(bytes.TrimSpace(out)) > 0
+		}
+	}
+	return m, nil
+}
+
+// gitBranch reads the branch checked out in the git repository at dir from
+// its HEAD, which is faster than asking git. It returns "" if dir is not the
+// root of a repository.
+func gitBranch(dir string) string {
+	gitDir := filepath.Join(dir, ".git")
+	info, err := os.Stat(gitDir)
+	if err != nil {
+		return ""
+	}
+	if !info.IsDir() {
+		// Worktrees and submodules point to their git directory
+		data, err := os.ReadFile(gitDir)
+		path, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+		if err != nil || !ok {
+			return ""
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		gitDir = path
+	}
+
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	head := strings.TrimSpace(string(data))
+	if ref, ok := strings.CutPrefix(head, "ref: "); ok {
+		return strings.TrimPrefix(ref, "refs/heads/")
+	}
+	return head
+}
+
+// metadataOf returns the function giving the metadata of the target at index
+// to its template data: that in its result with Executor.Metadata, and
+// otherwise read with ctx the first time a template asks for it.
+func (r *run) metadataOf(ctx context.Context, index int) func() Metadata {
+	if m := r.results[index].Metadata; m != nil {
+		return func() Metadata { return *m }
+	}
+	target := r.results[index].Target
+	return sync.OnceValue(func() Metadata {
+		m, _ := ReadMetadata(ctx, target)
+		return m
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package executor
+
+import "os"
+
+// fileOwner returns "", as files have no owning user ID on these platforms.
+func fileOwner(info os.FileInfo) string {
+	return ""
+}
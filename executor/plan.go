@@ -0,0 +1,141 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Task is the work Run would do for one target, or a batch of them, as
+// planned by Plan.
+type Task struct {
+	// Targets lists the targets of the task, several when batching.
+	Targets []string
+
+	// Dir is the working directory of the commands, or "" for the current
+	// one.
+	Dir string
+
+	// Test is the expanded Executor.Test command, or "" without one. The
+	// other commands only run if it succeeds.
+	Test string
+
+	// Commands are the expanded commands to run in order, each only if the
+	// one before succeeded: those of the Executor.Stages, or the command
+	// followed by the Executor.Verify command, if any.
+	Commands []string
+
+	// Stdin is the file fed to the commands with Executor.PipeTarget, or "".
+	Stdin string
+
+	// After lists the positions in the plan of the tasks that must succeed
+	// before this one, because of Executor.Deps.
+	After []int
+}
+
+// Plan expands the commands that Run would run for targets, written for
+// Shell, without running them. The tasks come in an order in which the
+// prerequisites of each, listed in its After, come first. Plan cannot be
+// used with a function, remote hosts, containers, scratch directories, a
+// sandbox or Input, whose commands only make sense as Run runs them.
+func (e *Executor) Plan(targets []string, command string) ([]Task, error) {
+	if e.Func != nil || e.Hosts != nil || e.Container != nil || e.Scratch || e.Sandbox || e.Input != nil {
+		return nil, errors.New("cannot plan commands with a function, remote hosts, containers, scratch directories, a sandbox or input")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := e.newRun(ctx, cancel, targets, command)
+	if err != nil {
+		return nil, err
+	}
+
+	var graph *depGraph
+	if e.Batch > 1 {
+		graph = emptyDepGraph((len(targets) + e.Batch - 1) / e.Batch)
+	} else {
+		if graph, err = newDepGraph(targets, e.Deps); err != nil {
+			return nil, err
+		}
+	}
+
+	// Visit the tasks as the scheduler would hand them out, one at a time
+	order := make([]int, 0, len(graph.pending))
+	pending := append([]int(nil), graph.pending...)
+	for i, n := range pending {
+		if n == 0 {
+			order = append(order, i)
+		}
+	}
+	for k := 0; k < len(order); k++ {
+		for _, d := range graph.dependents[order[k]] {
+			if pending[d]--; pending[d] == 0 {
+				order = append(order, d)
+			}
+		}
+	}
+
+	position := make([]int, len(order))
+	for k, i := range order {
+		position[i] = k
+	}
+	tasks := make([]Task, len(order))
+	for k, i := range order {
+		lo, hi := i, i+1
+		if e.Batch > 1 {
+			lo, hi = i*e.Batch, min((i+1)*e.Batch, len(targets))
+		}
+		if tasks[k], err = r.plan(lo, hi); err != nil {
+			return nil, fmt.Errorf("%s: %w", targets[lo], err)
+		}
+	}
+	for k, i := range order {
+		for _, d := range graph.dependents[i] {
+			tasks[position[d]].After = append(tasks[position[d]].After, k)
+		}
+	}
+	return tasks, nil
+}
+
+// plan expands the commands for the targets from index lo up to hi.
+func (r *run) plan(lo, hi int) (Task, error) {
+	task := Task{Targets: make([]string, 0, hi-lo)}
+	var err error
+	for i := lo; i < hi; i++ {
+		task.Targets = append(task.Targets, r.results[i].Target)
+	}
+	if r.PipeTarget {
+		// The commands read it from their own directory
+		if task.Stdin, err = filepath.Abs(task.Targets[0]); err != nil {
+			return Task{}, err
+		}
+	}
+
+	if task.Dir, err = r.workDir(task.Targets[0]); err != nil {
+		return Task{}, err
+	}
+	expand := func(line *commandLine) (string, error) {
+		_, cmdStr, err := r.newCommand(r.ctx, line, 0, lo, hi, task.Dir)
+		return cmdStr, err
+	}
+	if r.test != nil {
+		if task.Test, err = expand(r.test); err != nil {
+			return Task{}, fmt.Errorf("cannot expand test command: %w", err)
+		}
+	}
+	lines := r.stages
+	if lines == nil {
+		lines = []*commandLine{r.commandOf(lo)}
+		if r.verify != nil {
+			lines = append(lines, r.verify)
+		}
+	}
+	for _, line := range lines {
+		cmdStr, err := expand(line)
+		if err != nil {
+			return Task{}, fmt.Errorf("cannot expand command: %w", err)
+		}
+		task.Commands = append(task.Commands, cmdStr)
+	}
+	return task, nil
+}
@@ -0,0 +1,198 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// DefaultGracePeriod is how long a stopped command may take to exit when
+// Executor.GracePeriod is zero.
+const DefaultGracePeriod = 5 * time.Second
+
+// Interrupted is the cancellation cause to use when the context passed to
+// Run is cancelled because the process received a signal, e.g. with
+// context.WithCancelCause. Run forwards Signal to the running commands
+// instead of the default stop signal.
+type Interrupted struct {
+	Signal os.Signal
+}
+
+func (i Interrupted) Error() string {
+	return fmt.Sprintf("interrupted by %s", i.Signal)
+}
+
+// stopSignal returns the signal to send to running commands when ctx is
+// cancelled.
+func stopSignal(ctx context.Context) os.Signal {
+	var interrupted Interrupted
+	if errors.As(context.Cause(ctx), &interrupted) {
+		return interrupted.Signal
+	}
+	return defaultStopSignal
+}
+
+// runProcess runs cmd, which was created with ctx, and records its output and
+// exit status in result, within Executor.MaxOutput. Writers already set as
+// cmd.Stdout and cmd.Stderr receive the complete output. If stream is not
+// nil, it is also called with each line of output as it arrives. When ctx is
+// cancelled, the command and any processes it started receive the stop
+// signal, and are killed if they are still running after the grace period.
+func (r *run) runProcess(ctx context.Context, cmd *exec.Cmd, result *Result, stream func(line []byte, stderr bool)) {
+	grace := r.GracePeriod
+	if grace == 0 {
+		grace = DefaultGracePeriod
+	}
+
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return signalProcessGroup(cmd.Process, stopSignal(r.ctx))
+	}
+	cmd.WaitDelay = grace
+
+	// Keep the streams apart as well as interleaved in arrival order
+	stdout, stderr := r.newOutputBuffer(), r.newOutputBuffer()
+	combined := &lockedBuffer{buf: r.newOutputBuffer()}
+	stdoutWriters := []io.Writer{stdout, combined}
+	stderrWriters := []io.Writer{stderr, combined}
+	if cmd.Stdout != nil {
+		stdoutWriters = append(stdoutWriters, cmd.Stdout)
+	}
+	if cmd.Stderr != nil {
+		stderrWriters = append(stderrWriters, cmd.Stderr)
+	}
+	cmd.Stdout = io.MultiWriter(stdoutWriters...)
+	cmd.Stderr = io.MultiWriter(stderrWriters...)
+	if stream != nil {
+		stdoutLines := &lineWriter{emit: func(line []byte) { stream(line, false) }}
+		stderrLines := &lineWriter{emit: func(line []byte) { stream(line, true) }}
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, stdoutLines)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, stderrLines)
+		defer stdoutLines.flush()
+		defer stderrLines.flush()
+	}
+
+	result.Err = cmd.Run()
+	result.Output = r.decode(combined.Bytes())
+	result.Stdout = r.decode(stdout.Bytes())
+	result.Stderr = r.decode(stderr.Bytes())
+	result.StdoutDropped = stdout.dropped()
+	result.StderrDropped = stderr.dropped()
+	result.ExitCode = -1
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+		result.CPUTime += cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+	}
+
+	if ctx.Err() != nil && cmd.Process != nil {
+		// Don't leave behind children that outlived the command itself
+		killProcessGroup(cmd.Process)
+	}
+}
+
+// newOutputBuffer returns a buffer for the output of a command that keeps
+// what Executor.MaxOutput allows.
+func (r *run) newOutputBuffer() *cappedBuffer {
+	return &cappedBuffer{limit: r.MaxOutput, tail: r.KeepOutputTail}
+}
+
+// decode converts output with Executor.Decode, if set.
+func (r *run) decode(output []byte) []byte {
+	if r.Decode == nil || len(output) == 0 {
+		return output
+	}
+	return r.Decode(output)
+}
+
+// cappedBuffer keeps at most limit bytes of what is written to it: the first
+// ones, or the last ones if tail is set. A limit of zero keeps everything.
+type cappedBuffer struct {
+	limit int
+	tail  bool
+	buf   []byte
+	total int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	switch {
+	case b.limit <= 0:
+		b.buf = append(b.buf, p...)
+	case b.tail:
+		b.buf = append(b.buf, p...)
+		// Let the buffer grow to twice the limit before moving the tail
+		// back, so each byte is moved at most once on average
+		if len(b.buf) > 2*b.limit {
+			b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.limit:]...)
+		}
+	default:
+		room := b.limit - len(b.buf)
+		b.buf = append(b.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns the bytes kept.
+func (b *cappedBuffer) Bytes() []byte {
+	if b.limit > 0 && len(b.buf) > b.limit {
+		return b.buf[len(b.buf)-b.limit:]
+	}
+	return b.buf
+}
+
+// dropped returns the number of bytes written but not kept.
+func (b *cappedBuffer) dropped() int64 {
+	return b.total - int64(len(b.Bytes()))
+}
+
+// lockedBuffer is a buffer that stdout and stderr can be copied into
+// concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf *cappedBuffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+// lineWriter passes each complete line written to it to emit, without the
+// line ending. A final line without one is passed on by flush.
+type lineWriter struct {
+	buf  []byte
+	emit func(line []byte)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(bytes.TrimSuffix(bytes.Clone(w.buf[:i]), []byte("\r")))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !unix
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Signals other than Kill cannot be delivered on these platforms.
+var defaultStopSignal = os.Kill
+
+// setProcessGroup is a no-op where process groups are not supported.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup kills p, the only way to stop it on these platforms.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	return p.Kill()
+}
+
+// killProcessGroup is a no-op; p was already killed when it was signalled.
+func killProcessGroup(p *os.Process) {}
+
+const limitsSupported = false
+
+// idleIOCommand returns nil, as there is no command to lower the IO
+// priority of another on these platforms.
+func idleIOCommand() []string { return nil }
+
+// limitProcess is a no-op; Run rejects limits on these platforms.
+func limitProcess(cmd *exec.Cmd, nice int, idleIO bool, cpu time.Duration, mem int64) {}
@@ -0,0 +1,84 @@
+//go:build unix
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultStopSignal = syscall.SIGTERM
+
+// setProcessGroup starts cmd in a process group of its own, so signals can be
+// delivered to everything it spawns and a Ctrl-C in the terminal reaches
+// executor alone, which then decides how to stop its commands.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalProcessGroup sends sig to the process group led by p.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
+	}
+	return syscall.Kill(-p.Pid, s)
+}
+
+// killProcessGroup kills whatever is left of the process group led by p.
+func killProcessGroup(p *os.Process) {
+	syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
+
+// limitsSupported reports whether limitProcess can apply limits.
+const limitsSupported = true
+
+// idleIOCommand returns the command prefix that runs a command at idle IO
+// priority, or nil where there is none: ionice(1) on Linux and taskpolicy(8)
+// on macOS.
+func idleIOCommand() []string {
+	switch runtime.GOOS {
+	case "linux":
+		return []string{"ionice", "-c", "3"}
+	case "darwin":
+		return []string{"taskpolicy", "-d", "throttle"}
+	}
+	return nil
+}
+
+// limitProcess makes cmd run with the nice value, IO priority and resource
+// limits of Executor, by starting it through a shell that applies them
+// first.
+func limitProcess(cmd *exec.Cmd, nice int, idleIO bool, cpu time.Duration, mem int64) {
+	if cmd.Err != nil || (nice == 0 && !idleIO && cpu <= 0 && mem <= 0) {
+		return
+	}
+
+	var script strings.Builder
+	if cpu > 0 {
+		seconds := (cpu + time.Second - 1) / time.Second
+		fmt.Fprintf(&script, "ulimit -t %d || exit 126; ", seconds)
+	}
+	if mem > 0 {
+		fmt.Fprintf(&script, "ulimit -v %d || exit 126; ", (mem+1023)/1024)
+	}
+	script.WriteString("exec ")
+	if idleIO {
+		script.WriteString(strings.Join(idleIOCommand(), " ") + " ")
+	}
+	if nice != 0 {
+		fmt.Fprintf(&script, "nice -n %d ", nice)
+	}
+	script.WriteString(`"$@"`)
+
+	cmd.Args = append([]string{"/bin/sh", "-c", script.String(), "executor", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+}
@@ -0,0 +1,149 @@
+package executor
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// shellQuoter returns the function that quotes a substituted value so that
+// the given shell treats it as a single literal word.
+func shellQuoter(shell []string) func(string) string {
+	switch strings.TrimSuffix(strings.ToLower(filepath.Base(shell[0])), ".exe") {
+	case "cmd":
+		return cmdQuote
+	case "powershell", "pwsh":
+		return powershellQuote
+	default:
+		return posixQuote
+	}
+}
+
+// isSafeWord reports whether s needs no quoting in any supported shell.
+func isSafeWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("_@%+=:,./-", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// posixQuote quotes s for sh and compatible shells.
+func posixQuote(s string) string {
+	if isSafeWord(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// cmdQuote quotes s for cmd.exe. Since cmd.exe expands %VAR% and, with
+// delayed expansion, !VAR! even between double quotes, those characters are
+// left out of the quotes and escaped with a caret.
+func cmdQuote(s string) string {
+	if isSafeWord(s) && !strings.Contains(s, "%") {
+		return s
+	}
+	if s == "" {
+		return `""`
+	}
+
+	var b strings.Builder
+	for s != "" {
+		i := strings.IndexAny(s, "%!")
+		if i < 0 {
+			i = len(s)
+		}
+		if i > 0 {
+			b.WriteString(`"` + strings.ReplaceAll(s[:i], `"`, `""`) + `"`)
+		}
+		if i < len(s) {
+			b.WriteString("^" + s[i:i+1])
+			i++
+		}
+		s = s[i:]
+	}
+	return b.String()
+}
+
+// powershellQuote quotes s for PowerShell.
+func powershellQuote(s string) string {
+	if isSafeWord(s) && !strings.ContainsAny(s, "@%") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// splitWords splits command into words the way sh would, honouring single
+// quotes, double quotes and backslash escapes, but without performing any
+// expansion.
+func splitWords(command string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, c := range command {
+		switch {
+		case escaped:
+			// Inside double quotes a backslash only escapes characters that
+			// would otherwise be special there
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", c) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case quote == '"':
+			switch c {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(c)
+			}
+		case c == '\\':
+			escaped, inWord = true, true
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in command")
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash in command")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return words, nil
+}
@@ -0,0 +1,201 @@
+package executor
+
+import (
+	"os/exec"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// hostileWords are values that a shell would split, expand or run if they
+// were substituted without quoting.
+var hostileWords = []string{
+	"plain.txt",
+	"with space.txt",
+	"it's.txt",
+	`say "hi".txt`,
+	"$(touch pwned).txt",
+	"`touch pwned`.txt",
+	"line\nbreak.txt",
+	"%PATH%.txt",
+	"%COMSPEC%",
+	"!PATH!.txt",
+	"@list.txt",
+	"-rf",
+	"a;b&c|d>e<f",
+	"",
+}
+
+func TestPosixQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain.txt", "plain.txt"},
+		{"with space.txt", "'with space.txt'"},
+		{"it's.txt", `'it'\''s.txt'`},
+		{`say "hi".txt`, `'say "hi".txt'`},
+		{"$(touch pwned).txt", "'$(touch pwned).txt'"},
+		{"`touch pwned`.txt", "'`touch pwned`.txt'"},
+		{"line\nbreak.txt", "'line\nbreak.txt'"},
+		{"%PATH%.txt", "%PATH%.txt"},
+		{"!PATH!.txt", "'!PATH!.txt'"},
+		{"@list.txt", "@list.txt"},
+		{"-rf", "-rf"},
+		{"", "''"},
+	}
+	for _, tt := range tests {
+		if got := posixQuote(tt.in); got != tt.want {
+			t.Errorf("posixQuote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPosixQuoteSplitsBack(t *testing.T) {
+	for _, word := range hostileWords {
+		got, err := splitWords("echo " + posixQuote(word))
+		if err != nil {
+			t.Errorf("splitWords(echo %s): %v", posixQuote(word), err)
+			continue
+		}
+		if want := []string{"echo", word}; !slices.Equal(got, want) {
+			t.Errorf("splitWords(echo %s) = %q, want %q", posixQuote(word), got, want)
+		}
+	}
+}
+
+func TestPosixQuoteShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no POSIX shell")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh in PATH")
+	}
+	for _, word := range hostileWords {
+		out, err := exec.Command("sh", "-c", "printf %s "+posixQuote(word)).Output()
+		if err != nil {
+			t.Errorf("sh -c printf %%s %s: %v", posixQuote(word), err)
+			continue
+		}
+		if string(out) != word {
+			t.Errorf("sh printed %q for %q", out, word)
+		}
+	}
+}
+
+func TestCmdQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain.txt", "plain.txt"},
+		{"with space.txt", `"with space.txt"`},
+		{"it's.txt", `"it's.txt"`},
+		{`say "hi".txt`, `"say ""hi"".txt"`},
+		{"$(touch pwned).txt", `"$(touch pwned).txt"`},
+		{"`touch pwned`.txt", "\"`touch pwned`.txt\""},
+		{"line\nbreak.txt", "\"line\nbreak.txt\""},
+		{"%PATH%.txt", `^%"PATH"^%".txt"`},
+		{"%COMSPEC%", `^%"COMSPEC"^%`},
+		{"!PATH!.txt", `^!"PATH"^!".txt"`},
+		{"a b%c", `"a b"^%"c"`},
+		{"%%", `^%^%`},
+		{"@list.txt", "@list.txt"},
+		{"-rf", "-rf"},
+		{"a;b&c|d>e<f", `"a;b&c|d>e<f"`},
+		{"", `""`},
+	}
+	for _, tt := range tests {
+		if got := cmdQuote(tt.in); got != tt.want {
+			t.Errorf("cmdQuote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPowershellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain.txt", "plain.txt"},
+		{"with space.txt", "'with space.txt'"},
+		{"it's.txt", "'it''s.txt'"},
+		{`say "hi".txt`, `'say "hi".txt'`},
+		{"$(touch pwned).txt", "'$(touch pwned).txt'"},
+		{"`touch pwned`.txt", "'`touch pwned`.txt'"},
+		{"line\nbreak.txt", "'line\nbreak.txt'"},
+		{"%PATH%.txt", "'%PATH%.txt'"},
+		{"!PATH!.txt", "'!PATH!.txt'"},
+		{"@list.txt", "'@list.txt'"},
+		{"-rf", "-rf"},
+		{"", "''"},
+	}
+	for _, tt := range tests {
+		if got := powershellQuote(tt.in); got != tt.want {
+			t.Errorf("powershellQuote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestShellQuoter(t *testing.T) {
+	tests := []struct {
+		shell []string
+		want  string
+	}{
+		{[]string{"sh", "-c"}, "'a b'"},
+		{[]string{"/bin/bash", "-c"}, "'a b'"},
+		{[]string{"cmd", "/c"}, `"a b"`},
+		{[]string{"CMD.EXE", "/c"}, `"a b"`},
+		{[]string{"pwsh", "-Command"}, "'a b'"},
+		{[]string{"powershell.exe", "-Command"}, "'a b'"},
+	}
+	for _, tt := range tests {
+		if got := shellQuoter(tt.shell)("a b"); got != tt.want {
+			t.Errorf("shellQuoter(%q)(\"a b\") = %q, want %q", tt.shell, got, tt.want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "echo plain.txt", want: []string{"echo", "plain.txt"}},
+		{in: "echo  with   space.txt", want: []string{"echo", "with", "space.txt"}},
+		{in: "echo 'with space.txt'", want: []string{"echo", "with space.txt"}},
+		{in: `echo "with space.txt"`, want: []string{"echo", "with space.txt"}},
+		{in: `echo it\'s.txt`, want: []string{"echo", "it's.txt"}},
+		{in: `echo "it's.txt"`, want: []string{"echo", "it's.txt"}},
+		{in: `echo "say \"hi\""`, want: []string{"echo", `say "hi"`}},
+		{in: `echo 'say "hi"'`, want: []string{"echo", `say "hi"`}},
+		{in: "echo $(touch pwned)", want: []string{"echo", "$(touch", "pwned)"}},
+		{in: "echo '$(touch pwned)'", want: []string{"echo", "$(touch pwned)"}},
+		{in: "echo `touch pwned`", want: []string{"echo", "`touch", "pwned`"}},
+		{in: `echo "\$HOME \a"`, want: []string{"echo", `$HOME \a`}},
+		{in: "echo a\nb", want: []string{"echo", "a", "b"}},
+		{in: "echo 'a\nb'", want: []string{"echo", "a\nb"}},
+		{in: "echo %PATH% !PATH! @list -rf", want: []string{"echo", "%PATH%", "!PATH!", "@list", "-rf"}},
+		{in: "echo '' \"\"", want: []string{"echo", "", ""}},
+		{in: "echo a''b", want: []string{"echo", "ab"}},
+		{in: "", wantErr: true},
+		{in: "   ", wantErr: true},
+		{in: "echo 'open", wantErr: true},
+		{in: `echo "open`, wantErr: true},
+		{in: `echo trailing\`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := splitWords(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("splitWords(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitWords(%q): %v", tt.in, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package executor
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// launchLimiter spaces out task launches across all workers: at most one
+// launch per interval, each delayed by a further random amount up to jitter.
+type launchLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	jitter   time.Duration
+	next     time.Time
+	rand     *rand.Rand
+}
+
+// newLaunchLimiter returns a limiter allowing rate launches per second, or
+// nil if neither a rate nor jitter is set. The jitter is drawn from seed,
+// unless it is zero.
+func newLaunchLimiter(rate float64, jitter time.Duration, seed uint64) *launchLimiter {
+	if rate <= 0 && jitter <= 0 {
+		return nil
+	}
+
+	l := &launchLimiter{jitter: jitter}
+	if seed != 0 {
+		l.rand = rand.New(rand.NewPCG(seed, seed))
+	}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return l
+}
+
+// wait blocks until the caller may launch a task. It returns the context's
+// error if ctx is cancelled first. A nil limiter never blocks.
+func (l *launchLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	// Reserve the next free launch slot
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	delay := at.Sub(now)
+	switch {
+	case l.jitter > 0 && l.rand != nil:
+		delay += time.Duration(l.rand.Int64N(int64(l.jitter)))
+	case l.jitter > 0:
+		delay += rand.N(l.jitter)
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,34 @@
+package executor
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+// DefaultSSH is the ssh client command used when Executor.SSH is empty.
+// BatchMode makes it fail instead of prompting for passwords.
+var DefaultSSH = []string{"ssh", "-o", "BatchMode=yes"}
+
+// remoteCommand builds the process that runs command, a POSIX shell command
+// line, for the target at index on host over ssh, in dir unless it is empty.
+// The variables of environ are exported to it on the remote side.
+func (r *run) remoteCommand(ctx context.Context, host, command string, worker, index int, dir string) *exec.Cmd {
+	var script strings.Builder
+	script.WriteString("export")
+	for _, kv := range r.taskEnv(worker, index) {
+		script.WriteString(" " + posixQuote(kv))
+	}
+	script.WriteString("; ")
+	if dir != "" {
+		script.WriteString("cd " + posixQuote(dir) + " && ")
+	}
+	script.WriteString(command)
+
+	ssh := r.SSH
+	if len(ssh) == 0 {
+		ssh = DefaultSSH
+	}
+	args := append(ssh[1:len(ssh):len(ssh)], host, "--", script.String())
+	return exec.CommandContext(ctx, ssh[0], args...)
+}
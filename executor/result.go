@@ -0,0 +1,257 @@
+package executor
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+	"time"
+)
+
+// Result describes the outcome of running the command for one target.
+type Result struct {
+	// Target is the target as passed to Run.
+	Target string
+
+	// Host is the remote host the command ran on, or empty if it ran
+	// locally.
+	Host string
+
+	// Metadata describes the target as it was when its task started, with
+	// Executor.Metadata, unless it could not be read.
+	Metadata *Metadata
+
+	// Command is the command after placeholder expansion.
+	Command string
+
+	// Output is the combined stdout and stderr of the last attempt.
+	Output []byte
+
+	// Stdout and Stderr hold the two streams of the last attempt separately.
+	Stdout []byte
+	Stderr []byte
+
+	// StdoutDropped and StderrDropped count the bytes of each stream left
+	// out of Output, Stdout and Stderr because of Executor.MaxOutput.
+	StdoutDropped int64
+	StderrDropped int64
+
+	// Err is the error of the last attempt, or nil if the command succeeded.
+	Err error
+
+	// ExitCode is the exit code of the last attempt, or -1 if the command
+	// could not be started or was killed by a signal. With Executor.Func, it
+	// is 0 if the function succeeded and -1 otherwise.
+	ExitCode int
+
+	// TimedOut is set when the last attempt was stopped because it ran
+	// longer than Executor.Timeout.
+	TimedOut bool
+
+	// VerifyFailed is set when the command of the last attempt succeeded
+	// but Executor.Verify failed for it.
+	VerifyFailed bool
+
+	// Attempts is the number of times the command was started.
+	Attempts int
+
+	// Duration is the wall time spent on the target, including retries.
+	Duration time.Duration
+
+	// CPUTime is the user and system CPU time used by the command and the
+	// children it waited for, over all attempts.
+	CPUTime time.Duration
+
+	// Skipped is set when the command never ran for the target, and
+	// SkipReason says why: the run was aborted first, a dependency did not
+	// succeed, Executor.Confirm declined it, its test command failed or
+	// Executor.CheckSpace found no room for it.
+	Skipped    bool
+	SkipReason string
+
+	// TestFailed is set along with Skipped when the target was skipped
+	// because Executor.Test failed for it.
+	TestFailed bool
+
+	// NoSpace is set along with Skipped when the target was skipped
+	// because Executor.CheckSpace found no room for it.
+	NoSpace bool
+
+	// Scratch is the scratch directory of the failed task, kept with
+	// Executor.KeepFailedScratch.
+	Scratch string
+
+	// Batch lists the targets whose command ran in the same invocation as
+	// this one, starting with the first, when Executor.Batch is set.
+	Batch []string
+
+	// Cancelled is set when the command was stopped because the run was
+	// aborted while it was running.
+	Cancelled bool
+
+	// Stages records the stages of Executor.Stages that ran for the target,
+	// in order, ending with the one that failed if any did.
+	Stages []StageResult
+}
+
+// Stage is a named step of the pipeline of Executor.Stages.
+type Stage struct {
+	Name    string
+	Command string
+}
+
+// StageResult describes the outcome of one stage of a pipeline, as Result
+// does for the whole of it.
+type StageResult struct {
+	Name     string
+	Command  string
+	Err      error
+	ExitCode int
+	TimedOut bool
+	Attempts int
+	Duration time.Duration
+}
+
+// Failed reports whether the target was processed and its command failed.
+func (r Result) Failed() bool {
+	return !r.Skipped && !r.Cancelled && r.Err != nil
+}
+
+// Failure classifies why the command of a target failed.
+type Failure string
+
+// The kinds of failure, by how the last attempt ended.
+const (
+	FailureExit        Failure = "exit"         // exited with a non-zero code
+	FailureTimeout     Failure = "timeout"      // ran longer than Executor.Timeout
+	FailureSpawn       Failure = "spawn"        // could not be started, or not found by the shell
+	FailureSignal      Failure = "signal"       // killed by a signal
+	FailureOutputLimit Failure = "output-limit" // exited non-zero after writing more than Executor.MaxOutput
+	FailureVerify      Failure = "verify"       // succeeded, but Executor.Verify failed
+	FailureError       Failure = "error"        // anything else, such as an error of Executor.Func
+)
+
+// Failure returns the kind of failure of the target, or "" if its command
+// did not fail. Exit codes 126 and 127, with which shells report commands
+// they cannot run or find, count as FailureSpawn. A command that exited
+// non-zero otherwise counts as FailureOutputLimit when some of its output
+// was dropped, as the reason may be in what is missing.
+func (r Result) Failure() Failure {
+	var exitErr *exec.ExitError
+	var execErr *exec.Error
+	var pathErr *fs.PathError
+	switch {
+	case !r.Failed():
+		return ""
+	case r.TimedOut:
+		return FailureTimeout
+	case r.VerifyFailed:
+		return FailureVerify
+	case errors.As(r.Err, &exitErr) && exitErr.ExitCode() < 0:
+		return FailureSignal
+	case exitErr != nil && (exitErr.ExitCode() == 126 || exitErr.ExitCode() == 127):
+		return FailureSpawn
+	case exitErr != nil && (r.StdoutDropped > 0 || r.StderrDropped > 0):
+		return FailureOutputLimit
+	case exitErr != nil:
+		return FailureExit
+	case errors.As(r.Err, &execErr), errors.As(r.Err, &pathErr):
+		return FailureSpawn
+	}
+	return FailureError
+}
+
+// Results holds one Result per target, in target order.
+type Results []Result
+
+// Succeeded returns the number of targets whose command succeeded.
+func (rs Results) Succeeded() int {
+	n := 0
+	for _, r := range rs {
+		if !r.Skipped && !r.Cancelled && r.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the number of targets whose command failed.
+func (rs Results) Failed() int {
+	n := 0
+	for _, r := range rs {
+		if r.Failed() {
+			n++
+		}
+	}
+	return n
+}
+
+// Skipped returns the number of targets that were never processed.
+func (rs Results) Skipped() int {
+	n := 0
+	for _, r := range rs {
+		if r.Skipped {
+			n++
+		}
+	}
+	return n
+}
+
+// TimedOut returns the number of targets whose command failed because it ran
+// too long.
+func (rs Results) TimedOut() int {
+	n := 0
+	for _, r := range rs {
+		if r.Failed() && r.TimedOut {
+			n++
+		}
+	}
+	return n
+}
+
+// VerifyFailed returns the number of failed targets whose command succeeded
+// but whose verify command failed.
+func (rs Results) VerifyFailed() int {
+	n := 0
+	for _, r := range rs {
+		if r.Failed() && r.VerifyFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// TestFailed returns the number of targets skipped because their test
+// command failed.
+func (rs Results) TestFailed() int {
+	n := 0
+	for _, r := range rs {
+		if r.TestFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// NoSpace returns the number of targets skipped because there was no room
+// for them.
+func (rs Results) NoSpace() int {
+	n := 0
+	for _, r := range rs {
+		if r.NoSpace {
+			n++
+		}
+	}
+	return n
+}
+
+// Cancelled returns the number of targets whose command was stopped because
+// the run was aborted.
+func (rs Results) Cancelled() int {
+	n := 0
+	for _, r := range rs {
+		if r.Cancelled {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,154 @@
+//go:build linux
+
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+// sandboxArg0 is the name a sandboxed command is started under, through the
+// executable of the program itself, for SandboxInit to recognise.
+const sandboxArg0 = "executor-sandbox"
+
+// sandboxSupported reports whether sandboxProcess can confine commands.
+const sandboxSupported = true
+
+// Linux interfaces missing from package syscall.
+const (
+	atFdcwd              = -100
+	sysMountSetattr      = 442
+	atRecursive          = 0x8000
+	mountAttrRdonly      = 0x1
+	prCapbsetDrop        = 24
+	prSetNoNewPrivs      = 38
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+	capVersion3          = 0x20080522
+)
+
+// sandboxProcess makes cmd start in namespaces of its own, without network
+// access, where SandboxInit makes the filesystem read-only except for
+// writable and drops its capabilities before running the command.
+func sandboxProcess(cmd *exec.Cmd, writable []string) {
+	if cmd.Err != nil {
+		return
+	}
+	args := append([]string{sandboxArg0}, writable...)
+	args = append(args, "--", cmd.Path)
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+}
+
+// SandboxInit runs the command of a task in the sandbox when the program was
+// started for that by Executor.Sandbox, and then never returns. Programs
+// setting Sandbox must call it first thing in main.
+func SandboxInit() {
+	if len(os.Args) < 2 || os.Args[0] != sandboxArg0 {
+		return
+	}
+	err := enterSandbox(os.Args[1:])
+	fmt.Fprintf(os.Stderr, "executor: sandbox: %v\n", err)
+	os.Exit(126)
+}
+
+// enterSandbox sets up the sandbox and executes the command in args, which
+// follow the writable paths and "--". It only returns on failure.
+func enterSandbox(args []string) error {
+	sep := slices.Index(args, "--")
+	if sep < 0 || len(args) < sep+3 {
+		return errors.New("missing command")
+	}
+	writable, path, argv := args[:sep], args[sep+1], args[sep+2:]
+
+	// Capabilities belong to threads, and must be dropped by the one that
+	// executes the command
+	runtime.LockOSThread()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("cannot make mounts private: %w", err)
+	}
+	if err := setMountAttr("/", mountAttrRdonly, 0); err != nil {
+		return fmt.Errorf("cannot make the filesystem read-only: %w", err)
+	}
+	for _, dir := range writable {
+		if err := syscall.Mount(dir, dir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("cannot make %s writable: %w", dir, err)
+		}
+		if err := setMountAttr(dir, 0, mountAttrRdonly); err != nil {
+			return fmt.Errorf("cannot make %s writable: %w", dir, err)
+		}
+	}
+	// Enter the working directory again, through the new mounts
+	if err := os.Chdir(wd); err != nil {
+		return err
+	}
+
+	if err := dropCapabilities(); err != nil {
+		return fmt.Errorf("cannot drop capabilities: %w", err)
+	}
+	return syscall.Exec(path, argv, os.Environ())
+}
+
+// setMountAttr sets and clears the attributes of the mount at path and those
+// below it with mount_setattr(2), available since Linux 5.12.
+func setMountAttr(path string, set, clear uint64) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attr := struct{ set, clear, propagation, userns uint64 }{set: set, clear: clear}
+	dirfd := atFdcwd
+	_, _, errno := syscall.Syscall6(sysMountSetattr, uintptr(dirfd), uintptr(unsafe.Pointer(p)),
+		atRecursive, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// dropCapabilities empties the capability sets of the calling thread, so
+// that not even a command running as root in the namespace regains them,
+// and forbids gaining privileges through set-user-ID programs.
+func dropCapabilities() error {
+	for c := uintptr(0); c < 64; c++ {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapbsetDrop, c, 0); errno == syscall.EINVAL {
+			break
+		} else if errno != 0 {
+			return errno
+		}
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 && errno != syscall.EINVAL {
+		return errno
+	}
+	header := struct {
+		version uint32
+		pid     int32
+	}{version: capVersion3}
+	var data [2]struct{ effective, permitted, inheritable uint32 }
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data)), 0); errno != 0 {
+		return errno
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package executor
+
+import "os/exec"
+
+// sandboxSupported is false, as the sandbox relies on Linux namespaces.
+const sandboxSupported = false
+
+// sandboxProcess is a no-op; Run rejects Sandbox on these platforms.
+func sandboxProcess(cmd *exec.Cmd, writable []string) {}
+
+// SandboxInit does nothing on these platforms, where Executor.Sandbox is not
+// supported.
+func SandboxInit() {}
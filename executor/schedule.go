@@ -0,0 +1,230 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// depGraph tracks the dependencies between the targets of a run by index.
+type depGraph struct {
+	// pending counts the prerequisites of each target not yet resolved
+	pending []int
+
+	// dependents lists the targets waiting for each target
+	dependents [][]int
+
+	// blockedBy is the index of a prerequisite that did not succeed, or -1
+	blockedBy []int
+}
+
+// newDepGraph builds the graph for targets from deps, which maps a target to
+// its prerequisites. Prerequisites that are not among the targets are
+// ignored. It fails if the dependencies contain a cycle.
+func newDepGraph(targets []string, deps map[string][]string) (*depGraph, error) {
+	g := emptyDepGraph(len(targets))
+
+	index := make(map[string]int, len(targets))
+	for i, target := range targets {
+		index[target] = i
+	}
+	for i, target := range targets {
+		for _, prereq := range deps[target] {
+			if p, ok := index[prereq]; ok && p != i {
+				g.dependents[p] = append(g.dependents[p], i)
+				g.pending[i]++
+			}
+		}
+	}
+
+	// Every target must become ready eventually; those that don't are on
+	// or behind a cycle
+	pending := append([]int(nil), g.pending...)
+	var queue []int
+	for i, n := range pending {
+		if n == 0 {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		for _, d := range g.dependents[i] {
+			if pending[d]--; pending[d] == 0 {
+				queue = append(queue, d)
+			}
+		}
+	}
+	var cyclic []string
+	for i, n := range pending {
+		if n > 0 {
+			cyclic = append(cyclic, targets[i])
+		}
+	}
+	if len(cyclic) > 0 {
+		return nil, fmt.Errorf("dependency cycle among %s", strings.Join(cyclic, ", "))
+	}
+	return g, nil
+}
+
+// emptyDepGraph returns a graph of n tasks without any dependencies.
+func emptyDepGraph(n int) *depGraph {
+	g := &depGraph{
+		pending:    make([]int, n),
+		dependents: make([][]int, n),
+		blockedBy:  make([]int, n),
+	}
+	for i := range g.blockedBy {
+		g.blockedBy[i] = -1
+	}
+	return g
+}
+
+// schedule hands targets to the workers through tasks as soon as all their
+// prerequisites have succeeded and fewer than workers, or the workers set on
+// Executor.Control, are in flight, and closes tasks when every target is
+// resolved or the run is aborted. spawn is called to start the workers up to
+// the given number. Targets of a busy partition wait for it to be done, and
+// those of a group at its limit for one of its tasks to be. Workers report
+// each target they took on done, whether they ran it or not.
+func (r *run) schedule(g *depGraph, workers int, spawn func(n int), tasks chan<- int, done <-chan int) {
+	defer close(tasks)
+
+	limit, paused := workers, false
+	var changed <-chan struct{}
+	watch := func() {
+		if r.Control == nil {
+			return
+		}
+		var n int
+		paused, n, changed = r.Control.watch()
+		limit = workers
+		if n > 0 {
+			limit = n
+		}
+	}
+	watch()
+
+	var ready []int
+	for i, n := range g.pending {
+		if n == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	inflight := 0
+	busy := make(map[string]bool)     // partitions with a task in flight
+	running := make(map[string]int)   // tasks in flight by group
+	waiting := make(map[string][]int) // by what holds them back, in order
+
+	// held returns what holds back the target at index, if anything
+	held := func(i int) (string, bool) {
+		if key := r.partition(i); key != "" && busy[key] {
+			return "partition " + key, true
+		}
+		if group := r.group(i); group != "" && r.GroupLimits[group] > 0 && running[group] >= r.GroupLimits[group] {
+			return "group " + group, true
+		}
+		return "", false
+	}
+	// release makes the first target held back by key ready again
+	release := func(key string) {
+		if queue := waiting[key]; len(queue) > 0 {
+			ready = append(ready, queue[0])
+			waiting[key] = queue[1:]
+		}
+	}
+
+	aborted := r.ctx.Done()
+	for len(ready) > 0 || inflight > 0 {
+		spawn(limit)
+		for len(ready) > 0 {
+			key, ok := held(ready[0])
+			if !ok {
+				break
+			}
+			waiting[key] = append(waiting[key], ready[0])
+			ready = ready[1:]
+		}
+
+		var send chan<- int
+		var next int
+		if len(ready) > 0 && !paused && inflight < limit {
+			send, next = tasks, ready[0]
+		}
+
+		select {
+		case send <- next:
+			ready = ready[1:]
+			inflight++
+			if key := r.partition(next); key != "" {
+				busy[key] = true
+			}
+			if group := r.group(next); group != "" {
+				running[group]++
+			}
+		case i := <-done:
+			inflight--
+			if key := r.partition(i); key != "" {
+				delete(busy, key)
+				release("partition " + key)
+			}
+			if group := r.group(i); group != "" {
+				running[group]--
+				release("group " + group)
+			}
+			if aborted != nil {
+				ready = r.resolve(g, i, ready)
+			}
+		case <-aborted:
+			// Stop dispatching; targets not started yet remain skipped
+			ready, waiting, aborted = nil, nil, nil
+		case <-changed:
+			watch()
+		}
+	}
+}
+
+// partition returns the partition of the target at index, or "" if it has
+// none.
+func (r *run) partition(index int) string {
+	if r.Partitions == nil {
+		return ""
+	}
+	return r.Partitions[index]
+}
+
+// group returns the concurrency group of the target at index, or "" if it
+// has none.
+func (r *run) group(index int) string {
+	if r.Groups == nil {
+		return ""
+	}
+	return r.Groups[index]
+}
+
+// resolve releases the dependents of the target at index once it is done,
+// returning ready with the targets that can now run appended. Dependents of
+// a target that did not succeed are skipped, and so on down the graph.
+func (r *run) resolve(g *depGraph, index int, ready []int) []int {
+	result := &r.results[index]
+	succeeded := !result.Skipped && !result.Cancelled && result.Err == nil
+
+	for _, d := range g.dependents[index] {
+		if !succeeded && g.blockedBy[d] < 0 {
+			g.blockedBy[d] = index
+		}
+		if g.pending[d]--; g.pending[d] > 0 {
+			continue
+		}
+
+		if blocker := g.blockedBy[d]; blocker >= 0 {
+			skipped := &r.results[d]
+			skipped.SkipReason = fmt.Sprintf("dependency %s did not succeed", r.results[blocker].Target)
+			r.emit(Event{Type: TaskSkipped, Worker: -1, Target: skipped.Target, Index: d, Result: skipped})
+			ready = r.resolve(g, d, ready)
+		} else {
+			ready = append(ready, d)
+		}
+	}
+	return ready
+}
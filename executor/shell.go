@@ -0,0 +1,212 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultShell returns the interpreter used when Executor.Shell is empty:
+// sh on Unix-like systems and cmd.exe on Windows. The command string is
+// appended to it as the last argument.
+func DefaultShell() []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C"}
+	}
+	return []string{"/bin/sh", "-c"}
+}
+
+// commandLine is a command prepared for expansion by prepareCommand.
+type commandLine struct {
+	text   string
+	expand expander
+
+	// words and expandArgs hold the words of the command in direct mode
+	words      []string
+	expandArgs []expander
+}
+
+// prepareCommand sets up the expansion of command for the run.
+//
+// In shell mode placeholders are replaced with values quoted for the shell,
+// so paths containing spaces, quotes or shell syntax arrive as one literal
+// argument. In direct mode the command is split into words up front and
+// placeholders are replaced within each word, which needs no quoting at all.
+func (r *run) prepareCommand(command string) (*commandLine, error) {
+	if r.Batch > 1 && strings.Contains(command, "{{") {
+		return nil, errors.New("templates cannot be used with batching")
+	}
+
+	line := &commandLine{text: command}
+	if !r.Direct {
+		expand, err := newExpander(command, r.quote)
+		if err != nil {
+			return nil, err
+		}
+		line.expand = expand
+		return line, nil
+	}
+
+	words, err := splitWords(command)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range words {
+		expand, err := newExpander(word, nil)
+		if err != nil {
+			return nil, err
+		}
+		line.expandArgs = append(line.expandArgs, expand)
+	}
+	line.words = words
+	return line, nil
+}
+
+// newCommand builds the process that runs line for the targets from index lo
+// up to hi in dir on the given worker, and returns it along with the
+// expanded command line for reporting. Unless batching, that is a single
+// target.
+func (r *run) newCommand(ctx context.Context, line *commandLine, worker, lo, hi int, dir string) (*exec.Cmd, string, error) {
+	var data []TemplateData
+	for i := lo; i < hi; i++ {
+		d := r.columns.data(r.results[i].Target, i, len(r.results))
+		if r.Paths != nil {
+			d.Path = r.Paths[i]
+		}
+		if r.scratch != nil {
+			d.Tmp = r.scratch[lo]
+		}
+		d.meta = r.metadataOf(ctx, i)
+		data = append(data, d)
+	}
+
+	if r.Direct {
+		var args []string
+		if r.Batch > 1 {
+			args = batchArgs(line.words, data)
+		} else {
+			for _, expand := range line.expandArgs {
+				arg, err := expand(data[0])
+				if err != nil {
+					return nil, "", err
+				}
+				args = append(args, arg)
+			}
+		}
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = posixQuote(arg)
+		}
+		cmdStr := strings.Join(quoted, " ")
+		if host := r.host(lo); host != "" {
+			return r.remoteCommand(ctx, host, cmdStr, worker, lo, dir), cmdStr, nil
+		}
+		if r.Container != nil {
+			return r.containerCommand(ctx, args, worker, lo, dir), cmdStr, nil
+		}
+
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = dir
+		cmd.Env = r.environ(worker, lo)
+		limitProcess(cmd, r.Nice, r.IdleIO, r.CPULimit, r.MemoryLimit)
+		r.sandbox(cmd, lo, hi)
+		return cmd, cmdStr, nil
+	}
+
+	var cmdStr string
+	if r.Batch > 1 {
+		cmdStr = expandBatch(line.text, data, r.quote)
+	} else {
+		var err error
+		if cmdStr, err = line.expand(data[0]); err != nil {
+			return nil, "", err
+		}
+	}
+	if host := r.host(lo); host != "" {
+		return r.remoteCommand(ctx, host, cmdStr, worker, lo, dir), cmdStr, nil
+	}
+	if r.Container != nil {
+		return r.containerCommand(ctx, []string{"/bin/sh", "-c", cmdStr}, worker, lo, dir), cmdStr, nil
+	}
+	args := append(r.shell[1:len(r.shell):len(r.shell)], cmdStr)
+	cmd := exec.CommandContext(ctx, r.shell[0], args...)
+	cmd.Dir = dir
+	cmd.Env = r.environ(worker, lo)
+	prepareShell(cmd, r.shell, cmdStr)
+	limitProcess(cmd, r.Nice, r.IdleIO, r.CPULimit, r.MemoryLimit)
+	r.sandbox(cmd, lo, hi)
+	return cmd, cmdStr, nil
+}
+
+// batchArgs expands the words of a direct command for several targets. A
+// word containing placeholders becomes one argument per target, so
+// "--file={}" passes "--file=a --file=b"; other words are kept as they are.
+func batchArgs(words []string, data []TemplateData) []string {
+	var args []string
+	for _, word := range words {
+		if !hasPlaceholder(word, data[0]) {
+			args = append(args, word)
+			continue
+		}
+		for _, d := range data {
+			args = append(args, expandBatch(word, []TemplateData{d}, nil))
+		}
+	}
+	return args
+}
+
+// host returns the remote host for the target at index, or "" if its command
+// runs locally.
+func (r *run) host(index int) string {
+	if r.Hosts == nil {
+		return ""
+	}
+	return r.Hosts[index]
+}
+
+// sandbox confines cmd with Executor.Sandbox, letting it write to nothing
+// but the targets from index lo up to hi and their scratch directory.
+func (r *run) sandbox(cmd *exec.Cmd, lo, hi int) {
+	if !r.Sandbox {
+		return
+	}
+	var writable []string
+	for i := lo; i < hi; i++ {
+		target, err := filepath.Abs(r.results[i].Target)
+		if err != nil {
+			target = r.results[i].Target
+		}
+		writable = append(writable, target)
+	}
+	if r.scratch != nil {
+		writable = append(writable, r.scratch[lo])
+	}
+	sandboxProcess(cmd, writable)
+}
+
+// environ returns the environment for the command of the target at index:
+// executor's own environment, Executor.Env, and variables describing the
+// task. For a batch, index is its first target.
+func (r *run) environ(worker, index int) []string {
+	return append(os.Environ(), r.taskEnv(worker, index)...)
+}
+
+// taskEnv returns the variables environ adds to executor's own environment.
+func (r *run) taskEnv(worker, index int) []string {
+	env := append(slices.Clip(r.Env),
+		"EXECUTOR_TARGET="+r.results[index].Target,
+		"EXECUTOR_INDEX="+strconv.Itoa(index+1),
+		"EXECUTOR_TOTAL="+strconv.Itoa(len(r.results)),
+		"EXECUTOR_WORKER="+strconv.Itoa(worker),
+	)
+	if r.scratch != nil {
+		env = append(env, "EXECUTOR_TMP="+r.scratch[index])
+	}
+	return env
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package executor
+
+import "os/exec"
+
+// prepareShell needs no adjustments outside Windows.
+func prepareShell(cmd *exec.Cmd, shell []string, cmdStr string) {}
@@ -0,0 +1,20 @@
+package executor
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// prepareShell passes the command line to cmd.exe verbatim. cmd.exe does its
+// own parsing and mangles commands that Go has quoted as a single argument.
+func prepareShell(cmd *exec.Cmd, shell []string, cmdStr string) {
+	name := strings.TrimSuffix(strings.ToLower(filepath.Base(shell[0])), ".exe")
+	if name != "cmd" {
+		return
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CmdLine: strings.Join(append(shell[:len(shell):len(shell)], cmdStr), " "),
+	}
+}
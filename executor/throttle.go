@@ -0,0 +1,63 @@
+package executor
+
+import "time"
+
+// throttlePoll is how often a throttled worker checks whether the system has
+// recovered.
+const throttlePoll = 500 * time.Millisecond
+
+// throttle blocks while Executor.Overloaded reports that the system is
+// overloaded, unless no other task is running, so the run always makes
+// progress. It returns the context's error if the run is aborted first.
+func (r *run) throttle() error {
+	if r.Overloaded == nil {
+		return r.ctx.Err()
+	}
+
+	ticker := time.NewTicker(throttlePoll)
+	defer ticker.Stop()
+	for r.running.Load() > 0 && r.Overloaded() {
+		select {
+		case <-ticker.C:
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		}
+	}
+	return r.ctx.Err()
+}
+
+// checkSpace waits while Executor.CheckSpace finds no room for the targets
+// from index lo up to hi and other tasks are running, which may free some.
+// If there is still none, it marks the targets as skipped and returns false,
+// as it does if the run is aborted first.
+func (r *run) checkSpace(id, lo, hi int) bool {
+	if r.CheckSpace == nil {
+		return true
+	}
+
+	ticker := time.NewTicker(throttlePoll)
+	defer ticker.Stop()
+	err := r.CheckSpace(r.results[lo].Target)
+	for err != nil && r.running.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-r.ctx.Done():
+			return false
+		}
+		err = r.CheckSpace(r.results[lo].Target)
+	}
+	if r.ctx.Err() != nil {
+		return false
+	}
+	if err == nil {
+		return true
+	}
+
+	for i := lo; i < hi; i++ {
+		skipped := &r.results[i]
+		skipped.SkipReason = err.Error()
+		skipped.NoSpace = true
+		r.emit(Event{Type: TaskSkipped, Worker: id, Target: skipped.Target, Index: i, Result: skipped})
+	}
+	return false
+}
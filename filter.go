@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// targetFilter selects matched paths by type and metadata.
+type targetFilter struct {
+	// where is the -where expression, including -dirs-only and -files-only;
+	// nil accepts everything.
+	where wherePredicate
+
+	// types lists the accepted kinds of path as in find(1): 'f' for regular
+	// files, 'd' for directories and 'l' for symbolic links; empty accepts
+	// all of them.
+	types string
+
+	// minDepth and maxDepth bound the number of path elements below root;
+	// a negative maxDepth disables the check.
+	root     string
+	minDepth int
+	maxDepth int
+
+	// newerThan and olderThan compare the modification time against now;
+	// zero disables the check.
+	newerThan time.Duration
+	olderThan time.Duration
+
+	// minSize and maxSize bound the size of files; negative disables the
+	// check. Directories never pass a size check.
+	minSize int64
+	maxSize int64
+
+	// exts lists the accepted extensions without their leading dot.
+	exts []string
+
+	// contains and notContains are matched against the contents of files
+	// by matchContent; nil disables the check. Directories never pass a
+	// content check.
+	contains    *regexp.Regexp
+	notContains *regexp.Regexp
+}
+
+// match reports whether the path with the given info passes the filter.
+func (f *targetFilter) match(path string, info os.FileInfo, now time.Time) bool {
+	isDir := info.IsDir()
+	if f.where != nil && !f.where(&whereFile{path: path, info: info, root: f.root, now: now}) {
+		return false
+	}
+
+	if f.types != "" && !strings.ContainsRune(f.types, fileType(path, info)) {
+		return false
+	}
+
+	if f.minDepth > 0 || f.maxDepth >= 0 {
+		depth := pathDepth(f.root, path)
+		if depth < f.minDepth || (f.maxDepth >= 0 && depth > f.maxDepth) {
+			return false
+		}
+	}
+
+	age := now.Sub(info.ModTime())
+	if (f.newerThan > 0 && age > f.newerThan) || (f.olderThan > 0 && age < f.olderThan) {
+		return false
+	}
+
+	if f.minSize >= 0 || f.maxSize >= 0 {
+		if isDir || (f.minSize >= 0 && info.Size() < f.minSize) || (f.maxSize >= 0 && info.Size() > f.maxSize) {
+			return false
+		}
+	}
+
+	if len(f.exts) > 0 {
+		ext := strings.TrimPrefix(filepath.Ext(path), ".")
+		for _, want := range f.exts {
+			if strings.EqualFold(ext, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// active reports whether the filter leaves out any path at all.
+func (f *targetFilter) active() bool {
+	return f.where != nil || f.types != "" || f.minDepth > 0 || f.maxDepth >= 0 ||
+		f.newerThan > 0 || f.olderThan > 0 || f.minSize >= 0 || f.maxSize >= 0 ||
+		len(f.exts) > 0 || f.contains != nil || f.notContains != nil
+}
+
+// fileType returns the find(1) letter for the kind of path, whose info
+// describes what it resolves to: 'l' for symbolic links, 'd' for
+// directories, 'f' for regular files and '?' for anything else.
+func fileType(path string, info os.FileInfo) rune {
+	if link, err := os.Lstat(path); err == nil && link.Mode()&os.ModeSymlink != 0 {
+		return 'l'
+	}
+	switch {
+	case info.IsDir():
+		return 'd'
+	case info.Mode().IsRegular():
+		return 'f'
+	}
+	return '?'
+}
+
+// parseTypes checks a -type value such as "f" or "f,l" and returns its
+// letters.
+func parseTypes(value string) (string, error) {
+	types := strings.ReplaceAll(value, ",", "")
+	for _, c := range types {
+		if !strings.ContainsRune("fdl", c) {
+			return "", fmt.Errorf("unknown type %q (expected f, d or l)", c)
+		}
+	}
+	return types, nil
+}
+
+// pathDepth counts the path elements of path below root, so root itself is
+// at depth 0 and its entries at depth 1.
+func pathDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return len(splitPath(path))
+	}
+	return len(splitPath(rel))
+}
+
+// matchContent reports whether the contents of the file at path pass the
+// filter. It is separate from match because it reads the file.
+func (f *targetFilter) matchContent(path string, info os.FileInfo) (bool, error) {
+	if f.contains == nil && f.notContains == nil {
+		return true, nil
+	}
+	if info.IsDir() {
+		return false, nil
+	}
+
+	for _, check := range []struct {
+		re   *regexp.Regexp
+		want bool
+	}{{f.contains, true}, {f.notContains, false}} {
+		if check.re == nil {
+			continue
+		}
+		found, err := fileContains(path, check.re)
+		if err != nil || found != check.want {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// fileContains reports whether re matches somewhere in the file at path,
+// reading it as a stream rather than all at once.
+func fileContains(path string, re *regexp.Regexp) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	return re.MatchReader(bufio.NewReader(file)), nil
+}
+
+// parseAge parses a duration such as "90m", "24h" or "7d". On top of the
+// time.ParseDuration units, "d" stands for days and "w" for weeks.
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			value, err := strconv.ParseFloat(n, 64)
+			if err != nil || value < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(value * float64(unit)), nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// parseSize parses a size such as "512", "10K", "1.5MB" or "2G". Units are
+// powers of 1024 and the trailing "B" is optional.
+func parseSize(s string) (int64, error) {
+	n := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+
+	multiplier := int64(1)
+	if i := strings.IndexAny(n, "KMGT"); i >= 0 && i == len(n)-1 {
+		multiplier = int64(1) << (10 * (strings.IndexByte("KMGT", n[i]) + 1))
+		n = n[:i]
+	}
+
+	value, err := strconv.ParseFloat(n, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// formatSize writes a number of bytes the way parseSize reads it, such as
+// "1.5G", rounded to one decimal.
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	value, unit := float64(n)/1024, 0
+	for value >= 1024 && unit < 3 {
+		value, unit = value/1024, unit+1
+	}
+	return strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64) + string("KMGT"[unit])
+}
+
+// parseExts splits comma-separated extension lists, dropping leading dots.
+func parseExts(lists []string) []string {
+	var exts []string
+	for _, list := range lists {
+		for _, ext := range strings.Split(list, ",") {
+			if ext = strings.TrimPrefix(strings.TrimSpace(ext), "."); ext != "" {
+				exts = append(exts, ext)
+			}
+		}
+	}
+	return exts
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a single pattern line from a .gitignore file.
+type ignoreRule struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// gitignore decides whether paths are ignored by the .gitignore files of the
+// repository containing them. Files are loaded lazily, once per directory.
+type gitignore struct {
+	root  string
+	rules map[string][]ignoreRule
+}
+
+// newGitignore returns a matcher for the repository enclosing dir. If dir is
+// not inside a git repository, .gitignore files below dir are still honoured.
+func newGitignore(dir string) *gitignore {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		root = dir
+	}
+	for d := root; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			root = d
+			break
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return &gitignore{root: root, rules: make(map[string][]ignoreRule)}
+}
+
+// ignored reports whether path, or any directory containing it, is ignored.
+// The .git directory itself is always ignored.
+func (g *gitignore) ignored(path string, isDir bool) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(g.root, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	segments := splitPath(rel)
+	for i := 1; i <= len(segments); i++ {
+		if segments[i-1] == ".git" || g.match(segments[:i], i < len(segments) || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// match applies the rules of every .gitignore between the root and the path
+// given by segments. As in git, the last matching rule wins.
+func (g *gitignore) match(segments []string, isDir bool) bool {
+	ignored := false
+	for depth := 0; depth < len(segments); depth++ {
+		dir := filepath.Join(append([]string{g.root}, segments[:depth]...)...)
+		rel := segments[depth:]
+		for _, rule := range g.load(dir) {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.matches(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// matches reports whether the rule matches a path relative to the directory
+// of its .gitignore file.
+func (r ignoreRule) matches(rel []string) bool {
+	if r.anchored {
+		return matchSegments(r.segments, rel)
+	}
+	ok, _ := filepath.Match(r.segments[0], rel[len(rel)-1])
+	return ok
+}
+
+// load returns the rules of the .gitignore file in dir, if any.
+func (g *gitignore) load(dir string) []ignoreRule {
+	if rules, ok := g.rules[dir]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	if f, err := os.Open(filepath.Join(dir, ".gitignore")); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+				rules = append(rules, rule)
+			}
+		}
+		f.Close()
+	}
+	g.rules[dir] = rules
+	return rules
+}
+
+// parseIgnoreRule parses one .gitignore line, reporting false for blank
+// lines and comments.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+
+	// Patterns containing a slash are relative to the .gitignore directory,
+	// others match a name at any depth
+	rule.anchored = strings.Contains(line, "/")
+	rule.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	return rule, true
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// expandHome replaces a leading "~" in pattern with the user's home
+// directory. Both "~/" and "~\" are accepted so patterns written for Windows
+// work as well.
+func expandHome(pattern string) (string, error) {
+	if pattern != "~" && !strings.HasPrefix(pattern, "~/") && !strings.HasPrefix(pattern, `~\`) {
+		return pattern, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, pattern[1:]), nil
+}
+
+// skipFunc reports whether a path found while matching should be left out.
+// Directories it skips are not descended into by recursive patterns.
+type skipFunc func(path string, isDir bool) bool
+
+// glob returns the paths matching pattern. On top of the filepath.Match
+// syntax, a "**" path element matches any number of directories, and "**"
+// inside an element is short for "**/" followed by the element with "*",
+// so "**.go" matches Go files at any depth. Paths for which skip returns
+// true are left out. With follow set, recursive patterns descend into
+// symbolic links to directories too.
+func glob(pattern string, skip skipFunc, follow bool) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		var kept []string
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err == nil && skip(match, info.IsDir()) {
+				continue
+			}
+			kept = append(kept, match)
+		}
+		return kept, nil
+	}
+
+	segments := patternSegments(pattern)
+	if _, err := filepath.Match(strings.Join(segments, "/"), ""); err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	err := walkDir(globRoot(pattern), follow, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are left out rather than failing the match
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if skip(path, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if matchSegments(segments, splitPath(path)) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// walkDir is filepath.WalkDir, except that with follow set it descends into
+// symbolic links to directories as well. A link leading back to a directory
+// it is in is a loop: it is reported but not descended into.
+func walkDir(root string, follow bool, fn fs.WalkDirFunc) error {
+	if !follow {
+		return filepath.WalkDir(root, fn)
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkFollow(root, fs.FileInfoToDirEntry(info), nil, fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkFollow walks the tree at path for walkDir. ancestors holds the
+// resolved paths of the directories path is in.
+func walkFollow(path string, d fs.DirEntry, ancestors []string, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			return nil
+		}
+		return err
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil && slices.Contains(ancestors, resolved) {
+		warnf(os.Stderr, "not following symlink loop at %s", path)
+		return nil
+	}
+	ancestors = append(ancestors, resolved)
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if err := fn(path, d, err); err != nil && err != fs.SkipDir {
+			return err
+		}
+		return nil
+	}
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if info, err := os.Stat(child); err == nil {
+				entry = fs.FileInfoToDirEntry(info)
+			}
+		}
+		if err := walkFollow(child, entry, ancestors, fn); err != nil {
+			if err == fs.SkipDir {
+				// Returned for a file, it skips the rest of the directory
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// isSymlink reports whether path is a symbolic link.
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
+
+// globRoot returns the leading part of pattern that contains no
+// metacharacters, which is where matching has to start.
+func globRoot(pattern string) string {
+	var static []string
+	for _, segment := range strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/") {
+		if strings.ContainsAny(segment, `*?[\`) {
+			break
+		}
+		static = append(static, segment)
+	}
+
+	root := strings.Join(static, "/")
+	switch {
+	case len(static) == 1 && static[0] == "":
+		root = "/"
+	case root == "":
+		root = "."
+	}
+	return filepath.FromSlash(root)
+}
+
+// commonRoot returns the deepest directory containing the globRoot of every
+// pattern, relative if the first one is.
+func commonRoot(patterns []string) string {
+	if len(patterns) == 0 {
+		return "."
+	}
+	first := globRoot(patterns[0])
+	if len(patterns) == 1 {
+		return first
+	}
+
+	root := absPath(first)
+	for _, pattern := range patterns[1:] {
+		other := absPath(globRoot(pattern))
+		for !within(other, root) && filepath.Dir(root) != root {
+			root = filepath.Dir(root)
+		}
+	}
+	if !filepath.IsAbs(first) {
+		if rel, err := filepath.Rel(absPath("."), root); err == nil {
+			return rel
+		}
+	}
+	return root
+}
+
+// within reports whether path is dir or below it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// globSet returns the paths matching any of patterns and all of intersect
+// but none of exclude, in the order patterns match them. Paths are compared
+// by canonicalPath, so that different spellings of a path count as one.
+func globSet(patterns, intersect, exclude []string, skip skipFunc, follow bool) ([]string, error) {
+	var matches []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		found, err := glob(pattern, skip, follow)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range found {
+			if !seen[path] {
+				seen[path] = true
+				matches = append(matches, path)
+			}
+		}
+	}
+
+	filter := func(pattern string, keep bool) error {
+		found, err := glob(pattern, skip, follow)
+		if err != nil {
+			return err
+		}
+		set := make(map[string]bool, len(found))
+		for _, path := range found {
+			set[canonicalPath(path)] = true
+		}
+		kept := matches[:0]
+		for _, path := range matches {
+			if set[canonicalPath(path)] == keep {
+				kept = append(kept, path)
+			}
+		}
+		matches = kept
+		return nil
+	}
+	for _, pattern := range intersect {
+		if err := filter(pattern, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range exclude {
+		if err := filter(pattern, false); err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
+
+// canonicalPath returns the absolute path of the file path resolves to, or
+// the absolute form of path if it doesn't resolve.
+func canonicalPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return absPath(path)
+}
+
+// patternSegments splits pattern into path elements, rewriting elements such
+// as "**.go" into "**" followed by "*.go".
+func patternSegments(pattern string) []string {
+	var segments []string
+	for _, segment := range splitPath(pattern) {
+		if segment != "**" && strings.Contains(segment, "**") {
+			segments = append(segments, "**")
+			segment = strings.ReplaceAll(segment, "**", "*")
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+// splitPath splits a cleaned path into its elements. Absolute paths start
+// with an empty element.
+func splitPath(path string) []string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// matchSegments reports whether the path elements name match the pattern
+// elements pat, where a "**" element matches any number of path elements
+// (at least one when it ends the pattern).
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			pat = pat[1:]
+			if len(pat) == 0 {
+				return len(name) > 0
+			}
+			for i := range name {
+				if matchSegments(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// excluded reports whether path matches any of the exclude patterns. A
+// pattern matches when it matches any run of consecutive path elements, so
+// "node_modules" and "*/node_modules/*" both exclude everything below a
+// node_modules directory and "*.min.js" excludes such files at any depth.
+func excluded(path string, patterns []string) bool {
+	name := splitPath(path)
+	for _, pattern := range patterns {
+		pat := patternSegments(pattern)
+		for i := range name {
+			for j := i + 1; j <= len(name); j++ {
+				if matchSegments(pat, name[i:j]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
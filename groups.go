@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseGroupBy returns the function computing the -group-by group of a
+// target from spec: any -partition-by key, or a file mapping path patterns
+// to group names like the -cmd-map file does to commands:
+//
+//	"/mnt/nfs/**": nfs
+//	"*.iso": large
+//
+// Targets matching no pattern are in no group.
+func parseGroupBy(spec string) (func(target string) string, error) {
+	key, err := parsePartition(spec)
+	if err == nil {
+		return key, nil
+	}
+	if _, statErr := os.Stat(spec); statErr != nil {
+		return nil, fmt.Errorf("expected dir, repo, a template such as {dir} or a mapping file, got %q", spec)
+	}
+	groups, err := loadPatternMap(spec, "group")
+	if err != nil {
+		return nil, err
+	}
+	return func(target string) string {
+		group, _ := groups.command(target)
+		return group
+	}, nil
+}
+
+// parseGroupLimits parses -group-limit values of the form NAME=N.
+func parseGroupLimits(values []string) (map[string]int, error) {
+	limits := make(map[string]int, len(values))
+	for _, value := range values {
+		name, n, ok := strings.Cut(value, "=")
+		limit, err := strconv.Atoi(n)
+		if !ok || name == "" || err != nil || limit < 1 {
+			return nil, fmt.Errorf("expected NAME=N with N at least 1, got %q", value)
+		}
+		limits[name] = limit
+	}
+	return limits, nil
+}
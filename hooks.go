@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// runHook runs a -pre-cmd or -post-cmd command once through shell, in the
+// current directory and attached to the terminal, with the extra
+// environment variables env.
+func runHook(ctx context.Context, shell []string, command string, env []string) error {
+	if len(shell) == 0 {
+		shell = executor.DefaultShell()
+	}
+	args := append(shell[1:len(shell):len(shell)], command)
+	cmd := exec.CommandContext(ctx, shell[0], args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.Run()
+}
+
+// summaryEnv describes the outcome of a run to the -post-cmd command. status
+// is that of runStatus, or "error" if the run could not start.
+func summaryEnv(rep *report, status string) []string {
+	return []string{
+		"EXECUTOR_STATUS=" + status,
+		fmt.Sprintf("EXECUTOR_TOTAL=%d", rep.Total),
+		fmt.Sprintf("EXECUTOR_SUCCEEDED=%d", rep.Succeeded),
+		fmt.Sprintf("EXECUTOR_FAILED=%d", rep.Failed),
+		fmt.Sprintf("EXECUTOR_TIMED_OUT=%d", rep.TimedOut),
+		fmt.Sprintf("EXECUTOR_SKIPPED=%d", rep.Skipped),
+		fmt.Sprintf("EXECUTOR_STOPPED=%d", rep.Stopped),
+		fmt.Sprintf("EXECUTOR_WALL_TIME_MS=%d", rep.WallTimeMS),
+	}
+}
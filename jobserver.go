@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobserverEnv names the FIFO of the jobserver for the runs nested in the
+// commands.
+const jobserverEnv = "EXECUTOR_JOBSERVER"
+
+// errNoFIFO is returned where named pipes, and so jobservers, are not
+// supported.
+var errNoFIFO = errors.New("named pipes are not supported on this system")
+
+// jobserverPoll is how often a task waiting for a token checks whether the
+// process's own slot became free or the run was aborted.
+const jobserverPoll = 100 * time.Millisecond
+
+// jobserver shares a budget of concurrent tasks between executor and the
+// executor runs nested in its commands, like the jobserver of GNU make. Each
+// process runs one task on the slot it was started on; the others take a
+// token from a FIFO created by the outermost run with one token per worker
+// beyond the first, and put it back when done.
+type jobserver struct {
+	path  string
+	file  *os.File
+	owner bool
+
+	mu          sync.Mutex
+	implicit    bool          // whether the process's own slot is taken
+	reading     chan struct{} // held by the task waiting for a slot
+	tokens      int           // tokens of the FIFO this run created
+	withdrawing int           // tokens to take back after shrinking
+}
+
+// newJobserver creates a jobserver for workers concurrent tasks, or fails with
+// errNoFIFO where it cannot.
+func newJobserver(workers int) (*jobserver, error) {
+	dir, err := os.MkdirTemp("", "executor-jobserver-")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "fifo")
+	if err := mkfifo(path); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	j, err := openJobserver(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	j.owner = true
+	j.tokens = workers - 1
+	if _, err := j.file.Write(bytes.Repeat([]byte{'+'}, workers-1)); err != nil {
+		j.close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// openJobserver joins the jobserver with the FIFO at path. It is opened for
+// reading and writing, which doesn't wait for the other end.
+func openJobserver(path string) (*jobserver, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &jobserver{path: path, file: file, reading: make(chan struct{}, 1)}, nil
+}
+
+// enclosingJobserver returns the FIFO of the jobserver of an enclosing
+// executor or make run, if any. make only shares a FIFO as of version 4.4.
+func enclosingJobserver() string {
+	if path := os.Getenv(jobserverEnv); path != "" {
+		return path
+	}
+	for _, arg := range strings.Fields(os.Getenv("MAKEFLAGS")) {
+		if path, ok := strings.CutPrefix(arg, "--jobserver-auth=fifo:"); ok {
+			return path
+		}
+	}
+	return ""
+}
+
+// acquire is the executor.Executor.Acquire hook. The task waiting longest
+// takes the process's own slot as soon as it is free, or else the first
+// token. A token that cannot be read for another reason than the run being
+// aborted is not waited for, so that a broken jobserver doesn't stop the run.
+func (j *jobserver) acquire(ctx context.Context) (func(), error) {
+	select {
+	case j.reading <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-j.reading }()
+
+	token := make([]byte, 1)
+	for {
+		j.mu.Lock()
+		free := !j.implicit
+		j.implicit = true
+		j.mu.Unlock()
+		if free {
+			return func() {
+				j.mu.Lock()
+				j.implicit = false
+				j.mu.Unlock()
+			}, nil
+		}
+
+		j.file.SetReadDeadline(time.Now().Add(jobserverPoll))
+		n, err := j.file.Read(token)
+		if n == 1 {
+			return func() { j.file.Write(token) }, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			warnf(os.Stderr, "cannot read from jobserver %s: %v", j.path, err)
+			return func() {}, nil
+		}
+	}
+}
+
+// resize changes the budget of a jobserver this run created to workers
+// tasks. Tokens beyond the new budget are taken back as they are returned.
+func (j *jobserver) resize(workers int) {
+	if !j.owner {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delta := workers - 1 - j.tokens
+	j.tokens = workers - 1
+	if delta < 0 {
+		if j.withdrawing == 0 {
+			go j.withdraw()
+		}
+		j.withdrawing -= delta
+		return
+	}
+	kept := min(delta, j.withdrawing)
+	j.withdrawing -= kept
+	j.file.Write(bytes.Repeat([]byte{'+'}, delta-kept))
+}
+
+// withdraw reads tokens from the FIFO until none are to be taken back. It
+// takes turns with the tasks waiting in acquire, as they share the read
+// deadline.
+func (j *jobserver) withdraw() {
+	token := make([]byte, 1)
+	for {
+		j.mu.Lock()
+		pending := j.withdrawing
+		j.mu.Unlock()
+		if pending == 0 {
+			return
+		}
+
+		j.reading <- struct{}{}
+		j.file.SetReadDeadline(time.Now().Add(jobserverPoll))
+		n, err := j.file.Read(token)
+		<-j.reading
+		if n == 1 {
+			j.mu.Lock()
+			if j.withdrawing > 0 {
+				j.withdrawing--
+			} else {
+				// The budget grew again in the meantime
+				j.file.Write(token)
+			}
+			j.mu.Unlock()
+		}
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+	}
+}
+
+// close leaves the jobserver, removing its FIFO if this run created it.
+func (j *jobserver) close() error {
+	err := j.file.Close()
+	if j.owner {
+		os.RemoveAll(filepath.Dir(j.path))
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// mkfifo creates a named pipe at path, which these platforms lack.
+func mkfifo(path string) error {
+	return errNoFIFO
+}
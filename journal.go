@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// journalEntry is one line of the journal.
+type journalEntry struct {
+	Time         time.Time `json:"time"`
+	Event        string    `json:"event"`
+	Target       string    `json:"target"`
+	Host         string    `json:"host,omitempty"`
+	Index        int       `json:"index"`
+	Worker       int       `json:"worker"`
+	Attempt      int       `json:"attempt,omitempty"`
+	DelayMS      int64     `json:"delay_ms,omitempty"`
+	ExitCode     *int      `json:"exit_code,omitempty"`
+	TimedOut     bool      `json:"timed_out,omitempty"`
+	VerifyFailed bool      `json:"verify_failed,omitempty"`
+	DurationMS   int64     `json:"duration_ms,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+
+	Metadata *targetMetadata `json:"metadata,omitempty"`
+}
+
+// journal appends a JSON line to a file for every task event as it
+// happens. Each line is a single write, so whatever ends executor, the
+// journal records everything up to that point.
+type journal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openJournal opens the journal at path, appending to an existing one.
+func openJournal(path string) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{file: file}, nil
+}
+
+// handle is the executor event handler that records events.
+func (j *journal) handle(ev executor.Event) {
+	entry := journalEntry{Time: time.Now(), Target: ev.Target, Index: ev.Index, Worker: ev.Worker}
+	switch ev.Type {
+	case executor.TaskStarted:
+		entry.Event = "started"
+	case executor.TaskRetrying:
+		entry.Event = "retried"
+		entry.Attempt = ev.Attempt
+		entry.DelayMS = ev.Delay.Milliseconds()
+		entry.Error = ev.Err.Error()
+	case executor.TaskFinished:
+		result := ev.Result
+		entry.Event = "finished"
+		if result.Err != nil {
+			entry.Event = "failed"
+			entry.Error = result.Err.Error()
+		}
+		entry.Host = result.Host
+		entry.Attempt = result.Attempts
+		entry.ExitCode = &result.ExitCode
+		entry.TimedOut = result.TimedOut
+		entry.VerifyFailed = result.VerifyFailed
+		entry.DurationMS = result.Duration.Milliseconds()
+		entry.Metadata = newTargetMetadata(result.Metadata)
+	case executor.TaskSkipped:
+		entry.Event = "skipped"
+		entry.Reason = ev.Result.SkipReason
+	default:
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		warnf(os.Stderr, "cannot write journal: %v", err)
+	}
+}
+
+// close closes the journal file.
+func (j *journal) close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// junitSuites is the root of a JUnit XML report, in the form understood by
+// Jenkins, GitLab and most other CI systems.
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string         `xml:"name,attr"`
+	ClassName string         `xml:"classname,attr"`
+	Time      string         `xml:"time,attr"`
+	Failure   *junitProblem  `xml:"failure"`
+	Error     *junitProblem  `xml:"error"`
+	Skipped   *junitSkipped  `xml:"skipped"`
+	SystemOut *junitCharData `xml:"system-out"`
+	SystemErr *junitCharData `xml:"system-err"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitCharData struct {
+	Text string `xml:",chardata"`
+}
+
+// writeJUnit saves results as a JUnit XML report to path, with one test case
+// per target. Failed commands are failures, commands stopped by an abort are
+// errors, and targets that were not run are skipped.
+func writeJUnit(path, command string, results executor.Results, wallTime time.Duration) error {
+	suite := junitSuite{
+		Name:     "executor: " + command,
+		Tests:    len(results),
+		Failures: results.Failed(),
+		Errors:   results.Cancelled(),
+		Skipped:  results.Skipped(),
+		Time:     junitTime(wallTime),
+	}
+	for _, result := range results {
+		tc := junitCase{
+			Name:      result.Target,
+			ClassName: "executor",
+			Time:      junitTime(result.Duration),
+		}
+		switch {
+		case result.Skipped:
+			tc.Skipped = &junitSkipped{Message: result.SkipReason}
+		case result.Cancelled:
+			tc.Error = &junitProblem{Message: "stopped: " + result.Err.Error(), Text: string(result.Output)}
+		case result.Err != nil:
+			problemType := "exit"
+			if result.TimedOut {
+				problemType = "timeout"
+			}
+			tc.Failure = &junitProblem{
+				Message: result.Err.Error(),
+				Type:    problemType,
+				Text:    string(result.Output),
+			}
+		}
+		if len(result.Stdout) > 0 {
+			tc.SystemOut = &junitCharData{Text: string(result.Stdout)}
+		}
+		if len(result.Stderr) > 0 {
+			tc.SystemErr = &junitCharData{Text: string(result.Stderr)}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	data, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), data...)
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// junitTime formats a duration in seconds as JUnit reports expect.
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
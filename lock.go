@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockedError reports that another run holds the lock on a tree.
+type lockedError struct {
+	root string
+	pid  string
+}
+
+func (e *lockedError) Error() string {
+	if e.pid == "" {
+		return fmt.Sprintf("another run holds the lock on %s", e.root)
+	}
+	return fmt.Sprintf("another run (PID %s) holds the lock on %s", e.pid, e.root)
+}
+
+// heldLock is the lock file of the tree, kept open until the process exits.
+var heldLock *os.File
+
+// lockPath returns the lock file of the runs on root. It is kept in the
+// temporary directory rather than in the tree, which is left alone.
+func lockPath(root string) string {
+	sum := sha256.Sum256([]byte(absPath(root)))
+	return filepath.Join(os.TempDir(), "executor-"+hex.EncodeToString(sum[:8])+".lock")
+}
+
+// lockTree takes the advisory lock on root for the rest of the process,
+// which releases it when it exits. If another run holds it, lockTree waits
+// for it with wait set, or else fails with a *lockedError.
+func lockTree(root string, wait bool) error {
+	file, err := os.OpenFile(lockPath(root), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	locked, err := lockFile(file, false)
+	if err == nil && !locked {
+		holder := &lockedError{root: absPath(root), pid: lockHolder(file)}
+		if !wait {
+			file.Close()
+			return holder
+		}
+		logger.Info("Waiting for the lock held by another run", "pid", holder.pid, "root", holder.root)
+		_, err = lockFile(file, true)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// Record the holder for the runs that find it locked
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	heldLock = file
+	return nil
+}
+
+// lockHolder returns the PID recorded in a lock file, if any.
+func lockHolder(file *os.File) string {
+	data := make([]byte, 32)
+	n, _ := file.ReadAt(data, 0)
+	return strings.TrimSpace(string(data[:n]))
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile takes an exclusive advisory lock on f, which these platforms
+// don't support here.
+func lockFile(f *os.File, wait bool) (bool, error) {
+	return false, errors.New("file locks are not supported on this system")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// levelTrace is the level of the task details logged with -vv.
+const levelTrace = slog.LevelDebug - 4
+
+// logger logs the progress of executor to stderr, apart from the results of
+// the tasks on stdout, at the level selected by -q, -v and -vv.
+var logger = newLogger(os.Stderr, slog.LevelInfo)
+
+// newLogger returns a logger writing the records of level and above to w as
+// text. Times are only included at levelTrace, as the records are mostly
+// read on a terminal as they are written.
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch {
+			case a.Key == slog.TimeKey && level > levelTrace:
+				return slog.Attr{}
+			case a.Key == slog.LevelKey && a.Value.Any() == levelTrace:
+				return slog.String(slog.LevelKey, "TRACE")
+			}
+			return a
+		},
+	}))
+}
+
+// logLevel returns the level selected by the verbosity flags: -q leaves out
+// everything but warnings and errors, -v adds what each worker does and -vv
+// the details of every task.
+func logLevel(quiet, verbose, veryVerbose bool) slog.Level {
+	switch {
+	case quiet:
+		return slog.LevelWarn
+	case veryVerbose:
+		return levelTrace
+	case verbose:
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
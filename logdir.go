@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// maxLogName keeps log file names, including their suffix, within the limits
+// of common filesystems.
+const maxLogName = 200
+
+// manifestEntry describes the log files of one target in manifest.json.
+type manifestEntry struct {
+	Target     string `json:"target"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exit_code"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// logDir writes the stdout and stderr of every finished task to separate
+// files in a directory, and a manifest.json indexing them. The files are
+// written as the commands run, so they hold the complete output whatever
+// -max-output-bytes keeps in memory.
+type logDir struct {
+	mu       sync.Mutex
+	dir      string
+	manifest []manifestEntry
+	saved    map[string]bool // names whose files were opened by save
+}
+
+// newLogDir creates dir if needed.
+func newLogDir(dir string) (*logDir, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &logDir{dir: dir, saved: make(map[string]bool)}, nil
+}
+
+// save is the executor.Executor.SaveOutput hook creating the log files of
+// target.
+func (l *logDir) save(target string, index int) (stdout, stderr io.WriteCloser) {
+	name := logName(target)
+	l.mu.Lock()
+	l.saved[name] = true
+	l.mu.Unlock()
+
+	files := make([]io.WriteCloser, 2)
+	for i, suffix := range []string{".stdout", ".stderr"} {
+		f, err := os.Create(filepath.Join(l.dir, name+suffix))
+		if err != nil {
+			warnf(os.Stderr, "cannot write log: %v", err)
+			continue
+		}
+		files[i] = f
+	}
+	return files[0], files[1]
+}
+
+// handle is the executor event handler that writes the logs of finished tasks.
+func (l *logDir) handle(ev executor.Event) {
+	if ev.Type != executor.TaskFinished || ev.Result.Skipped {
+		return
+	}
+	result := ev.Result
+
+	// The targets of a batch share the logs of the first
+	name := logName(result.Target)
+	if len(result.Batch) > 1 {
+		name = logName(result.Batch[0])
+	}
+	entry := manifestEntry{
+		Target:     result.Target,
+		Stdout:     name + ".stdout",
+		Stderr:     name + ".stderr",
+		ExitCode:   result.ExitCode,
+		DurationMS: result.Duration.Milliseconds(),
+	}
+	if result.Err != nil {
+		entry.Error = result.Err.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.manifest = append(l.manifest, entry)
+
+	// Commands that never started have no logs yet
+	if l.saved[name] {
+		return
+	}
+	for file, data := range map[string][]byte{entry.Stdout: result.Stdout, entry.Stderr: result.Stderr} {
+		if err := os.WriteFile(filepath.Join(l.dir, file), data, 0o644); err != nil {
+			warnf(os.Stderr, "cannot write log: %v", err)
+		}
+	}
+}
+
+// close writes manifest.json.
+func (l *logDir) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := json.MarshalIndent(l.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(l.dir, "manifest.json"), append(data, '\n'), 0o644)
+}
+
+// logName turns a target path into a file name that is unique per target:
+// characters other than letters, digits, '.', '_' and '-' are
+// percent-encoded, so "a/b" and "a_b" can never collide. Names too long for
+// the filesystem are shortened and suffixed with a hash of the full name.
+func logName(target string) string {
+	var b strings.Builder
+	for _, c := range []byte(filepath.ToSlash(target)) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+			b.WriteByte(c)
+		case c == '.' && b.Len() > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	name := b.String()
+	if len(name) > maxLogName {
+		sum := sha256.Sum256([]byte(name))
+		name = name[:maxLogName-17] + "~" + hex.EncodeToString(sum[:8])
+	}
+	return name
+}
@@ -1,159 +1,1936 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
-	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/truemilk/executor/executor"
 )
 
-var (
-	executionCount int32
-	totalTasks    int32
+// Exit codes reported by executor.
+const (
+	exitSuccess     = 0   // every task succeeded
+	exitTaskFailure = 1   // at least one task failed or the run was aborted
+	exitSetupError  = 2   // invalid flags, pattern errors or nothing to process
+	exitInterrupted = 130 // stopped by SIGINT or SIGTERM
 )
 
 func main() {
-	command := flag.String("cmd", "", "Command to execute")
-	workers := flag.Int("workers", 4, "Number of concurrent workers")
-	pattern := flag.String("pattern", "", "Path pattern (e.g., '*/src' or '**.go')")
-	dirsOnly := flag.Bool("dirs-only", false, "Only process directories")
-	filesOnly := flag.Bool("files-only", false, "Only process files")
-	flag.Parse()
+	executor.SandboxInit()
+
+	cmdMapPath := flag.String("cmd-map", "", "YAML file mapping path patterns to commands, e.g. '\"*.go\": gofmt -l {}'; targets matching none run -cmd, or are skipped without it")
+	command := flag.String("cmd", "", "Command to execute ({}, {dir}, {base}, {name}, {ext} and {abs} are replaced with the target path quoted for the shell, {raw} with it unquoted, {tmp} with the -scratch directory, or use Go template syntax such as {{.Path}}, {{.Index}} and {{quote .Dir}})")
+	workersFlag := flag.String("workers", "4", "Number of concurrent workers, or 'auto' for one per CPU, held back while the load exceeds the number of CPUs")
+	maxLoad := flag.Float64("max-load", 0, "Hold back new tasks while the system load average exceeds this (0 means no limit)")
+	minFreeMem := flag.String("min-free-mem", "", "Hold back new tasks while less memory than this is available, e.g. '512M'")
+	requiredSpace := flag.String("require-free-space", "", "Hold back each task while the filesystem of its target has less space available than this, e.g. '10G', and skip it if no running task is left to free some (Linux and macOS)")
+	var patterns, intersects, excludePatterns stringList
+	flag.Var(&patterns, "pattern", "Path pattern (e.g., '*/src' or '**.go'); '**' matches any number of directories (repeatable, for the paths matching any of them)")
+	flag.Var(&intersects, "intersect", "Keep only the paths that also match this pattern (repeatable, for the paths matching all of them)")
+	flag.Var(&excludePatterns, "exclude-pattern", "Drop the paths that match this whole pattern, e.g. '**/vendor/**', unlike -exclude which skips them while matching (repeatable)")
+	targetsPath := flag.String("targets", "", "Read the targets from this file, one per line, or one task per row of a .csv or .tsv file whose columns are available as {1}, {2}, ..., or list the objects under an s3://BUCKET/PREFIX; targets that are http(s) or s3 URLs are available as {url} and {key}")
+	header := flag.Bool("columns", false, "Name the columns of the -targets table after its first row, available as {NAME}")
+	gitRepos := flag.String("git-repos", "", "Process every git repository found below this directory instead of matching a pattern")
+	where := flag.String("where", "", "Only process the paths for which this expression holds, e.g. 'isdir && name =~ \"^lib\"' or 'size > 1MB && age < 7d' (fields: isdir, isfile, islink, path, name, dir, ext, size, depth, age)")
+	dirsOnly := flag.Bool("dirs-only", false, "Only process directories (short for -where isdir)")
+	filesOnly := flag.Bool("files-only", false, "Only process paths that are not directories, special files included (short for -where '!isdir'; -where isfile keeps regular files only)")
+	fileTypes := flag.String("type", "", "Only process paths of these types: 'f' (regular files), 'd' (directories), 'l' (symbolic links), or several such as 'f,l'")
+	minDepth := flag.Int("min-depth", 0, "Only process paths at least this many levels below the fixed start of the -pattern flags (or the -git-repos directory)")
+	maxDepth := flag.Int("max-depth", -1, "Only process paths at most this many levels below the fixed start of the -pattern flags (or the -git-repos directory); -1 means no limit")
+	timeout := flag.Duration("timeout", 0, "Stop a command that runs longer than this and count it as failed (0 means no limit)")
+	retries := flag.Int("retries", 0, "Number of times to retry a failed command")
+	retryDelay := flag.Duration("retry-delay", time.Second, "Delay before the first retry, doubled for each further attempt")
+	failFast := flag.Bool("fail-fast", false, "Stop all remaining tasks as soon as one command fails")
+	maxFailures := flag.Int("max-failures", 0, "Stop all remaining tasks after this many failed commands (0 means no limit)")
+	quietSuccess := flag.Bool("quiet-success", false, "Suppress the output of commands that succeed")
+	onlyFailures := flag.Bool("only-failures", false, "Print nothing about commands that succeed, not even their progress")
+	grep := flag.String("grep", "", "Only print the results of commands whose output matches this regular expression")
+	followSymlinks := flag.Bool("follow-symlinks", false, "Descend into symbolic links to directories when matching '**' patterns or searching -git-repos, skipping links that loop")
+	allowDuplicates := flag.Bool("allow-duplicates", false, "Process a path once for every match, even when several matches resolve to the same file")
+	skipSymlinks := flag.Bool("skip-symlinks", false, "Leave out paths that are symbolic links")
+	skipListPath := flag.String("skip-list", defaultSkipList(), "File of the targets and patterns to leave out of every run, kept with 'executor skip'; empty disables it")
+	showSkipped := flag.Bool("show-skipped", false, "Log each target left out because of the -skip-list file, with the reason it was added")
+	useGitignore := flag.Bool("gitignore", false, "Skip paths ignored by .gitignore files")
+	var excludes stringList
+	flag.Var(&excludes, "exclude", "Skip paths matching this pattern, e.g. 'node_modules' or '*.min.js' (repeatable)")
+	useJobserver := flag.Bool("jobserver", true, "Share the -workers budget with executor runs nested in the commands through a jobserver, and join that of an enclosing executor or make")
+	rate := flag.Float64("rate", 0, "Maximum number of tasks started per second across all workers (0 means no limit)")
+	jitter := flag.Duration("jitter", 0, "Delay each task start by a random duration up to this value")
+	emitScript := flag.String("emit-script", "", "Write every expanded command to this shell script instead of running them, to review, keep or hand them to another scheduler")
+	emitMake := flag.String("emit-make", "", "Write every expanded command to this makefile instead of running them, with a rule per target that make can run in parallel")
+	metadata := flag.Bool("metadata", false, "Record the size, modification time, owner and, for git repositories, the branch and whether it is dirty of each target in the -report and -journal files; templates get them as {{.Size}}, {{.ModTime}}, {{.Owner}}, {{.GitBranch}} and {{.GitDirty}} either way")
+	seedFlag := flag.Uint64("seed", 0, "Seed of the random choices of -order random and -jitter, to schedule a run the same way as one whose report recorded it (default: picked at random)")
+	shell := flag.String("shell", "", "Interpreter used to run the command, e.g. 'bash' or 'powershell -Command' (default: sh -c, or cmd /C on Windows)")
+	noShell := flag.Bool("no-shell", false, "Run the command directly instead of through a shell; it is split into arguments before placeholders are replaced")
+	statePath := flag.String("state", "", "Record successfully processed targets in this JSON file")
+	resume := flag.Bool("resume", false, "Skip targets recorded as successful in the -state file")
+	grace := flag.Duration("grace", executor.DefaultGracePeriod, "Time running commands get to exit after an interrupt before they are killed")
+	newerThan := flag.String("newer-than", "", "Only process paths modified within this duration, e.g. '24h' or '7d'")
+	olderThan := flag.String("older-than", "", "Only process paths last modified longer ago than this duration")
+	minSize := flag.String("min-size", "", "Only process files at least this large, e.g. '10K' or '1.5MB'")
+	maxSize := flag.String("max-size", "", "Only process files at most this large")
+	var exts stringList
+	flag.Var(&exts, "ext", "Only process paths with one of these extensions, e.g. 'go' or 'jpg,png' (repeatable)")
+	workDir := flag.String("workdir", "", "Working directory of the commands: 'target' (the target, or the parent of a file), 'parent', 'cwd' (don't change), or a path that may contain placeholders such as {dir} (default: target, or cwd with -batch)")
+	ifContains := flag.String("if-contains", "", "Only process files whose contents match this regular expression")
+	ifNotContains := flag.String("if-not-contains", "", "Only process files whose contents don't match this regular expression")
+	stdinFile := flag.String("stdin-file", "", "Feed the contents of this file to the standard input of every command")
+	stdinData := flag.String("stdin-data", "", "Feed this text to the standard input of every command")
+	pipe := flag.Bool("pipe", false, "Feed the contents of each target file to the standard input of its command")
+	var sshHosts stringList
+	flag.Var(&sshHosts, "ssh", "Run the commands on this remote host over SSH, e.g. 'user@host' (repeatable)")
+	sshMode := flag.String("ssh-mode", sshRoundRobin, "How targets are spread over the -ssh hosts: 'round-robin' or 'all' (every target on every host)")
+	sshOpts := flag.String("ssh-opts", "", "Extra options for the ssh client, e.g. '-p 2222 -i key'")
+	nice := flag.Int("nice", 0, "Run the commands with this niceness, e.g. 10 for a lower priority (not supported on Windows)")
+	sandbox := flag.Bool("sandbox", false, "Run the commands without network access, on a read-only filesystem except for their target and -scratch directory, and without capabilities (Linux only)")
+	ionice := flag.Bool("ionice", false, "Run the commands at idle IO priority, so disk-heavy ones don't slow down the rest of the machine (Linux and macOS)")
+	cpuLimit := flag.Duration("cpu-limit", 0, "Limit the processor time of each command's processes, e.g. '5m' (not supported on Windows)")
+	memLimit := flag.String("mem-limit", "", "Limit the memory of each command's processes, e.g. '2G' (not supported on Windows)")
+	dockerImage := flag.String("docker", "", "Run each command in a fresh container of this image, with the target bind-mounted at its absolute path")
+	var dockerMounts stringList
+	flag.Var(&dockerMounts, "docker-mount", "Extra bind mount for -docker containers as SOURCE:TARGET[:OPTIONS] (repeatable)")
+	dockerNetwork := flag.String("docker-network", "", "Network mode of -docker containers, e.g. 'none' or 'host'")
+	dockerCPUs := flag.String("docker-cpus", "", "CPU limit of each -docker container, e.g. '1.5'")
+	dockerMemory := flag.String("docker-memory", "", "Memory limit of each -docker container, e.g. '512m'")
+	scratch := flag.Bool("scratch", false, "Give each task a fresh temporary directory, available as {tmp} and EXECUTOR_TMP and removed when it ends")
+	keepScratch := flag.Bool("keep-scratch-on-failure", false, "Keep the -scratch directories of failed tasks for inspection")
+	var pathMaps stringList
+	flag.Var(&pathMaps, "map", "Rewrite the value substituted for {}: 'strip-prefix:P', 'strip-suffix:S', 'prefix:P', 'suffix:S', 'rel[:BASE]', 'abs', 'slash' or 's/RE/REPL/' (repeatable, applied in order)")
+	var env stringList
+	flag.Var(&env, "env", "Set an environment variable for the commands as KEY=VALUE (repeatable)")
+	cacheDir := flag.String("cache-dir", "", "Remember in this directory the contents of each target whose command succeeded, and skip the targets unchanged since for the same command")
+	journalPath := flag.String("journal", "", "Append a JSON line to this file for every task started, retried, finished, failed or skipped, as it happens")
+	diffBaseline := flag.String("diff-baseline", "", "Compare the stdout of each task with the baseline file for its target in this directory, and report only the outputs that changed, are new or were removed")
+	updateBaseline := flag.Bool("update-baseline", false, "Write the stdout of each task to the -diff-baseline directory as its new baseline instead of comparing")
+	logDirPath := flag.String("log-dir", "", "Write each task's stdout and stderr to separate files in this directory, indexed by manifest.json")
+	shard := flag.String("shard", "", "Only process the i-th of n shards of the targets, given as 'i/n', to split a run across machines")
+	order := flag.String("order", "", "Schedule the targets by 'size' (largest first), 'mtime' (newest first), 'name' or 'random' instead of in the order they were found")
+	keepOrder := flag.Bool("keep-order", false, "Print results in the order of the targets rather than as they finish")
+	batch := flag.Int("batch", 0, "Run the command once per this many targets, with each placeholder replaced by all their paths like xargs")
+	depsPath := flag.String("deps", "", "YAML file mapping targets to the targets that must succeed before them")
+	depsParents := flag.Bool("deps-parents", false, "Run targets only after the targets among their parent directories succeeded")
+	reportPath := flag.String("report", "", "Write the final summary as JSON to this file")
+	csvPath := flag.String("report-csv", "", "Write a CSV file with the exit code, duration, retries and output sizes of each target")
+	junitPath := flag.String("report-junit", "", "Write a JUnit XML report with one test case per target to this file")
+	notifyURL := flag.String("notify-url", "", "POST a JSON summary to this webhook URL (e.g. Slack or Discord) when the run ends")
+	notifyFailures := flag.Bool("notify-failures", false, "Also POST to the -notify-url webhook for each failed target")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics of the run on this address, e.g. ':9090', at /metrics")
+	webAddr := flag.String("web", "", "Serve a status page of the run on this address, e.g. ':8080', showing what each worker runs, the progress, recent failures and the JSON report so far")
+	groupBy := flag.String("group-by", "", "Put each target in a concurrency group: 'dir', 'repo', a template such as '{dir}' as for -partition-by, or a YAML file mapping path patterns to group names")
+	var groupLimits stringList
+	flag.Var(&groupLimits, "group-limit", "Run at most N tasks of a -group-by group at once, given as 'NAME=N', e.g. 'nfs=2'; groups without a limit only share -workers (repeatable)")
+	partitionBy := flag.String("partition-by", "", "Run targets sharing a key one after another, as on one worker: 'dir' for the directory the command runs in, 'repo' for the git repository, or a template such as '{dir}'")
+	lock := flag.Bool("lock", false, "Fail if another run with -lock works on the same pattern root, so that they can't touch the same directories at once")
+	waitForLock := flag.Bool("wait-for-lock", false, "Wait for the other run to finish instead of failing with -lock")
+	benchWorkers := flag.String("bench-workers", "1,2,4,8,16", "The worker counts 'executor bench' compares")
+	verbose := flag.Bool("v", false, "Log what each worker does to stderr")
+	veryVerbose := flag.Bool("vv", false, "Log the details of every task to stderr, with times, on top of -v")
+	quiet := flag.Bool("q", false, "Log only warnings and errors to stderr, leaving out the progress of the run")
+	runWindowFlag := flag.String("run-window", "", "Only start tasks during this time of day, e.g. '22:00-06:00', and wait outside of it without stopping running commands")
+	controlPath := flag.String("control", "", "Listen on this unix socket for 'executor ctl' to pause, resume, resize or inspect the run")
+	preCmd := flag.String("pre-cmd", "", "Command run once before the targets are collected, e.g. to mount a share; executor stops if it fails")
+	pluginDir := flag.String("plugin-dir", defaultPluginDir(), "Directory of plugin executables, called with JSON on stdin when targets are discovered and tasks start and end, and able to skip targets or rewrite their command; empty disables plugins")
+	postCmd := flag.String("post-cmd", "", "Command run once after the last task, with the outcome in EXECUTOR_STATUS, EXECUTOR_SUCCEEDED, EXECUTOR_FAILED, ...")
+	testCmd := flag.String("test-cmd", "", "Check command run before -cmd for each target, with the same placeholders; targets for which it fails are skipped")
+	var stageFlags stringList
+	flag.Var(&stageFlags, "stage", "Run this stage of a pipeline for every target instead of -cmd, given as 'NAME: COMMAND'; each target goes through the stages in the order given until one fails (repeatable)")
+	verifyCmd := flag.String("verify-cmd", "", "Check command run after -cmd succeeds for each target, with the same placeholders, e.g. 'test -s {dir}/{name}.out'; the task fails unless it succeeds too")
+	interactive := flag.Bool("interactive", false, "Show each expanded command and ask whether to run it (y/n/all/quit)")
+	stream := flag.Bool("stream", false, "Print each line of output as soon as it is written, prefixed with its target")
+	maxOutput := flag.String("max-output-bytes", "", "Keep at most this much of each command's stdout and stderr in memory, e.g. '1M'; -log-dir still gets all of it")
+	truncate := flag.String("truncate-output", "tail", "Which part of the output -max-output-bytes keeps: 'head' or 'tail'")
+	outputEncoding := flag.String("output-encoding", "auto", "Encoding of the output of the commands, converted to UTF-8: 'auto' (UTF-8, UTF-16 or else Windows-1252), 'none' to keep it as written, or one of "+strings.Join(encodings, ", "))
+	stdoutOnly := flag.Bool("stdout-only", false, "Show only what the commands write to stdout")
+	stderrOnly := flag.Bool("stderr-only", false, "Show only what the commands write to stderr, such as their diagnostics")
+	color := colorMode(colorAuto)
+	flag.Var(&color, "color", "Color the output: 'auto' (when printing to a terminal and NO_COLOR is not set), 'always' or 'never'")
+	useTUI := flag.Bool("tui", false, "Show a live status display (falls back to plain output when stdout is not a terminal)")
+	watch := flag.Bool("watch", false, "Keep running after processing the targets and run the command again for each target that changes")
+	watchDebounce := flag.Duration("watch-debounce", 500*time.Millisecond, "With -watch, wait until a changed target has been left alone for this long before running the command")
+	watchInterval := flag.Duration("watch-interval", 2*time.Second, "With -watch, where the system cannot notify changes, how often to check the top level of the targets; every 15 checks go through them whole")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), `Usage:
+  executor [run [PROFILE]] [flags]   process the targets
+  executor resume STATE [flags]      run again what the -state file STATE recorded, skipping completed targets
+  executor rerun-failed --from REPORT [flags]
+                                     run again what the -report file REPORT recorded, for its failed targets only
+  executor report FILE               print the summary of a -report file
+  executor bench [flags]             run the targets at each of the -bench-workers counts and recommend one
+  executor ctl SOCKET status|pause|resume|workers N
+                                     inspect or steer the run serving the -control socket SOCKET
+  executor skip add [-reason TEXT] TARGET|PATTERN...
+  executor skip remove TARGET|PATTERN...
+  executor skip list                 keep the targets that every run leaves out in the -skip-list file
+  executor completion SHELL          write a completion script for bash, zsh or fish
+  executor version                   print the version of executor
+  executor self-update [-check] [-force]
+                                     replace executor with the latest release if newer, or any other with -force,
+                                     after verifying its checksum and, if a key is built in, its signature
+
+Flags:
+`)
+		flag.PrintDefaults()
+	}
+
+	args := os.Args[1:]
+	var subcommand, profile string
+	var benchmark bool
+	var given []string // the flags to record in the -state and -report files
+	var rerun map[string]bool
+	if len(args) > 0 && slices.Contains(subcommands, args[0]) {
+		subcommand, args = args[0], args[1:]
+	}
+	switch subcommand {
+	case "run":
+		// "executor run PROFILE [flags]" starts from the flags of a profile
+		// in the configuration file
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "Usage: executor run [PROFILE] [flags]")
+			if err := listProfiles(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			os.Exit(exitSetupError)
+		}
+		if !strings.HasPrefix(args[0], "-") {
+			profile, args = args[0], args[1:]
+		}
+		given = args
+
+	case "resume":
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			fmt.Fprintln(os.Stderr, "Usage: executor resume STATE [flags]")
+			os.Exit(exitSetupError)
+		}
+		path := args[0]
+		recorded, err := readState(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading state file: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		if recorded.Command == "" && recorded.Args == nil {
+			fmt.Fprintf(os.Stderr, "%s does not record how its run was started\n", path)
+			os.Exit(exitSetupError)
+		}
+		profile, given = recorded.Profile, append(recorded.Args, args[1:]...)
+		args = append([]string{"-cmd", recorded.Command}, given...)
+		args = append(args, "-state", path, "-resume")
+
+	case "rerun-failed":
+		if len(args) < 2 || (args[0] != "-from" && args[0] != "--from") {
+			fmt.Fprintln(os.Stderr, "Usage: executor rerun-failed --from REPORT [flags]")
+			os.Exit(exitSetupError)
+		}
+		path := args[1]
+		rep, err := readReport(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading report: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		if rep.Args == nil {
+			fmt.Fprintf(os.Stderr, "%s does not record how its run was started\n", path)
+			os.Exit(exitSetupError)
+		}
+		if len(rep.Failures) == 0 {
+			logger.Info("No targets failed", "report", path)
+			os.Exit(exitSuccess)
+		}
+		rerun = make(map[string]bool)
+		for _, entry := range rep.Failures {
+			rerun[absPath(entry.Target)] = true
+		}
+		profile, given = rep.Profile, append(rep.Args, args[2:]...)
+		args = append([]string{"-cmd", rep.Command}, given...)
+
+	case "report":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: executor report FILE")
+			os.Exit(exitSetupError)
+		}
+		rep, err := readReport(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading report: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		rep.print(os.Stdout)
+		os.Exit(exitSuccess)
+
+	case "bench":
+		benchmark, given = true, args
+
+	case "ctl":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: executor ctl SOCKET status|pause|resume|workers N")
+			os.Exit(exitSetupError)
+		}
+		if err := runCtl(os.Stdout, args[0], args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		os.Exit(exitSuccess)
+
+	case "skip":
+		if err := runSkip(os.Stdout, defaultSkipList(), args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintln(os.Stderr, "Usage: executor skip add [-reason TEXT] TARGET...|remove TARGET...|list")
+			os.Exit(exitSetupError)
+		}
+		os.Exit(exitSuccess)
+
+	case "version":
+		printVersion(os.Stdout)
+		os.Exit(exitSuccess)
+
+	case "self-update":
+		fs := flag.NewFlagSet("self-update", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		check := fs.Bool("check", false, "")
+		force := fs.Bool("force", false, "")
+		if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+			fmt.Fprintln(os.Stderr, "Usage: executor self-update [-check] [-force]")
+			os.Exit(exitSetupError)
+		}
+		if err := selfUpdate(os.Stdout, *check, *force); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		os.Exit(exitSuccess)
+
+	case "completion":
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "Usage: executor completion %s\n", strings.Join(completionShells, "|"))
+			os.Exit(exitSetupError)
+		}
+		if err := writeCompletion(os.Stdout, args[0], flag.CommandLine); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		os.Exit(exitSuccess)
+
+	default:
+		given = args
+	}
+	flag.CommandLine.Parse(args)
+	if profile != "" {
+		if err := applyProfile(flag.CommandLine, profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	warningColor = color
+	if *quiet && (*verbose || *veryVerbose) {
+		fmt.Fprintln(os.Stderr, "Cannot combine -q with -v or -vv")
+		os.Exit(exitSetupError)
+	}
+	logger = newLogger(os.Stderr, logLevel(*quiet, *verbose, *veryVerbose))
+
+	var stages []executor.Stage
+	if len(stageFlags) > 0 {
+		var err error
+		if stages, err = parseStages(stageFlags); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -stage: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	if stages != nil && (*command != "" || *cmdMapPath != "" || *batch > 1 || *verifyCmd != "") {
+		fmt.Fprintln(os.Stderr, "Cannot combine -stage with -cmd, -cmd-map, -batch or -verify-cmd")
+		os.Exit(exitSetupError)
+	}
+	if *command == "" && *cmdMapPath == "" && stages == nil {
+		fmt.Fprintln(os.Stderr, "Please provide a command using -cmd flag (or a -cmd-map file or -stage pipeline)")
+		os.Exit(exitSetupError)
+	}
+
+	sources := 0
+	for _, source := range []string{strings.Join(patterns, ""), *gitRepos, *targetsPath} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources == 0 {
+		fmt.Fprintln(os.Stderr, "Please provide a path pattern using -pattern flag (or a directory to search with -git-repos, or a -targets file)")
+		os.Exit(exitSetupError)
+	}
+	if sources > 1 {
+		fmt.Fprintln(os.Stderr, "Only one of -pattern, -git-repos and -targets can be used")
+		os.Exit(exitSetupError)
+	}
+	if (len(intersects) > 0 || len(excludePatterns) > 0) && len(patterns) == 0 {
+		fmt.Fprintln(os.Stderr, "The -intersect and -exclude-pattern flags require a -pattern")
+		os.Exit(exitSetupError)
+	}
+
+	if *sshMode != sshRoundRobin && *sshMode != sshAllHosts {
+		fmt.Fprintf(os.Stderr, "Invalid -ssh-mode %q: expected %q or %q\n", *sshMode, sshRoundRobin, sshAllHosts)
+		os.Exit(exitSetupError)
+	}
+
+	if *sshMode == sshAllHosts && (*depsPath != "" || *depsParents) {
+		fmt.Fprintln(os.Stderr, "Cannot combine -ssh-mode all with -deps or -deps-parents")
+		os.Exit(exitSetupError)
+	}
+
+	if *dockerImage != "" && len(sshHosts) > 0 {
+		fmt.Fprintln(os.Stderr, "Cannot combine -docker with -ssh")
+		os.Exit(exitSetupError)
+	}
+
+	if *header && *targetsPath == "" {
+		fmt.Fprintln(os.Stderr, "The -columns flag requires a -targets file")
+		os.Exit(exitSetupError)
+	}
+
+	var shardIndex, shardCount int
+	if *shard != "" {
+		var err error
+		if shardIndex, shardCount, err = parseShard(*shard); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -shard: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+
+	if *truncate != "head" && *truncate != "tail" {
+		fmt.Fprintf(os.Stderr, "Invalid -truncate-output %q: expected head or tail\n", *truncate)
+		os.Exit(exitSetupError)
+	}
+
+	if *order != orderNone && !slices.Contains(orders, *order) {
+		fmt.Fprintf(os.Stderr, "Invalid -order %q: expected one of %s\n", *order, strings.Join(orders, ", "))
+		os.Exit(exitSetupError)
+	}
+
+	if *retries < 0 {
+		fmt.Fprintln(os.Stderr, "The -retries flag cannot be negative")
+		os.Exit(exitSetupError)
+	}
+
+	if *timeout < 0 {
+		fmt.Fprintln(os.Stderr, "The -timeout flag cannot be negative")
+		os.Exit(exitSetupError)
+	}
+
+	if *maxFailures < 0 {
+		fmt.Fprintln(os.Stderr, "The -max-failures flag cannot be negative")
+		os.Exit(exitSetupError)
+	}
+
+	if *failFast {
+		*maxFailures = 1
+	}
+
+	for _, kv := range env {
+		if key, _, ok := strings.Cut(kv, "="); !ok || key == "" {
+			fmt.Fprintf(os.Stderr, "Invalid -env %q: expected KEY=VALUE\n", kv)
+			os.Exit(exitSetupError)
+		}
+	}
+
+	if *batch < 0 {
+		fmt.Fprintln(os.Stderr, "The -batch flag cannot be negative")
+		os.Exit(exitSetupError)
+	}
+
+	if *batch > 1 && (*depsPath != "" || *depsParents || *testCmd != "" || *cmdMapPath != "") {
+		fmt.Fprintln(os.Stderr, "Cannot combine -batch with -deps, -deps-parents, -test-cmd or -cmd-map")
+		os.Exit(exitSetupError)
+	}
+
+	if benchmark && (*watch || *interactive) {
+		fmt.Fprintln(os.Stderr, "Cannot combine bench with -watch or -interactive")
+		os.Exit(exitSetupError)
+	}
+	var benchCounts []int
+	if benchmark {
+		var err error
+		if benchCounts, err = parseWorkerCounts(*benchWorkers); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -bench-workers: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+
+	if len(groupLimits) > 0 && *groupBy == "" {
+		fmt.Fprintln(os.Stderr, "The -group-limit flag requires -group-by")
+		os.Exit(exitSetupError)
+	}
+
+	if *batch > 1 && (*partitionBy != "" || *groupBy != "") {
+		fmt.Fprintln(os.Stderr, "Cannot combine -batch with -partition-by or -group-by")
+		os.Exit(exitSetupError)
+	}
+
+	if *waitForLock && !*lock {
+		fmt.Fprintln(os.Stderr, "The -wait-for-lock flag requires -lock")
+		os.Exit(exitSetupError)
+	}
+	randomized := *order == orderRandom || *jitter > 0
+	if *seedFlag != 0 && !randomized {
+		fmt.Fprintln(os.Stderr, "The -seed flag requires -order random or -jitter")
+		os.Exit(exitSetupError)
+	}
+	seed := *seedFlag
+	for randomized && seed == 0 {
+		seed = rand.Uint64()
+	}
+	if randomized {
+		logger.Info("Scheduling at random", "seed", seed)
+	}
+
+	emitting := *emitScript != "" || *emitMake != ""
+	if *emitScript != "" && *emitMake != "" {
+		fmt.Fprintln(os.Stderr, "Cannot combine -emit-script with -emit-make")
+		os.Exit(exitSetupError)
+	}
+	if emitting && (benchmark || *watch || len(sshHosts) > 0 || *dockerImage != "" || *sandbox || *scratch || *stdinData != "" || *stdinFile != "") {
+		fmt.Fprintln(os.Stderr, "Cannot combine -emit-script or -emit-make with bench, -watch, -ssh, -docker, -sandbox, -scratch, -stdin-data or -stdin-file")
+		os.Exit(exitSetupError)
+	}
+
+	if *notifyFailures && *notifyURL == "" {
+		fmt.Fprintln(os.Stderr, "The -notify-failures flag requires a -notify-url")
+		os.Exit(exitSetupError)
+	}
+
+	inputs := 0
+	for _, set := range []bool{*stdinFile != "", *stdinData != "", *pipe} {
+		if set {
+			inputs++
+		}
+	}
+	if inputs > 1 {
+		fmt.Fprintln(os.Stderr, "Only one of -stdin-file, -stdin-data and -pipe can be used")
+		os.Exit(exitSetupError)
+	}
+
+	if *pipe && *batch > 1 {
+		fmt.Fprintln(os.Stderr, "Cannot combine -pipe with -batch")
+		os.Exit(exitSetupError)
+	}
+
+	if *resume && *statePath == "" {
+		fmt.Fprintln(os.Stderr, "The -resume flag requires a -state file")
+		os.Exit(exitSetupError)
+	}
+
+	if *interactive && *useTUI {
+		fmt.Fprintln(os.Stderr, "Cannot combine -interactive with -tui")
+		os.Exit(exitSetupError)
+	}
+
+	if *stream && (*grep != "" || *onlyFailures) {
+		fmt.Fprintln(os.Stderr, "Cannot combine -stream with -grep or -only-failures")
+		os.Exit(exitSetupError)
+	}
 
-	if *command == "" {
-		fmt.Println("Please provide a command using -cmd flag")
-		os.Exit(1)
+	if *keepScratch && !*scratch {
+		fmt.Fprintln(os.Stderr, "The -keep-scratch-on-failure flag requires -scratch")
+		os.Exit(exitSetupError)
 	}
 
-	if *pattern == "" {
-		fmt.Println("Please provide a path pattern using -pattern flag")
-		os.Exit(1)
+	if *scratch && len(sshHosts) > 0 {
+		fmt.Fprintln(os.Stderr, "Cannot combine -scratch with -ssh")
+		os.Exit(exitSetupError)
+	}
+
+	if *stdoutOnly && *stderrOnly {
+		fmt.Fprintln(os.Stderr, "Cannot combine -stdout-only with -stderr-only")
+		os.Exit(exitSetupError)
+	}
+
+	if *sandbox && (len(sshHosts) > 0 || *dockerImage != "") {
+		fmt.Fprintln(os.Stderr, "Cannot combine -sandbox with -ssh or -docker")
+		os.Exit(exitSetupError)
+	}
+
+	if *cacheDir != "" && len(sshHosts) > 0 {
+		fmt.Fprintln(os.Stderr, "Cannot combine -cache-dir with -ssh")
+		os.Exit(exitSetupError)
+	}
+
+	if *updateBaseline && *diffBaseline == "" {
+		fmt.Fprintln(os.Stderr, "The -update-baseline flag requires -diff-baseline")
+		os.Exit(exitSetupError)
+	}
+
+	if *diffBaseline != "" && (*batch > 1 || *maxOutput != "") {
+		fmt.Fprintln(os.Stderr, "Cannot combine -diff-baseline with -batch or -max-output-bytes")
+		os.Exit(exitSetupError)
+	}
+
+	if *watch && (*interactive || *useTUI || len(sshHosts) > 0) {
+		fmt.Fprintln(os.Stderr, "Cannot combine -watch with -interactive, -tui or -ssh")
+		os.Exit(exitSetupError)
+	}
+
+	if *watchDebounce < 0 {
+		fmt.Fprintln(os.Stderr, "The -watch-debounce flag cannot be negative")
+		os.Exit(exitSetupError)
+	}
+
+	if *watchInterval <= 0 {
+		fmt.Fprintln(os.Stderr, "The -watch-interval flag must be positive")
+		os.Exit(exitSetupError)
 	}
 
 	if *dirsOnly && *filesOnly {
-		fmt.Println("Cannot specify both -dirs-only and -files-only")
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "Cannot specify both -dirs-only and -files-only")
+		os.Exit(exitSetupError)
+	}
+
+	if *followSymlinks && *skipSymlinks {
+		fmt.Fprintln(os.Stderr, "Cannot specify both -follow-symlinks and -skip-symlinks")
+		os.Exit(exitSetupError)
+	}
+
+	filter := targetFilter{
+		minDepth: *minDepth,
+		maxDepth: *maxDepth,
+		minSize:  -1,
+		maxSize:  -1,
+		exts:     parseExts(exts),
+	}
+
+	var err error
+	var conditions []string
+	if *dirsOnly {
+		conditions = append(conditions, "isdir")
+	}
+	if *filesOnly {
+		// Not isfile: -files-only has always kept everything that is not a
+		// directory, and the paths are stat'ed, so links to files are files
+		conditions = append(conditions, "!isdir")
+	}
+	if *where != "" {
+		conditions = append(conditions, "("+*where+")")
+	}
+	if len(conditions) > 0 {
+		if filter.where, err = parseWhere(strings.Join(conditions, " && ")); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -where: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	if filter.types, err = parseTypes(*fileTypes); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -type: %v\n", err)
+		os.Exit(exitSetupError)
+	}
+	if *minDepth < 0 || (*maxDepth >= 0 && *maxDepth < *minDepth) {
+		fmt.Fprintln(os.Stderr, "The -min-depth flag cannot be negative or exceed -max-depth")
+		os.Exit(exitSetupError)
+	}
+	if *targetsPath != "" && (*minDepth > 0 || *maxDepth >= 0) {
+		// Listed targets have no pattern root to count levels from
+		fmt.Fprintln(os.Stderr, "Cannot combine -targets with -min-depth or -max-depth")
+		os.Exit(exitSetupError)
+	}
+	if *newerThan != "" {
+		if filter.newerThan, err = parseAge(*newerThan); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -newer-than: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	if *olderThan != "" {
+		if filter.olderThan, err = parseAge(*olderThan); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -older-than: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	if *minSize != "" {
+		if filter.minSize, err = parseSize(*minSize); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -min-size: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	if *maxSize != "" {
+		if filter.maxSize, err = parseSize(*maxSize); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -max-size: %v\n", err)
+			os.Exit(exitSetupError)
+		}
 	}
 
-	// Find matching paths
-	matches, err := filepath.Glob(*pattern)
+	workers, auto, err := parseWorkers(*workersFlag)
 	if err != nil {
-		fmt.Printf("Error with pattern matching: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Invalid -workers: %v\n", err)
+		os.Exit(exitSetupError)
+	}
+	if *maxLoad < 0 {
+		fmt.Fprintln(os.Stderr, "The -max-load flag cannot be negative")
+		os.Exit(exitSetupError)
+	}
+	if auto && *maxLoad == 0 {
+		*maxLoad = float64(workers)
+	}
+	var outputLimit int64
+	if *maxOutput != "" {
+		if outputLimit, err = parseSize(*maxOutput); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -max-output-bytes: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	var decode func([]byte) []byte
+	if decode, err = parseEncoding(*outputEncoding); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -output-encoding: %v\n", err)
+		os.Exit(exitSetupError)
+	}
+	var memoryLimit int64
+	if *memLimit != "" {
+		if memoryLimit, err = parseSize(*memLimit); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -mem-limit: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	var minFree int64
+	if *minFreeMem != "" {
+		if minFree, err = parseSize(*minFreeMem); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -min-free-mem: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	var checkSpace func(target string) error
+	if *requiredSpace != "" {
+		if !freeSpaceSupported {
+			fmt.Fprintln(os.Stderr, "The -require-free-space flag is not supported on this system")
+			os.Exit(exitSetupError)
+		}
+		if len(sshHosts) > 0 {
+			fmt.Fprintln(os.Stderr, "Cannot combine -require-free-space with -ssh")
+			os.Exit(exitSetupError)
+		}
+		var space int64
+		if space, err = parseSize(*requiredSpace); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -require-free-space: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		checkSpace = requireFreeSpace(space)
+	}
+
+	var partitionKey func(target string) string
+	if *partitionBy != "" {
+		if partitionKey, err = parsePartition(*partitionBy); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -partition-by: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+
+	var groupKey func(target string) string
+	var limits map[string]int
+	if *groupBy != "" {
+		if groupKey, err = parseGroupBy(*groupBy); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -group-by: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		if limits, err = parseGroupLimits(groupLimits); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -group-limit: %v\n", err)
+			os.Exit(exitSetupError)
+		}
 	}
 
-	// Add tilde expansion before glob matching
-	if strings.HasPrefix(*pattern, "~") {
-		homeDir, err := os.UserHomeDir()
+	var window *runWindow
+	if *runWindowFlag != "" {
+		w, err := parseRunWindow(*runWindowFlag)
 		if err != nil {
-			fmt.Printf("Error getting home directory: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Invalid -run-window: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		window = &w
+	}
+
+	var maps []pathMap
+	for _, expr := range pathMaps {
+		m, err := parsePathMap(expr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -map: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		maps = append(maps, m)
+	}
+
+	var grepOutput *regexp.Regexp
+	if *grep != "" {
+		if grepOutput, err = regexp.Compile(*grep); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -grep: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+
+	if *ifContains != "" {
+		if filter.contains, err = regexp.Compile(*ifContains); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -if-contains: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	if *ifNotContains != "" {
+		if filter.notContains, err = regexp.Compile(*ifNotContains); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -if-not-contains: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+
+	var input []byte
+	if *stdinData != "" {
+		input = []byte(*stdinData)
+	}
+	if *stdinFile != "" {
+		if input, err = os.ReadFile(*stdinFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading -stdin-file: %v\n", err)
+			os.Exit(exitSetupError)
 		}
-		*pattern = filepath.Join(homeDir, strings.TrimPrefix(*pattern, "~"))
-		matches, err = filepath.Glob(*pattern)
+	}
+
+	// Expand a leading tilde to the home directory before matching
+	paths := []*string{gitRepos, targetsPath}
+	for _, list := range []stringList{patterns, intersects, excludePatterns} {
+		for i := range list {
+			paths = append(paths, &list[i])
+		}
+	}
+	for _, path := range paths {
+		expanded, err := expandHome(*path)
 		if err != nil {
-			fmt.Printf("Error with pattern matching: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error getting home directory: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		*path = expanded
+	}
+
+	// Leave out excluded and git-ignored paths while matching, so recursive
+	// patterns don't descend into them at all
+	filter.root = *gitRepos
+	if filter.root == "" {
+		filter.root = commonRoot(patterns)
+	}
+	if *lock {
+		if err := lockTree(filter.root, *waitForLock); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot lock %s: %v\n", filter.root, err)
+			os.Exit(exitSetupError)
+		}
+	}
+	var ignore *gitignore
+	if *useGitignore {
+		ignore = newGitignore(filter.root)
+	}
+	var skips *skipList
+	if *skipListPath != "" {
+		if skips, err = loadSkipList(*skipListPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading -skip-list: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	skip := func(path string, isDir bool) bool {
+		return excluded(path, excludes) || (ignore != nil && ignore.ignored(path, isDir)) ||
+			(*skipSymlinks && isSymlink(path)) || (skips != nil && skips.skips(path))
+	}
+
+	// Set up what the targets need before looking for them
+	if *preCmd != "" {
+		if err := runHook(context.Background(), parseShell(*shell), *preCmd, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Pre command failed: %v\n", err)
+			os.Exit(exitSetupError)
 		}
 	}
 
-	if len(matches) == 0 {
-		fmt.Printf("No matches found for pattern: %s\n", *pattern)
-		os.Exit(1)
+	// Find matching paths, the repositories below the -git-repos root, or
+	// the listed targets
+	var matches []string
+	var listed *targetList
+	if *targetsPath != "" {
+		listed, err = loadTargets(*targetsPath, *header)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading -targets: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		if len(listed.targets) == 0 {
+			fmt.Fprintf(os.Stderr, "No targets listed in: %s\n", *targetsPath)
+			os.Exit(exitSetupError)
+		}
+		if skips != nil {
+			var kept []string
+			var columns [][]string
+			for i, target := range listed.targets {
+				if skips.skips(target) {
+					continue
+				}
+				kept = append(kept, target)
+				if listed.columns != nil {
+					columns = append(columns, listed.columns[i])
+				}
+			}
+			listed.targets = kept
+			if listed.columns != nil {
+				listed.columns = columns
+			}
+		}
+		matches = listed.targets
+	} else if *gitRepos != "" {
+		matches, err = findGitRepos(*gitRepos, skip, *followSymlinks)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error searching for git repositories: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		if len(matches) == 0 {
+			fmt.Fprintf(os.Stderr, "No git repositories found in: %s\n", *gitRepos)
+			os.Exit(exitSetupError)
+		}
+	} else {
+		matches, err = globSet(patterns, intersects, excludePatterns, skip, *followSymlinks)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error with pattern matching: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		if len(matches) == 0 {
+			fmt.Fprintf(os.Stderr, "No matches found for pattern: %s\n", strings.Join(patterns, ", "))
+			os.Exit(exitSetupError)
+		}
 	}
 
-	// Filter paths based on flags
+	// Filter paths based on flags. Listed targets only go through the
+	// filters given, as they may not exist yet, and URLs through none.
 	var targets []string
-	for _, match := range matches {
+	var columns [][]string
+	now := time.Now()
+	for i, match := range matches {
+		keep := func() {
+			targets = append(targets, match)
+			if listed != nil && listed.columns != nil {
+				columns = append(columns, listed.columns[i])
+			}
+		}
+		if listed != nil {
+			if _, isURL := urlKey(match); isURL {
+				keep()
+				continue
+			}
+			if excluded(match, excludes) || (*skipSymlinks && isSymlink(match)) {
+				continue
+			}
+			if !filter.active() {
+				keep()
+				continue
+			}
+		}
+
 		info, err := os.Stat(match)
 		if err != nil {
-			fmt.Printf("Warning: Cannot stat %s: %v\n", match, err)
+			warnf(os.Stderr, "Cannot stat %s: %v", match, err)
 			continue
 		}
 
-		isDir := info.IsDir()
-		if (*dirsOnly && isDir) || (*filesOnly && !isDir) || (!*dirsOnly && !*filesOnly) {
-			targets = append(targets, match)
+		if !filter.match(match, info, now) {
+			continue
+		}
+		ok, err := filter.matchContent(match, info)
+		if err != nil {
+			warnf(os.Stderr, "Cannot read %s: %v", match, err)
+			continue
 		}
+		if ok {
+			keep()
+		}
+	}
+	if listed != nil {
+		listed.columns = columns
 	}
 
+	if skips != nil && len(skips.skipped) > 0 {
+		if *showSkipped {
+			for _, entry := range skips.skipped {
+				logger.Info("Skipped by the skip list", "target", entry.target, "reason", entry.reason)
+			}
+		} else {
+			logger.Info("Leaving out paths on the skip list (see -show-skipped)", "paths", len(skips.skipped))
+		}
+	}
 	if len(targets) == 0 {
-		fmt.Println("No matching targets found after filtering")
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "No matching targets found after filtering")
+		os.Exit(exitSetupError)
 	}
 
-	// Set total tasks before creating workers
-	atomic.StoreInt32(&totalTasks, int32(len(targets)))
-	fmt.Printf("Found %d targets to process\n", len(targets))
+	// Repeat a previous run for the targets that failed in it
+	if rerun != nil {
+		var failed []string
+		var columns [][]string
+		for i, target := range targets {
+			if !rerun[absPath(target)] {
+				continue
+			}
+			failed = append(failed, target)
+			if listed != nil && listed.columns != nil {
+				columns = append(columns, listed.columns[i])
+			}
+		}
+		if listed != nil {
+			listed.columns = columns
+		}
+		logger.Info("Rerunning the targets that failed", "found", len(failed), "failed", len(rerun))
+		if len(failed) == 0 {
+			fmt.Fprintln(os.Stderr, "None of the failed targets were found again")
+			os.Exit(exitSetupError)
+		}
+		targets = failed
+	}
 
-	// Create a channel for tasks
-	tasks := make(chan string, len(targets))
-	var wg sync.WaitGroup
+	// Run once per file, however many paths lead to it. The rows of a table
+	// are all kept, as they can differ in their other columns.
+	if !*allowDuplicates && (listed == nil || listed.columns == nil) {
+		kept := dedupeTargets(targets)
+		if collapsed := len(targets) - len(kept); collapsed > 0 {
+			logger.Info("Collapsed duplicate targets resolving to the same path (use -allow-duplicates to keep them)", "duplicates", collapsed)
+		}
+		targets = kept
+	}
 
-	// Start workers
-	for i := 0; i < *workers; i++ {
-		wg.Add(1)
-		go worker(i, tasks, &wg, *command)
+	// Without a default command, only the targets with a command of their
+	// own are processed
+	var cmdMap *commandMap
+	if *cmdMapPath != "" {
+		if cmdMap, err = loadCommandMap(*cmdMapPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading command map: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+	}
+	if cmdMap != nil && *command == "" {
+		var mapped []string
+		var columns [][]string
+		for i, target := range targets {
+			if _, ok := cmdMap.command(target); !ok {
+				continue
+			}
+			mapped = append(mapped, target)
+			if listed != nil && listed.columns != nil {
+				columns = append(columns, listed.columns[i])
+			}
+		}
+		if listed != nil {
+			listed.columns = columns
+		}
+		if unmapped := len(targets) - len(mapped); unmapped > 0 {
+			logger.Info("Skipping targets that match no pattern of the command map", "targets", unmapped)
+		}
+		if len(mapped) == 0 {
+			fmt.Fprintln(os.Stderr, "No targets match a pattern of the command map")
+			os.Exit(exitSetupError)
+		}
+		targets = mapped
 	}
 
-	// Send tasks to workers
-	for _, target := range targets {
-		tasks <- target
+	// Keep this machine's share of the targets
+	if shardCount > 0 {
+		index := shardTargets(targets, shardIndex, shardCount)
+		kept := make([]string, len(index))
+		for i, j := range index {
+			kept[i] = targets[j]
+		}
+		if listed != nil && listed.columns != nil {
+			columns := make([][]string, len(index))
+			for i, j := range index {
+				columns[i] = listed.columns[j]
+			}
+			listed.columns = columns
+		}
+		logger.Info("Running a shard of the targets", "shard", fmt.Sprintf("%d/%d", shardIndex, shardCount), "targets", len(kept), "of", len(targets))
+		if len(kept) == 0 {
+			logger.Info("No targets in this shard")
+			os.Exit(exitSuccess)
+		}
+		targets = kept
 	}
-	close(tasks)
 
-	// Wait for all workers to complete
-	wg.Wait()
+	// Schedule the targets in the requested order
+	if *order != orderNone {
+		index := orderTargets(targets, *order, seed)
+		sorted := make([]string, len(index))
+		for i, j := range index {
+			sorted[i] = targets[j]
+		}
+		if listed != nil && listed.columns != nil {
+			columns := make([][]string, len(index))
+			for i, j := range index {
+				columns[i] = listed.columns[j]
+			}
+			listed.columns = columns
+		}
+		targets = sorted
+	}
+
+	// Skip the targets a previous run already completed
+	var state *stateFile
+	if *statePath != "" {
+		state, err = openState(*statePath, *command, *resume)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading state file: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		if state.state.Command != *command {
+			warnf(os.Stderr, "%s was recorded for a different command: %s", *statePath, state.state.Command)
+		}
+		state.state.Profile, state.state.Args = profile, given
+
+		var pending []string
+		var columns [][]string
+		for i, target := range targets {
+			if state.completed(target) {
+				continue
+			}
+			pending = append(pending, target)
+			if listed != nil && listed.columns != nil {
+				columns = append(columns, listed.columns[i])
+			}
+		}
+		if listed != nil {
+			listed.columns = columns
+		}
+		if skipped := len(targets) - len(pending); skipped > 0 {
+			logger.Info("Resuming: skipping targets completed by a previous run", "targets", skipped)
+		}
+		if len(pending) == 0 {
+			logger.Info("All targets were completed by a previous run")
+			os.Exit(exitSuccess)
+		}
+		targets = pending
+		state.autosave()
+	}
+
+	// Let the plugins skip targets and rewrite their commands
+	var plug *plugins
+	if *pluginDir != "" {
+		if plug, err = loadPlugins(*pluginDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading plugins: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		if len(plug.paths) == 0 {
+			plug = nil
+		}
+	}
+	// commandOf returns the command template of target
+	rewritten := map[string]string{}
+	commandOf := func(target string) string {
+		if command, ok := rewritten[target]; ok {
+			return command
+		}
+		if cmdMap != nil {
+			if command, ok := cmdMap.command(target); ok {
+				return command
+			}
+		}
+		if stages != nil {
+			return describeStages(stages)
+		}
+		return *command
+	}
+	if plug != nil {
+		logger.Info("Using plugins", "plugins", strings.Join(plug.names(), ","))
+		index, commands, err := plug.discover(targets, commandOf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Plugin error: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		if len(commands) > 0 && (*batch > 1 || stages != nil) {
+			fmt.Fprintln(os.Stderr, "Plugins cannot rewrite commands with -batch or -stage")
+			os.Exit(exitSetupError)
+		}
+		kept := make([]string, len(index))
+		for i, j := range index {
+			kept[i] = targets[j]
+		}
+		if listed != nil && listed.columns != nil {
+			columns := make([][]string, len(index))
+			for i, j := range index {
+				columns[i] = listed.columns[j]
+			}
+			listed.columns = columns
+		}
+		if len(kept) == 0 {
+			logger.Info("Plugins skipped all targets")
+			os.Exit(exitSuccess)
+		}
+		targets, rewritten = kept, commands
+	}
+
+	// commandsFor returns the command of each target that has its own,
+	// or nil if none does
+	commandsFor := func(targets []string) []string {
+		var commands []string
+		if cmdMap != nil {
+			commands = cmdMap.commandsFor(targets)
+		}
+		if len(rewritten) == 0 {
+			return commands
+		}
+		if commands == nil {
+			commands = make([]string, len(targets))
+		}
+		for i, target := range targets {
+			if command, ok := rewritten[target]; ok {
+				commands[i] = command
+			}
+		}
+		return commands
+	}
+
+	// Rewrite the targets into the values the command is given for them
+	mapped, err := mapPaths(targets, maps)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error applying -map: %v\n", err)
+		os.Exit(exitSetupError)
+	}
+
+	// pathsFor returns the value substituted for {} of each target, or nil
+	// if they are the targets themselves
+	pathsFor := func(targets []string) []string {
+		if len(mapped) == 0 {
+			return nil
+		}
+		paths := slices.Clone(targets)
+		for i, target := range targets {
+			if path, ok := mapped[target]; ok {
+				paths[i] = path
+			}
+		}
+		return paths
+	}
+
+	// Skip the targets unchanged since their command last succeeded
+	var cache *resultCache
+	if *cacheDir != "" {
+		cache, err = openCache(*cacheDir, func(target string) string {
+			if path, ok := mapped[target]; ok {
+				return commandOf(target) + "\x00" + path
+			}
+			return commandOf(target)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening cache: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+
+		var changed []string
+		var columns [][]string
+		for i, target := range targets {
+			if cache.unchanged(target) {
+				continue
+			}
+			changed = append(changed, target)
+			if listed != nil && listed.columns != nil {
+				columns = append(columns, listed.columns[i])
+			}
+		}
+		if listed != nil {
+			listed.columns = columns
+		}
+		if unchanged := len(targets) - len(changed); unchanged > 0 {
+			logger.Info("Skipping targets unchanged since their command succeeded", "targets", unchanged)
+		}
+		if len(changed) == 0 {
+			logger.Info("All targets are unchanged")
+			os.Exit(exitSuccess)
+		}
+		targets = changed
+	}
+
+	logger.Info("Found targets to process", "targets", len(targets))
+
+	// Collect the dependencies between targets
+	var deps map[string][]string
+	if *depsParents {
+		deps = parentDeps(targets)
+	}
+	if *depsPath != "" {
+		fileDeps, err := loadDeps(*depsPath, targets)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading dependency file: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		if deps == nil {
+			deps = make(map[string][]string)
+		}
+		for target, prereqs := range fileDeps {
+			deps[target] = append(deps[target], prereqs...)
+		}
+	}
+
+	// Spread the targets over the remote hosts
+	var pool *sshPool
+	var hosts []string
+	if len(sshHosts) > 0 {
+		if pool, err = newSSHPool(sshHosts, *sshOpts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting up SSH: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+
+		var index []int
+		targets, hosts, index = pool.assign(targets, *sshMode)
+		if listed != nil && listed.columns != nil {
+			columns := make([][]string, len(index))
+			for i, j := range index {
+				columns[i] = listed.columns[j]
+			}
+			listed.columns = columns
+		}
+		logger.Info("Running tasks on remote hosts", "tasks", len(targets), "hosts", len(sshHosts))
+	}
+
+	// Keep nested runs within the budget of the outermost one
+	var jobs *jobserver
+	if *useJobserver && !emitting {
+		if path := enclosingJobserver(); path != "" {
+			if jobs, err = openJobserver(path); err != nil {
+				warnf(os.Stderr, "cannot join jobserver %s: %v", path, err)
+			}
+		} else if jobs, err = newJobserver(workers); err != nil && !errors.Is(err, errNoFIFO) {
+			warnf(os.Stderr, "cannot create jobserver: %v", err)
+		}
+	}
+
+	output := outputOptions{quietSuccess: *quietSuccess, onlyFailures: *onlyFailures, grep: grepOutput, stream: *stream, color: color.enabled(os.Stdout), stdoutOnly: *stdoutOnly, stderrOnly: *stderrOnly}
+	runner := &executor.Executor{
+		Workers:           workers,
+		Timeout:           *timeout,
+		Retries:           *retries,
+		RetryDelay:        *retryDelay,
+		MaxFailures:       *maxFailures,
+		Rate:              *rate,
+		Jitter:            *jitter,
+		Seed:              seed,
+		Shell:             parseShell(*shell),
+		Direct:            *noShell,
+		WorkDir:           *workDir,
+		Input:             input,
+		PipeTarget:        *pipe,
+		Nice:              *nice,
+		IdleIO:            *ionice,
+		Sandbox:           *sandbox,
+		Stages:            stages,
+		CPULimit:          *cpuLimit,
+		MemoryLimit:       memoryLimit,
+		MaxOutput:         int(outputLimit),
+		KeepOutputTail:    *truncate == "tail",
+		Decode:            decode,
+		Metadata:          *metadata,
+		Env:               env,
+		Scratch:           *scratch,
+		KeepFailedScratch: *keepScratch,
+		Hosts:             hosts,
+		GracePeriod:       *grace,
+		Batch:             *batch,
+		Deps:              deps,
+		Test:              *testCmd,
+		Verify:            *verifyCmd,
+		Stream:            *stream,
+		Overloaded:        overloaded(*maxLoad, minFree),
+		CheckSpace:        checkSpace,
+		OnEvent:           printer(len(targets), *retries+1, output),
+	}
+
+	if pool != nil {
+		runner.SSH = pool.client
+	}
+	if jobs != nil {
+		runner.Acquire = jobs.acquire
+		runner.Env = append(runner.Env, jobserverEnv+"="+jobs.path)
+	}
+	runner.Commands = commandsFor(targets)
+	runner.Paths = pathsFor(targets)
+	if partitionKey != nil {
+		runner.Partitions = partitionsOf(targets, partitionKey)
+	}
+	if groupKey != nil {
+		runner.Groups, runner.GroupLimits = partitionsOf(targets, groupKey), limits
+	}
+	if *dockerImage != "" {
+		runner.Container = &executor.Container{
+			Image:   *dockerImage,
+			Mounts:  dockerMounts,
+			Network: *dockerNetwork,
+			CPUs:    *dockerCPUs,
+			Memory:  *dockerMemory,
+		}
+	}
+
+	// Listed targets need not be paths, so commands stay in the current
+	// directory unless told otherwise
+	if listed != nil {
+		runner.Columns = listed.columns
+		runner.ColumnNames = listed.names
+		if *workDir == "" {
+			runner.WorkDir = executor.WorkDirCurrent
+		}
+	}
+
+	// Write the commands out for later instead of running them
+	if emitting {
+		tasks, err := runner.Plan(targets, *command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error planning commands: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		path, perm, write := *emitScript, os.FileMode(0o755), writeScript
+		if *emitMake != "" {
+			path, perm, write = *emitMake, 0o644, writeMakefile
+		}
+		if err := emitPlan(path, perm, write, tasks, env, runner.Shell); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing commands: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		logger.Info("Wrote the commands instead of running them", "tasks", len(tasks), "file", path)
+		os.Exit(exitSuccess)
+	}
+
+	// Render the live display only when there is a terminal to draw on
+	var display *tui
+	if *useTUI && isTerminal(os.Stdout) && !benchmark {
+		display = newTUI(os.Stdout, len(targets), workers, output)
+		runner.OnEvent = display.handle
+	}
+	var ordered *orderedEvents
+	if *keepOrder {
+		ordered = newOrderedEvents(runner.OnEvent)
+		runner.OnEvent = ordered.handle
+	}
+
+	// Handlers that follow every event besides the display of progress
+	var observers []func(executor.Event)
+	if state != nil {
+		observers = append(observers, state.handle)
+	}
+	var logs *logDir
+	if *logDirPath != "" {
+		if logs, err = newLogDir(*logDirPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating log directory: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		observers = append(observers, logs.handle)
+		runner.SaveOutput = logs.save
+	}
+	var golden *baseline
+	if *diffBaseline != "" {
+		if golden, err = newBaseline(*diffBaseline, *updateBaseline, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -diff-baseline: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		observers = append(observers, golden.handle)
+	}
+	var record *journal
+	if *journalPath != "" {
+		if record, err = openJournal(*journalPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening journal: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		observers = append(observers, record.handle)
+	}
+
+	var notify *notifier
+	if *notifyURL != "" {
+		if notify, err = newNotifier(*notifyURL, *notifyFailures); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -notify-url: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		observers = append(observers, notify.handle)
+	}
+	if plug != nil {
+		observers = append(observers, plug.handle)
+	}
+	if cache != nil {
+		observers = append(observers, cache.handle)
+	}
+	var stats *metrics
+	if *metricsAddr != "" {
+		if stats, err = serveMetrics(*metricsAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving metrics: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		stats.plan(len(targets))
+		observers = append(observers, stats.handle)
+	}
+	var dashboard *webServer
+	if *webAddr != "" {
+		if dashboard, err = serveWeb(*webAddr, *command, profile, given, seed); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving status page: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		dashboard.plan(len(targets))
+		observers = append(observers, dashboard.handle)
+	}
+	var steering *controlServer
+	if *controlPath != "" {
+		runner.Control = &executor.Control{}
+		var resize func(int)
+		if jobs != nil {
+			resize = jobs.resize
+		}
+		if steering, err = serveControl(*controlPath, runner.Control, workers, resize); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving control socket: %v\n", err)
+			os.Exit(exitSetupError)
+		}
+		steering.plan(len(targets))
+		observers = append(observers, steering.handle)
+	}
+	runner.OnEvent = chainEvents(append([]func(executor.Event){runner.OnEvent}, observers...)...)
+
+	// Stop gracefully on the first SIGINT or SIGTERM: no new tasks are
+	// started and running commands receive the same signal. A second signal
+	// terminates executor immediately.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		logger.Warn("Waiting for running commands to stop (send again to exit immediately)", "signal", sig.String())
+		cancel(executor.Interrupted{Signal: sig})
+	}()
+	if *interactive {
+		runner.Confirm = newPrompter(func() { cancel(errQuit) }).confirm
+	}
+	if window != nil {
+		if runner.Control == nil {
+			runner.Control = &executor.Control{}
+		}
+		window.enforce(ctx, runner.Control)
+	}
+	if plug != nil {
+		// Commands the plugins let through are still confirmed
+		allowed, confirm := plug.confirm(ctx), runner.Confirm
+		runner.Confirm = func(target, command string) error {
+			if err := allowed(target, command); err != nil || confirm == nil {
+				return err
+			}
+			return confirm(target, command)
+		}
+	}
+
+	// summarize reports the results of a run, or of each run with -watch
+	summarize := func(results executor.Results, err error, wallTime time.Duration) *report {
+		summary := newReport(results, wallTime)
+		summary.Command, summary.Profile, summary.Args = *command, profile, given
+		summary.Seed = seed
+		if stages != nil {
+			summary.breakDown(stages, results)
+		}
+		summary.print(os.Stdout)
+		if *reportPath != "" {
+			if err := summary.write(*reportPath); err != nil {
+				logger.Error("Cannot write report", "err", err)
+			}
+		}
+		if *junitPath != "" {
+			if err := writeJUnit(*junitPath, *command, results, summary.wallTime); err != nil {
+				logger.Error("Cannot write JUnit report", "err", err)
+			}
+		}
+		if *csvPath != "" {
+			if err := writeCSVReport(*csvPath, results); err != nil {
+				logger.Error("Cannot write CSV report", "err", err)
+			}
+		}
+		if notify != nil {
+			if err := notify.finish(summary, runStatus(ctx, err)); err != nil {
+				logger.Error("Cannot send notification", "err", err)
+			}
+		}
+		if plug != nil {
+			plug.finish(summary, runStatus(ctx, err))
+		}
+		return summary
+	}
+
+	// postFailed runs -post-cmd with the outcome of the run and reports
+	// whether it failed
+	postFailed := func(summary *report, status string) bool {
+		if *postCmd == "" {
+			return false
+		}
+		if err := runHook(context.Background(), parseShell(*shell), *postCmd, summaryEnv(summary, status)); err != nil {
+			logger.Error("Post command failed", "err", err)
+			return true
+		}
+		return false
+	}
+
+	// Compare worker counts instead of running the targets once
+	if benchmark {
+		// Only the wall time matters, not what the commands print
+		runner.OnEvent = nil
+		var resize func(int)
+		if jobs != nil {
+			resize = jobs.resize
+		}
+		runs, err := runBench(ctx, runner, targets, *command, benchCounts, resize)
+		printBench(os.Stdout, runs)
+		if jobs != nil {
+			jobs.close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitTaskFailure)
+		}
+		os.Exit(exitSuccess)
+	}
+
+	start := time.Now()
+	results, err := runner.Run(ctx, targets, *command)
+	if dashboard != nil {
+		dashboard.finish()
+	}
+	if ordered != nil {
+		ordered.flush(len(targets))
+	}
+	if display != nil {
+		display.close()
+	}
+
+	// Run the command again for the targets that change until interrupted,
+	// once they have been left alone for the debounce period
+	var summary *report
+	if *watch && results != nil {
+		watched, notifyErr := newWatcher(ctx, targets, *watchInterval)
+		if notifyErr != nil {
+			logger.Warn("Polling targets for changes", "interval", *watchInterval, "err", notifyErr)
+		}
+		for ctx.Err() == nil {
+			summary = summarize(results, err, time.Since(start))
+			logger.Info("Watching for changes (press Ctrl-C to stop)")
+			changed := watched.wait(ctx, *watchDebounce)
+			if changed == nil {
+				break
+			}
+
+			round := make([]string, len(changed))
+			for i, j := range changed {
+				round[i] = targets[j]
+			}
+			runner.Commands = commandsFor(round)
+			runner.Paths = pathsFor(round)
+			if partitionKey != nil {
+				runner.Partitions = partitionsOf(round, partitionKey)
+			}
+			if groupKey != nil {
+				runner.Groups = partitionsOf(round, groupKey)
+			}
+			if listed != nil && listed.columns != nil {
+				runner.Columns = make([][]string, len(changed))
+				for i, j := range changed {
+					runner.Columns[i] = listed.columns[j]
+				}
+			}
+			logger.Info("Targets changed", "targets", len(round))
+			if stats != nil {
+				stats.plan(len(round))
+			}
+			if dashboard != nil {
+				dashboard.plan(len(round))
+			}
+			if steering != nil {
+				steering.plan(len(round))
+			}
+
+			runner.OnEvent = printer(len(round), *retries+1, output)
+			if ordered != nil {
+				ordered = newOrderedEvents(runner.OnEvent)
+				runner.OnEvent = ordered.handle
+			}
+			runner.OnEvent = chainEvents(append([]func(executor.Event){runner.OnEvent}, observers...)...)
+
+			start = time.Now()
+			results, err = runner.Run(ctx, round, *command)
+			if dashboard != nil {
+				dashboard.finish()
+			}
+			if ordered != nil {
+				ordered.flush(len(round))
+			}
+			summary = nil
+			watched.reset()
+		}
+	}
+
+	if state != nil {
+		if err := state.close(); err != nil {
+			logger.Error("Cannot write state file", "err", err)
+		}
+	}
+	if logs != nil {
+		if err := logs.close(); err != nil {
+			logger.Error("Cannot write log manifest", "err", err)
+		}
+	}
+	if record != nil {
+		if err := record.close(); err != nil {
+			logger.Error("Cannot write journal", "err", err)
+		}
+	}
+	if stats != nil {
+		stats.close()
+	}
+	if dashboard != nil {
+		dashboard.close()
+	}
+	if steering != nil {
+		steering.close()
+	}
+	if jobs != nil {
+		jobs.close()
+	}
+	if pool != nil {
+		pool.close()
+	}
+	if results == nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		postFailed(newReport(nil, 0), "error")
+		os.Exit(exitSetupError)
+	}
 
 	// Print final summary
-	fmt.Printf("\nExecution Summary: Completed %d operations\n", executionCount)
+	watching := summary != nil
+	if !watching {
+		summary = summarize(results, err, time.Since(start))
+	}
+	hookFailed := postFailed(summary, runStatus(ctx, err))
+	differs := golden != nil && golden.close(targets) > 0
+
+	var interrupted executor.Interrupted
+	switch {
+	case errors.As(context.Cause(ctx), &interrupted) && watching:
+		logger.Info("Stopped watching")
+		os.Exit(exitInterrupted)
+	case errors.As(context.Cause(ctx), &interrupted):
+		fmt.Printf("Interrupted: %d commands were stopped while running and %d targets were not processed\n",
+			results.Cancelled(), results.Skipped())
+		printUnfinished(results)
+		os.Exit(exitInterrupted)
+	case errors.Is(context.Cause(ctx), errQuit):
+		fmt.Printf("Quit: %d targets were not processed\n", results.Skipped())
+		os.Exit(exitSuccess)
+	case err != nil:
+		fmt.Printf("Aborted after %d failed commands; %d targets were not processed\n",
+			results.Failed(), results.Skipped()+results.Cancelled())
+	}
+
+	if err != nil || results.Failed() > 0 || results.NoSpace() > 0 || hookFailed || differs {
+		os.Exit(exitTaskFailure)
+	}
+	os.Exit(exitSuccess)
 }
 
-func worker(id int, tasks <-chan string, wg *sync.WaitGroup, command string) {
-	defer wg.Done()
+// maxUnfinishedListed caps the list of unfinished targets after an interrupt.
+const maxUnfinishedListed = 20
 
-	for target := range tasks {
-		fmt.Printf("Worker %d: Processing %s\n", id, target)
-		
-		info, err := os.Stat(target)
-		if err != nil {
-			fmt.Printf("Error: Cannot stat %s: %v\n", target, err)
+// printUnfinished lists the targets an interrupted run did not finish.
+func printUnfinished(results executor.Results) {
+	listed := 0
+	for _, result := range results {
+		if !result.Skipped && !result.Cancelled {
 			continue
 		}
+		if listed == maxUnfinishedListed {
+			fmt.Printf("  ... and %d more\n", results.Skipped()+results.Cancelled()-listed)
+			return
+		}
+		status := "not started"
+		if result.Cancelled {
+			status = "stopped"
+		}
+		fmt.Printf("  %s (%s)\n", label(&result), status)
+		listed++
+	}
+}
 
-		// Replace placeholder with target path
-		cmdStr := strings.ReplaceAll(command, "{}", target)
-		
-		// Create command using sh
-		cmd := exec.Command("/bin/sh", "-c", cmdStr)
-		
-		// If target is a directory, set working directory
-		// If target is a file, set working directory to its parent
-		if info.IsDir() {
-			cmd.Dir = target
-		} else {
-			cmd.Dir = filepath.Dir(target)
+// parseShell splits the -shell flag into the interpreter and its arguments.
+// A bare interpreter name gets the flag it needs to run a command string.
+func parseShell(spec string) []string {
+	shell := strings.Fields(spec)
+	if len(shell) != 1 {
+		return shell
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(filepath.Base(shell[0])), ".exe")
+	switch name {
+	case "cmd":
+		return append(shell, "/C")
+	case "powershell", "pwsh":
+		return append(shell, "-NoProfile", "-Command")
+	default:
+		return append(shell, "-c")
+	}
+}
+
+// chainEvents returns an event handler that passes each event to all
+// handlers in order, skipping nil ones.
+func chainEvents(handlers ...func(executor.Event)) func(executor.Event) {
+	return func(ev executor.Event) {
+		for _, handle := range handlers {
+			if handle != nil {
+				handle(ev)
+			}
 		}
-		
-		// Get combined output
-		output, err := cmd.CombinedOutput()
-		
-		// Replace the mutex-based counter with atomic operation
-		current := atomic.AddInt32(&executionCount, 1)
-		
-		// Print simple progress counter
-		fmt.Printf("\rProgress: [%d/%d]", current, totalTasks)
-		
-		if len(output) > 0 {
-			fmt.Printf("\nOutput: %s\n", strings.TrimSpace(string(output)))
+	}
+}
+
+// printer returns an event handler that reports the progress of a run of
+// total targets, each attempted at most attempts times, on stdout. Each
+// finished task is printed as one block so concurrent workers never
+// interleave their output.
+func printer(total, attempts int, opts outputOptions) func(executor.Event) {
+	var (
+		mu        sync.Mutex
+		completed int
+	)
+
+	return func(ev executor.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch ev.Type {
+		case executor.TaskStarted:
+			logger.Debug("Processing", "worker", ev.Worker, "target", ev.Target)
+
+		case executor.TaskRetrying:
+			logger.Warn("Attempt failed, retrying", "worker", ev.Worker, "target", ev.Target,
+				"attempt", fmt.Sprintf("%d/%d", ev.Attempt, attempts), "err", ev.Err, "delay", ev.Delay)
+
+		case executor.TaskFinished:
+			completed++
+			logger.Log(context.Background(), levelTrace, "Finished", "worker", ev.Worker, "target", ev.Target,
+				"command", ev.Result.Command, "exit_code", ev.Result.ExitCode, "attempts", ev.Result.Attempts,
+				"duration", ev.Result.Duration, "err", ev.Result.Err)
+			if !opts.showResult(ev.Result) {
+				return
+			}
+			progress := fmt.Sprintf("[%d/%d]", completed, total)
+			os.Stdout.Write(formatResult(ev.Result, progress, opts.showOutput(ev.Result), opts))
+
+		case executor.TaskSkipped:
+			completed++
+			fmt.Printf("[%d/%d] %s %s\n", completed, total, ev.Target, paint(colorWarning, "skipped: "+ev.Result.SkipReason, opts.color))
+
+		case executor.TaskOutput:
+			if opts.showLine(ev) {
+				fmt.Print(formatLine(ev, opts.color))
+			}
 		}
-		if err != nil {
-			fmt.Printf("\nError: %v\n", err)
+	}
+}
+
+// outputOptions controls how the output of tasks is printed.
+type outputOptions struct {
+	quietSuccess bool           // leave out the output of successful tasks
+	onlyFailures bool           // leave out successful tasks entirely
+	grep         *regexp.Regexp // leave out tasks whose output doesn't match
+	stream       bool           // print output line by line as it arrives
+	color        bool           // color outcomes, and streamed lines by worker
+	stdoutOnly   bool           // leave out what commands write to stderr
+	stderrOnly   bool           // leave out what commands write to stdout
+}
+
+// showResult reports whether result is printed at all.
+func (o outputOptions) showResult(result *executor.Result) bool {
+	if o.onlyFailures && result.Err == nil {
+		return false
+	}
+	return o.grep == nil || o.grep.Match(o.output(result))
+}
+
+// output returns the output of result that is shown.
+func (o outputOptions) output(result *executor.Result) []byte {
+	switch {
+	case o.stdoutOnly:
+		return result.Stdout
+	case o.stderrOnly:
+		return result.Stderr
+	}
+	return result.Output
+}
+
+// showLine reports whether a line of streamed output is shown.
+func (o outputOptions) showLine(ev executor.Event) bool {
+	return !(o.stdoutOnly && ev.Stderr) && !(o.stderrOnly && !ev.Stderr)
+}
+
+// showOutput reports whether the output of result belongs in its block.
+func (o outputOptions) showOutput(result *executor.Result) bool {
+	return !o.stream && (result.Err != nil || !o.quietSuccess)
+}
+
+// formatResult renders a finished task as a block: a header naming the
+// target, the command output, and a footer with the exit status and
+// duration. Only the footer is rendered unless showOutput is set. Unless
+// opts select one stream, stdout comes first and stderr follows under a
+// label of its own. A non-empty progress is prepended to the footer. With
+// opts.color set, the header and footer are colored by the outcome.
+func formatResult(result *executor.Result, progress string, showOutput bool, opts outputOptions) []byte {
+	var b bytes.Buffer
+
+	// The targets of a batch share their output, so show it only once
+	showBody := showOutput
+	if len(result.Batch) > 1 && result.Target != result.Batch[0] {
+		showBody = false
+	}
+	code := colorSuccess
+	switch {
+	case result.Cancelled:
+		code = colorWarning
+	case result.Err != nil:
+		code = colorFailure
+	}
+	if showBody {
+		header := fmt.Sprintf("==> %s <==", label(result))
+		if len(result.Batch) > 1 {
+			header = fmt.Sprintf("==> %s and %d more <==", label(result), len(result.Batch)-1)
+		}
+		b.WriteString(paint(code, header, opts.color) + "\n")
+		switch {
+		case opts.stdoutOnly:
+			writeOutput(&b, result.Stdout, "stdout", result.StdoutDropped)
+		case opts.stderrOnly:
+			writeOutput(&b, result.Stderr, "stderr", result.StderrDropped)
+		default:
+			writeOutput(&b, result.Stdout, "stdout", result.StdoutDropped)
+			if len(result.Stderr) > 0 {
+				b.WriteString(paint(colorFailure, "--- stderr ---", opts.color) + "\n")
+				writeOutput(&b, result.Stderr, "stderr", result.StderrDropped)
+			}
 		}
-		fmt.Println(strings.Repeat("-", 40))
 	}
-}
\ No newline at end of file
+
+	status := "ok"
+	switch {
+	case result.Cancelled:
+		status = "stopped: " + result.Err.Error()
+	case result.Err != nil:
+		status = fmt.Sprintf("failed (%s): %s", result.Failure(), result.Err)
+	}
+	if progress != "" {
+		b.WriteString(progress + " ")
+	}
+	fmt.Fprintf(&b, "%s %s (%s)\n", label(result), paint(code, status, opts.color), result.Duration.Round(time.Millisecond))
+	if result.Scratch != "" && (len(result.Batch) <= 1 || result.Target == result.Batch[0]) {
+		fmt.Fprintf(&b, "Scratch directory kept at %s\n", result.Scratch)
+	}
+	if showBody {
+		b.WriteString(strings.Repeat("-", 40) + "\n")
+	}
+	return b.Bytes()
+}
+
+// writeOutput writes the output of a command to b, ending it with a newline,
+// and notes how many bytes of the stream were dropped.
+func writeOutput(b *bytes.Buffer, output []byte, stream string, dropped int64) {
+	b.Write(output)
+	if len(output) > 0 && !bytes.HasSuffix(output, []byte("\n")) {
+		b.WriteByte('\n')
+	}
+	if dropped > 0 {
+		fmt.Fprintf(b, "[%d bytes of %s dropped by -max-output-bytes]\n", dropped, stream)
+	}
+}
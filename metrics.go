@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// metrics follows the progress of the runs for Prometheus, which scrapes it
+// in the text exposition format from /metrics.
+type metrics struct {
+	mu        sync.Mutex
+	targets   int
+	total     int
+	failed    int
+	skipped   int
+	started   map[int]int // worker by index of the targets in flight
+	busy      map[int]int // targets in flight by worker
+	buckets   []int       // counts by histogramBounds, then the rest
+	sum       time.Duration
+	count     int
+	lastEnded time.Time
+	server    *http.Server
+}
+
+// serveMetrics starts serving the metrics on addr, such as ":9090".
+func serveMetrics(addr string) (*metrics, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &metrics{
+		started: make(map[int]int),
+		busy:    make(map[int]int),
+		buckets: make([]int, len(histogramBounds)+1),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serve)
+	m.server = &http.Server{Handler: mux}
+	go m.server.Serve(listener)
+	return m, nil
+}
+
+// plan sets the number of targets of the run about to start.
+func (m *metrics) plan(targets int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.targets = targets
+}
+
+// handle is the executor event handler that updates the metrics.
+func (m *metrics) handle(ev executor.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch ev.Type {
+	case executor.TaskStarted:
+		m.started[ev.Index] = ev.Worker
+		m.busy[ev.Worker]++
+		return
+	case executor.TaskFinished:
+		m.total++
+		if ev.Result.Failed() {
+			m.failed++
+		}
+		if !ev.Result.Cancelled {
+			m.observe(ev.Result.Duration)
+		}
+	case executor.TaskSkipped:
+		m.total++
+		m.skipped++
+	default:
+		return
+	}
+
+	m.lastEnded = time.Now()
+	if worker, ok := m.started[ev.Index]; ok {
+		delete(m.started, ev.Index)
+		if m.busy[worker]--; m.busy[worker] == 0 {
+			delete(m.busy, worker)
+		}
+	}
+}
+
+// observe adds the duration of a command to the histogram.
+func (m *metrics) observe(d time.Duration) {
+	i := 0
+	for i < len(histogramBounds) && d > histogramBounds[i] {
+		i++
+	}
+	m.buckets[i]++
+	m.sum += d
+	m.count++
+}
+
+// serve writes the metrics.
+func (m *metrics) serve(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	gauge := func(name, help string, value any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	counter := func(name, help string, value int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+
+	gauge("executor_targets", "Number of targets of the current run.", m.targets)
+	counter("executor_tasks_total", "Tasks that finished or were skipped.", m.total)
+	counter("executor_tasks_failed_total", "Tasks that failed.", m.failed)
+	counter("executor_tasks_skipped_total", "Tasks that were skipped.", m.skipped)
+	gauge("executor_tasks_inflight", "Tasks currently running.", len(m.started))
+	gauge("executor_workers_busy", "Workers currently running a task.", len(m.busy))
+
+	var last float64
+	if !m.lastEnded.IsZero() {
+		last = float64(m.lastEnded.UnixMilli()) / 1000
+	}
+	gauge("executor_last_task_end_timestamp_seconds", "Unix time at which the latest task ended, to alert on stalled runs.", last)
+
+	fmt.Fprintln(w, "# HELP executor_task_duration_seconds Duration of the commands that ran to completion.")
+	fmt.Fprintln(w, "# TYPE executor_task_duration_seconds histogram")
+	cumulative := 0
+	for i, bound := range histogramBounds {
+		cumulative += m.buckets[i]
+		fmt.Fprintf(w, "executor_task_duration_seconds_bucket{le=\"%g\"} %d\n", bound.Seconds(), cumulative)
+	}
+	fmt.Fprintf(w, "executor_task_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "executor_task_duration_seconds_sum %g\n", m.sum.Seconds())
+	fmt.Fprintf(w, "executor_task_duration_seconds_count %d\n", m.count)
+}
+
+// close stops serving the metrics.
+func (m *metrics) close() error {
+	return m.server.Close()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// notifyTimeout bounds each webhook request.
+const notifyTimeout = 10 * time.Second
+
+// notifier posts JSON messages about a run to a webhook. Every message has a
+// "text" and a "content" field with a readable summary, so it can be sent
+// straight to Slack or Discord incoming webhooks as well as to generic ones.
+type notifier struct {
+	url      string
+	failures bool
+	client   *http.Client
+
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs []error
+}
+
+// notifyMessage is the payload posted to the webhook.
+type notifyMessage struct {
+	Text    string `json:"text"`
+	Content string `json:"content"`
+	Event   string `json:"event"`
+
+	// Status and Summary are set when the run finished
+	Status  string  `json:"status,omitempty"`
+	Summary *report `json:"summary,omitempty"`
+
+	// Failure is set for failed targets
+	Failure *reportEntry `json:"failure,omitempty"`
+}
+
+// newNotifier returns a notifier for the webhook at rawURL that also reports
+// each failed target if failures is set.
+func newNotifier(rawURL string, failures bool) (*notifier, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL %q: expected http or https", rawURL)
+	}
+	return &notifier{
+		url:      rawURL,
+		failures: failures,
+		client:   &http.Client{Timeout: notifyTimeout},
+	}, nil
+}
+
+// handle posts a message for each failed target if requested. The requests
+// are sent in the background so they don't hold up the workers.
+func (n *notifier) handle(ev executor.Event) {
+	if !n.failures || ev.Type != executor.TaskFinished || !ev.Result.Failed() {
+		return
+	}
+
+	failure := &reportEntry{
+		Target:     ev.Result.Target,
+		ExitCode:   ev.Result.ExitCode,
+		Error:      ev.Result.Err.Error(),
+		TimedOut:   ev.Result.TimedOut,
+		DurationMS: ev.Result.Duration.Milliseconds(),
+	}
+	text := fmt.Sprintf("executor: %s failed: %s", failure.Target, failure.Error)
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.post(notifyMessage{Text: text, Content: text, Event: "failure", Failure: failure}); err != nil {
+			n.mu.Lock()
+			n.errs = append(n.errs, err)
+			n.mu.Unlock()
+		}
+	}()
+}
+
+// finish waits for pending failure messages and posts the summary of the run,
+// returning any error encountered along the way.
+func (n *notifier) finish(rep *report, status string) error {
+	n.wg.Wait()
+
+	text := fmt.Sprintf("executor run %s: %d succeeded, %d failed, %d skipped of %d targets in %s",
+		status, rep.Succeeded, rep.Failed, rep.Skipped+rep.Stopped, rep.Total, rep.wallTime.Round(time.Millisecond))
+	err := n.post(notifyMessage{Text: text, Content: text, Event: "finished", Status: status, Summary: rep})
+	return errors.Join(append(n.errs, err)...)
+}
+
+func (n *notifier) post(msg notifyMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", n.url, resp.Status)
+	}
+	return nil
+}
+
+// runStatus describes how a run ended: "completed", "aborted" when the
+// failure limit stopped it, "interrupted" by a signal or "quit" at the
+// confirmation prompt.
+func runStatus(ctx context.Context, err error) string {
+	var interrupted executor.Interrupted
+	switch {
+	case errors.As(context.Cause(ctx), &interrupted):
+		return "interrupted"
+	case errors.Is(context.Cause(ctx), errQuit):
+		return "quit"
+	case err != nil:
+		return "aborted"
+	}
+	return "completed"
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// orderedEvents holds back TaskFinished and TaskSkipped events until those
+// of all earlier targets have been passed on, so results are reported in
+// target order even though they finish in any order. Other events are passed
+// on immediately.
+type orderedEvents struct {
+	mu      sync.Mutex
+	next    func(executor.Event)
+	pending map[int]executor.Event
+	index   int
+}
+
+func newOrderedEvents(next func(executor.Event)) *orderedEvents {
+	return &orderedEvents{next: next, pending: make(map[int]executor.Event)}
+}
+
+// handle is the executor event handler.
+func (o *orderedEvents) handle(ev executor.Event) {
+	if ev.Type != executor.TaskFinished && ev.Type != executor.TaskSkipped {
+		o.next(ev)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.pending[ev.Index] = ev
+	for {
+		ready, ok := o.pending[o.index]
+		if !ok {
+			return
+		}
+		delete(o.pending, o.index)
+		o.index++
+		o.next(ready)
+	}
+}
+
+// flush passes on the events still held back once the run is over. They
+// wait for targets that were never processed because the run was aborted.
+func (o *orderedEvents) flush(total int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for ; o.index < total; o.index++ {
+		if ev, ok := o.pending[o.index]; ok {
+			delete(o.pending, o.index)
+			o.next(ev)
+		}
+	}
+}
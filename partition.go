@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// parsePartition returns the function computing the -partition-by key of a
+// target from spec: "dir" for the directory its command runs in, "repo" for
+// the git repository it is in, or a template such as "{dir}" expanded as
+// described by executor.Expand.
+func parsePartition(spec string) (func(target string) string, error) {
+	switch {
+	case spec == "dir":
+		return targetDir, nil
+	case spec == "repo":
+		return enclosingRepo, nil
+	case strings.Contains(spec, "{"):
+		return func(target string) string { return executor.Expand(spec, target) }, nil
+	}
+	return nil, fmt.Errorf("expected dir, repo or a template such as {dir}, got %q", spec)
+}
+
+// targetDir returns target if it is a directory, or else its parent, as an
+// absolute path.
+func targetDir(target string) string {
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		return absPath(target)
+	}
+	return absPath(filepath.Dir(target))
+}
+
+// enclosingRepo returns the root of the git repository target is in, or the
+// directory of target if it is in none.
+func enclosingRepo(target string) string {
+	dir := targetDir(target)
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		if filepath.Dir(d) == d {
+			return dir
+		}
+	}
+}
+
+// partitionsOf returns the key of each target.
+func partitionsOf(targets []string, key func(target string) string) []string {
+	partitions := make([]string, len(targets))
+	for i, target := range targets {
+		partitions[i] = key(target)
+	}
+	return partitions
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// pathMap rewrites a target into the value substituted for {}.
+type pathMap func(target string) (string, error)
+
+// parsePathMap parses a -map expression:
+//
+//	strip-prefix:P  remove the prefix P
+//	strip-suffix:S  remove the suffix S
+//	prefix:P        prepend P
+//	suffix:S        append S
+//	rel[:BASE]      make the path relative to BASE, the current directory
+//	                by default
+//	abs             make the path absolute
+//	slash           use forward slashes
+//	s/RE/REPL/      replace the matches of the regular expression RE with
+//	                REPL, which can refer to groups as $1 or ${name}; any
+//	                character can stand in for the slashes, as in s|a/b|c|
+func parsePathMap(expr string) (pathMap, error) {
+	op, arg, hasArg := strings.Cut(expr, ":")
+	switch {
+	case op == "strip-prefix" && hasArg:
+		return func(target string) (string, error) { return strings.TrimPrefix(target, arg), nil }, nil
+	case op == "strip-suffix" && hasArg:
+		return func(target string) (string, error) { return strings.TrimSuffix(target, arg), nil }, nil
+	case op == "prefix" && hasArg:
+		return func(target string) (string, error) { return arg + target, nil }, nil
+	case op == "suffix" && hasArg:
+		return func(target string) (string, error) { return target + arg, nil }, nil
+	case op == "rel":
+		base := arg
+		if !hasArg {
+			base = "."
+		}
+		return func(target string) (string, error) {
+			abs, err := filepath.Abs(target)
+			if err != nil {
+				return "", err
+			}
+			absBase, err := filepath.Abs(base)
+			if err != nil {
+				return "", err
+			}
+			return filepath.Rel(absBase, abs)
+		}, nil
+	case expr == "abs":
+		return filepath.Abs, nil
+	case expr == "slash":
+		return func(target string) (string, error) { return filepath.ToSlash(target), nil }, nil
+	case len(expr) >= 4 && expr[0] == 's':
+		return parseSubstitution(expr)
+	}
+	return nil, fmt.Errorf("unknown expression %q", expr)
+}
+
+// parseSubstitution parses a sed-like s/RE/REPL/ expression.
+func parseSubstitution(expr string) (pathMap, error) {
+	delim := expr[1:2]
+	parts := strings.Split(expr[2:], delim)
+	if len(parts) != 3 || parts[2] != "" {
+		return nil, fmt.Errorf("%q is not of the form s%sRE%sREPL%s", expr, delim, delim, delim)
+	}
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	repl := parts[1]
+	return func(target string) (string, error) { return re.ReplaceAllString(target, repl), nil }, nil
+}
+
+// mapPaths passes each target through the maps in turn, and returns the
+// values that differ from their target.
+func mapPaths(targets []string, maps []pathMap) (map[string]string, error) {
+	paths := make(map[string]string)
+	for _, target := range targets {
+		path := target
+		for _, m := range maps {
+			var err error
+			if path, err = m(path); err != nil {
+				return nil, fmt.Errorf("%s: %v", target, err)
+			}
+		}
+		if path != target {
+			paths[target] = path
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// The lifecycle events plugins are called for.
+const (
+	pluginTargetDiscovered = "target-discovered"
+	pluginTaskStart        = "task-start"
+	pluginTaskEnd          = "task-end"
+	pluginRunEnd           = "run-end"
+)
+
+// pluginTimeout bounds each call of a plugin.
+const pluginTimeout = time.Minute
+
+// pluginMessage is the JSON a plugin receives on stdin.
+type pluginMessage struct {
+	Event   string `json:"event"`
+	Target  string `json:"target,omitempty"`
+	Command string `json:"command,omitempty"`
+
+	// Set for task-end, and Status and Summary for run-end
+	Status     string  `json:"status,omitempty"`
+	Reason     string  `json:"reason,omitempty"`
+	ExitCode   *int    `json:"exit_code,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	TimedOut   bool    `json:"timed_out,omitempty"`
+	DurationMS int64   `json:"duration_ms,omitempty"`
+	Summary    *report `json:"summary,omitempty"`
+}
+
+// pluginReply is the JSON a plugin may print on stdout for target-discovered
+// and task-start. Printing nothing leaves the task as it is. Only replies to
+// target-discovered may rewrite the command.
+type pluginReply struct {
+	Skip    bool   `json:"skip"`
+	Reason  string `json:"reason"`
+	Command string `json:"command"`
+}
+
+// plugins are the executables of a plugin directory. Each is run for every
+// event with the name of the event as its argument and a pluginMessage on
+// stdin, in the order of their names:
+//
+//	target-discovered  once per target before the run; the reply can skip
+//	                   the target or rewrite its command template
+//	task-start         before each command, with the command as it will
+//	                   run; the reply can skip the target, but a command in
+//	                   it is an error, as it is too late to rewrite it
+//	task-end           after each target, with its outcome
+//	run-end            after the run, with its summary
+//
+// Replies to task-end and run-end are ignored. The discovery costs a process
+// per plugin and target before the run starts, of which as many run at once
+// as there are CPUs. Task-end calls are made in the background, in the order
+// of the events, so that they don't hold up the workers.
+type plugins struct {
+	paths []string
+
+	// ends queues the task-end messages for the goroutine calling the
+	// plugins with them, which runs while there are any; wg waits for it.
+	mu      sync.Mutex
+	ends    []pluginMessage
+	sending bool
+	wg      sync.WaitGroup
+}
+
+// defaultPluginDir returns ~/.executor/plugins, or "" without a home
+// directory.
+func defaultPluginDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".executor", "plugins")
+}
+
+// loadPlugins finds the plugins in dir. A missing directory has none.
+func loadPlugins(dir string) (*plugins, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return &plugins{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &plugins{}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		// Windows has no executable bit
+		if runtime.GOOS != "windows" && info.Mode()&0o111 == 0 {
+			continue
+		}
+		p.paths = append(p.paths, path)
+	}
+	sort.Strings(p.paths)
+	return p, nil
+}
+
+// names returns the file names of the plugins.
+func (p *plugins) names() []string {
+	names := make([]string, len(p.paths))
+	for i, path := range p.paths {
+		names[i] = filepath.Base(path)
+	}
+	return names
+}
+
+// call runs every plugin for msg. A plugin asking to skip ends the call; a
+// rewritten command is passed on to the plugins after it.
+func (p *plugins) call(ctx context.Context, msg pluginMessage) (pluginReply, error) {
+	var reply pluginReply
+	for _, path := range p.paths {
+		answer, err := runPlugin(ctx, path, msg)
+		if err != nil {
+			return reply, fmt.Errorf("plugin %s: %v", filepath.Base(path), err)
+		}
+		if answer.Skip {
+			if answer.Reason == "" {
+				answer.Reason = "skipped by plugin " + filepath.Base(path)
+			}
+			return answer, nil
+		}
+		if answer.Command != "" && msg.Event == pluginTaskStart {
+			return reply, fmt.Errorf("plugin %s: cannot rewrite the command at task-start, only at target-discovered", filepath.Base(path))
+		}
+		if answer.Command != "" {
+			reply.Command = answer.Command
+			msg.Command = answer.Command
+		}
+	}
+	return reply, nil
+}
+
+// runPlugin runs the plugin at path for msg and decodes its reply.
+func runPlugin(ctx context.Context, path string, msg pluginMessage) (pluginReply, error) {
+	var reply pluginReply
+	input, err := json.Marshal(msg)
+	if err != nil {
+		return reply, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, msg.Event)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(input), &out, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return reply, err
+	}
+
+	if len(bytes.TrimSpace(out.Bytes())) == 0 {
+		return reply, nil
+	}
+	if err := json.Unmarshal(out.Bytes(), &reply); err != nil {
+		return reply, fmt.Errorf("invalid reply: %v", err)
+	}
+	return reply, nil
+}
+
+// discover calls the plugins for each target before the run, for as many
+// targets at once as there are CPUs. It returns the targets to keep and the
+// rewritten command templates by target.
+func (p *plugins) discover(targets []string, commandOf func(target string) string) (kept []int, commands map[string]string, err error) {
+	replies := make([]pluginReply, len(targets))
+	errs := make([]error, len(targets))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(runtime.NumCPU(), len(targets)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				msg := pluginMessage{Event: pluginTargetDiscovered, Target: targets[i], Command: commandOf(targets[i])}
+				replies[i], errs[i] = p.call(context.Background(), msg)
+			}
+		}()
+	}
+	for i := range targets {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	commands = make(map[string]string)
+	for i, target := range targets {
+		if errs[i] != nil {
+			return nil, nil, errs[i]
+		}
+		reply := replies[i]
+		if reply.Skip {
+			logger.Info("Skipping target", "target", target, "reason", reply.Reason)
+			continue
+		}
+		if reply.Command != "" {
+			commands[target] = reply.Command
+		}
+		kept = append(kept, i)
+	}
+	return kept, commands, nil
+}
+
+// confirm is the executor.Executor.Confirm hook calling the plugins before
+// each command. The reason a plugin gives for skipping the target becomes
+// that of its result. A plugin that fails skips the target too, so that a
+// broken policy doesn't let everything through.
+func (p *plugins) confirm(ctx context.Context) func(target, command string) error {
+	return func(target, command string) error {
+		reply, err := p.call(ctx, pluginMessage{Event: pluginTaskStart, Target: target, Command: command})
+		if err != nil {
+			if ctx.Err() == nil {
+				warnf(os.Stderr, "skipping %s: %v", target, err)
+			}
+			return err
+		}
+		if reply.Skip {
+			logger.Info("Skipping target", "target", target, "reason", reply.Reason)
+			return errors.New(reply.Reason)
+		}
+		return nil
+	}
+}
+
+// handle is the executor event handler queueing the task-end calls of the
+// plugins.
+func (p *plugins) handle(ev executor.Event) {
+	msg := pluginMessage{Event: pluginTaskEnd, Target: ev.Target}
+	switch ev.Type {
+	case executor.TaskFinished:
+		result := ev.Result
+		msg.Status = "succeeded"
+		switch {
+		case result.Cancelled:
+			msg.Status = "stopped"
+		case result.Err != nil:
+			msg.Status = "failed"
+			msg.Error = result.Err.Error()
+		}
+		msg.ExitCode = &result.ExitCode
+		msg.TimedOut = result.TimedOut
+		msg.DurationMS = result.Duration.Milliseconds()
+	case executor.TaskSkipped:
+		msg.Status = "skipped"
+		msg.Reason = ev.Result.SkipReason
+	default:
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ends = append(p.ends, msg)
+	if !p.sending {
+		p.sending = true
+		p.wg.Add(1)
+		go p.sendEnds()
+	}
+}
+
+// sendEnds calls the plugins with the queued task-end messages in order until
+// none is left.
+func (p *plugins) sendEnds() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		if len(p.ends) == 0 {
+			p.sending = false
+			p.mu.Unlock()
+			return
+		}
+		msg := p.ends[0]
+		p.ends = p.ends[1:]
+		p.mu.Unlock()
+
+		if _, err := p.call(context.Background(), msg); err != nil {
+			warnf(os.Stderr, "%v", err)
+		}
+	}
+}
+
+// finish waits for the task-end calls, then calls the plugins for run-end
+// with the summary of the run and its status as of runStatus.
+func (p *plugins) finish(summary *report, status string) {
+	p.wg.Wait()
+	if _, err := p.call(context.Background(), pluginMessage{Event: pluginRunEnd, Status: status, Summary: summary}); err != nil {
+		warnf(os.Stderr, "%v", err)
+	}
+}
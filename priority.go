@@ -0,0 +1,53 @@
+package main
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// Orders in which -order schedules the targets.
+const (
+	orderNone   = ""       // as matched or listed
+	orderSize   = "size"   // largest first
+	orderMTime  = "mtime"  // most recently modified first
+	orderName   = "name"   // alphabetical
+	orderRandom = "random" // shuffled
+)
+
+// orders are the accepted -order values.
+var orders = []string{orderSize, orderMTime, orderName, orderRandom}
+
+// orderTargets returns the positions of targets in the order they should be
+// scheduled, shuffled from seed for orderRandom. The size and modification
+// time of a directory cover all the files below it.
+func orderTargets(targets []string, order string, seed uint64) []int {
+	index := make([]int, len(targets))
+	for i := range index {
+		index[i] = i
+	}
+
+	switch order {
+	case orderSize, orderMTime:
+		prints := make([]fingerprint, len(targets))
+		for i, target := range targets {
+			prints[i] = fingerprintOf(target)
+		}
+		slices.SortStableFunc(index, func(a, b int) int {
+			if order == orderSize {
+				return cmp.Compare(prints[b].size, prints[a].size)
+			}
+			return prints[b].modified.Compare(prints[a].modified)
+		})
+	case orderName:
+		slices.SortStableFunc(index, func(a, b int) int {
+			return strings.Compare(targets[a], targets[b])
+		})
+	case orderRandom:
+		rand.New(rand.NewPCG(seed, seed)).Shuffle(len(index), func(i, j int) {
+			index[i], index[j] = index[j], index[i]
+		})
+	}
+	return index
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// report summarises a run: aggregate counts and the targets that failed.
+type report struct {
+	Total        int           `json:"total"`
+	Succeeded    int           `json:"succeeded"`
+	Failed       int           `json:"failed"`
+	TimedOut     int           `json:"timed_out"`
+	VerifyFailed int           `json:"verify_failed"`
+	Skipped      int           `json:"skipped"`
+	TestFailed   int           `json:"test_failed"`
+	NoSpace      int           `json:"no_space"`
+	Stopped      int           `json:"stopped"`
+	WallTimeMS   int64         `json:"wall_time_ms"`
+	Failures     []reportEntry `json:"failures"`
+	Kinds        []failureKind `json:"failure_kinds"`
+	Hosts        []hostSummary `json:"hosts,omitempty"`
+
+	// Stages breaks the results down by stage of a -stage pipeline
+	Stages []stageSummary `json:"stages,omitempty"`
+
+	// Durations is nil if no command ran to completion
+	Durations *durationStats `json:"durations"`
+
+	// Command, Profile and Args record how the run was started, so
+	// "executor rerun-failed" can repeat it for the failed targets
+	Command string   `json:"command"`
+	Profile string   `json:"profile,omitempty"`
+	Args    []string `json:"args,omitempty"`
+
+	// Seed is the -seed the random choices of the run were drawn from, or
+	// zero if it made none
+	Seed uint64 `json:"seed,omitempty"`
+
+	wallTime time.Duration
+}
+
+// failureKind counts the failures of one kind, with a few of the targets.
+type failureKind struct {
+	Kind     string   `json:"kind"`
+	Count    int      `json:"count"`
+	Examples []string `json:"examples"`
+}
+
+// maxKindExamples caps the example targets of each kind of failure.
+const maxKindExamples = 3
+
+// hostSummary counts the outcomes on one remote host.
+type hostSummary struct {
+	Host      string `json:"host"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+}
+
+// reportEntry describes a failed target.
+type reportEntry struct {
+	Target       string          `json:"target"`
+	Host         string          `json:"host,omitempty"`
+	ExitCode     int             `json:"exit_code"`
+	Error        string          `json:"error"`
+	Kind         string          `json:"kind"`
+	Stage        string          `json:"stage,omitempty"`
+	Metadata     *targetMetadata `json:"metadata,omitempty"`
+	TimedOut     bool            `json:"timed_out"`
+	VerifyFailed bool            `json:"verify_failed"`
+	DurationMS   int64           `json:"duration_ms"`
+	Stdout       string          `json:"stdout,omitempty"`
+	Stderr       string          `json:"stderr,omitempty"`
+
+	// The number of bytes of each stream dropped by -max-output-bytes
+	StdoutDropped int64 `json:"stdout_dropped,omitempty"`
+	StderrDropped int64 `json:"stderr_dropped,omitempty"`
+
+	duration time.Duration
+}
+
+// targetMetadata is the metadata of a target recorded with -metadata.
+type targetMetadata struct {
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+	Owner     string    `json:"owner,omitempty"`
+	GitBranch string    `json:"git_branch,omitempty"`
+	GitDirty  bool      `json:"git_dirty,omitempty"`
+}
+
+// newTargetMetadata converts the metadata of a result, returning nil if it
+// has none.
+func newTargetMetadata(m *executor.Metadata) *targetMetadata {
+	if m == nil {
+		return nil
+	}
+	return &targetMetadata{Size: m.Size, ModTime: m.ModTime, Owner: m.Owner, GitBranch: m.GitBranch, GitDirty: m.GitDirty}
+}
+
+func newReport(results executor.Results, wallTime time.Duration) *report {
+	rep := &report{
+		Total:        len(results),
+		Succeeded:    results.Succeeded(),
+		Failed:       results.Failed(),
+		TimedOut:     results.TimedOut(),
+		VerifyFailed: results.VerifyFailed(),
+		Skipped:      results.Skipped(),
+		TestFailed:   results.TestFailed(),
+		NoSpace:      results.NoSpace(),
+		Stopped:      results.Cancelled(),
+		WallTimeMS:   wallTime.Milliseconds(),
+		Failures:     []reportEntry{},
+		Kinds:        []failureKind{},
+		Durations:    newDurationStats(results),
+		wallTime:     wallTime,
+	}
+	hosts := make(map[string]int)
+	for _, result := range results {
+		if result.Host != "" && !result.Skipped && !result.Cancelled {
+			i, ok := hosts[result.Host]
+			if !ok {
+				i = len(rep.Hosts)
+				hosts[result.Host] = i
+				rep.Hosts = append(rep.Hosts, hostSummary{Host: result.Host})
+			}
+			host := &rep.Hosts[i]
+			if result.Err != nil {
+				host.Failed++
+			} else {
+				host.Succeeded++
+			}
+		}
+
+		if !result.Failed() {
+			continue
+		}
+		kind := string(result.Failure())
+		i := slices.IndexFunc(rep.Kinds, func(k failureKind) bool { return k.Kind == kind })
+		if i < 0 {
+			i = len(rep.Kinds)
+			rep.Kinds = append(rep.Kinds, failureKind{Kind: kind})
+		}
+		if rep.Kinds[i].Count++; len(rep.Kinds[i].Examples) < maxKindExamples {
+			rep.Kinds[i].Examples = append(rep.Kinds[i].Examples, result.Target)
+		}
+		rep.Failures = append(rep.Failures, reportEntry{
+			Target:       result.Target,
+			Host:         result.Host,
+			ExitCode:     result.ExitCode,
+			Error:        result.Err.Error(),
+			Kind:         kind,
+			Stage:        failedStage(result),
+			Metadata:     newTargetMetadata(result.Metadata),
+			TimedOut:     result.TimedOut,
+			VerifyFailed: result.VerifyFailed,
+			DurationMS:   result.Duration.Milliseconds(),
+			Stdout:       string(result.Stdout),
+			Stderr:       string(result.Stderr),
+
+			StdoutDropped: result.StdoutDropped,
+			StderrDropped: result.StderrDropped,
+			duration:      result.Duration,
+		})
+	}
+	return rep
+}
+
+// print writes the report as a table of failed targets followed by the
+// totals.
+func (rep *report) print(w io.Writer) {
+	fmt.Fprintln(w, "\nExecution Summary")
+	if len(rep.Failures) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  TARGET\tKIND\tEXIT\tDURATION\tERROR")
+		for _, entry := range rep.Failures {
+			exit := "-"
+			if entry.ExitCode >= 0 {
+				exit = fmt.Sprint(entry.ExitCode)
+			}
+			target := entry.Target
+			if entry.Host != "" {
+				target = entry.Host + ":" + target
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", target, entry.Kind, exit, entry.duration.Round(time.Millisecond), entry.Error)
+		}
+		tw.Flush()
+	}
+	if len(rep.Kinds) > 0 {
+		sorted := slices.Clone(rep.Kinds)
+		slices.SortStableFunc(sorted, func(a, b failureKind) int { return b.Count - a.Count })
+		fmt.Fprintln(w, "Failures by kind:")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, kind := range sorted {
+			examples := strings.Join(kind.Examples, ", ")
+			if kind.Count > len(kind.Examples) {
+				examples += ", ..."
+			}
+			fmt.Fprintf(tw, "  %s\t%d\t%s\n", kind.Kind, kind.Count, examples)
+		}
+		tw.Flush()
+	}
+	if len(rep.Hosts) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  HOST\tSUCCEEDED\tFAILED")
+		for _, host := range rep.Hosts {
+			fmt.Fprintf(tw, "  %s\t%d\t%d\n", host.Host, host.Succeeded, host.Failed)
+		}
+		tw.Flush()
+	}
+	rep.printStages(w)
+	if rep.Durations != nil {
+		rep.Durations.print(w)
+	}
+	noSpace := ""
+	if rep.NoSpace > 0 {
+		noSpace = fmt.Sprintf(", %d without free space", rep.NoSpace)
+	}
+	fmt.Fprintf(w, "Succeeded: %d, failed: %d (%d timed out, %d failed verification), skipped: %d (%d failed the test%s), stopped: %d, total: %d in %s\n",
+		rep.Succeeded, rep.Failed, rep.TimedOut, rep.VerifyFailed, rep.Skipped, rep.TestFailed, noSpace, rep.Stopped, rep.Total, rep.wallTime.Round(time.Millisecond))
+	if rep.Seed != 0 {
+		fmt.Fprintf(w, "Scheduled at random with -seed %d\n", rep.Seed)
+	}
+}
+
+// write saves the report as JSON to path.
+func (rep *report) write(path string) error {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// readReport loads a report written by write, so it can be printed again.
+func readReport(path string) (*report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rep := &report{}
+	if err := json.Unmarshal(data, rep); err != nil {
+		return nil, err
+	}
+
+	rep.wallTime = time.Duration(rep.WallTimeMS) * time.Millisecond
+	for i := range rep.Failures {
+		rep.Failures[i].duration = time.Duration(rep.Failures[i].DurationMS) * time.Millisecond
+	}
+	if s := rep.Durations; s != nil {
+		s.min = time.Duration(s.MinMS) * time.Millisecond
+		s.max = time.Duration(s.MaxMS) * time.Millisecond
+		s.mean = time.Duration(s.MeanMS) * time.Millisecond
+		s.p95 = time.Duration(s.P95MS) * time.Millisecond
+		for i := range s.Histogram {
+			s.Histogram[i].upTo = time.Duration(s.Histogram[i].UpToMS) * time.Millisecond
+		}
+	}
+	return rep, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// findGitRepos walks root and returns every directory containing a .git
+// directory or file (as in worktrees and submodules), root included.
+// Repositories are not searched for further repositories inside them, and
+// directories for which skip returns true are not descended into. With
+// follow set, symbolic links to directories are searched as well.
+func findGitRepos(root string, skip skipFunc, follow bool) ([]string, error) {
+	var repos []string
+	err := walkDir(root, follow, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are left out rather than failing the walk
+			if d != nil && d.IsDir() && path != root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && skip(path, true) {
+			return fs.SkipDir
+		}
+
+		if _, err := os.Lstat(filepath.Join(path, ".git")); err == nil {
+			repos = append(repos, path)
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return repos, err
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// parseShard parses a -shard value "i/n", selecting the i-th of n shards
+// counting from 1.
+func parseShard(value string) (i, n int, err error) {
+	first, second, ok := strings.Cut(value, "/")
+	if ok {
+		i, err = strconv.Atoi(first)
+	}
+	if ok && err == nil {
+		n, err = strconv.Atoi(second)
+	}
+	if !ok || err != nil || n < 1 || i < 1 || i > n {
+		return 0, 0, fmt.Errorf("%q is not of the form i/n with 1 <= i <= n", value)
+	}
+	return i, n, nil
+}
+
+// shardTargets returns the positions of the targets in the i-th of n shards.
+// A target's shard depends on its path alone, so every machine given the
+// same targets agrees on the split however they are ordered.
+func shardTargets(targets []string, i, n int) []int {
+	var index []int
+	for j, target := range targets {
+		h := fnv.New32a()
+		h.Write([]byte(target))
+		if int(h.Sum32()%uint32(n)) == i-1 {
+			index = append(index, j)
+		}
+	}
+	return index
+}
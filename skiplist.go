@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// skipEntry is one target or pattern on the skip list.
+type skipEntry struct {
+	target string
+	reason string
+}
+
+// skipList holds the targets that runs leave out until they are removed from
+// it again, kept in a file of one entry per line, each after the comment
+// lines giving its reason:
+//
+//	# checkout is broken
+//	/home/me/src/old-project
+//	# permission denied
+//	**/lost+found
+//
+// Entries without glob characters are absolute paths and skip the target and
+// everything below it; the others are patterns matched like -exclude ones.
+type skipList struct {
+	path    string
+	entries []skipEntry
+	skipped []skipEntry // the paths left out by a run, with the reason
+}
+
+// defaultSkipList returns the path of the skip list: $EXECUTOR_SKIP_LIST or
+// ~/.executor/skip-list.
+func defaultSkipList() string {
+	if path := os.Getenv("EXECUTOR_SKIP_LIST"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".executor", "skip-list")
+}
+
+// loadSkipList reads the skip list at path. A missing file lists nothing.
+func loadSkipList(path string) (*skipList, error) {
+	list := &skipList{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reason []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			reason = nil
+		case strings.HasPrefix(line, "#"):
+			reason = append(reason, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+		default:
+			list.entries = append(list.entries, skipEntry{target: line, reason: strings.Join(reason, " ")})
+			reason = nil
+		}
+	}
+	return list, scanner.Err()
+}
+
+// save writes the skip list back to its file.
+func (l *skipList) save() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, entry := range l.entries {
+		if entry.reason != "" {
+			fmt.Fprintf(&b, "# %s\n", entry.reason)
+		}
+		fmt.Fprintln(&b, entry.target)
+	}
+	return os.WriteFile(l.path, []byte(b.String()), 0o644)
+}
+
+// skipTarget returns how target is written on the skip list: patterns as
+// they are, paths made absolute.
+func skipTarget(target string) string {
+	if strings.ContainsAny(target, "*?[") {
+		return filepath.ToSlash(target)
+	}
+	return absPath(target)
+}
+
+// add puts targets on the list with reason, unless they already are.
+func (l *skipList) add(targets []string, reason string) {
+	for _, target := range targets {
+		target = skipTarget(target)
+		if !slices.ContainsFunc(l.entries, func(e skipEntry) bool { return e.target == target }) {
+			l.entries = append(l.entries, skipEntry{target: target, reason: reason})
+		}
+	}
+}
+
+// remove takes targets off the list, failing for those not on it.
+func (l *skipList) remove(targets []string) error {
+	for _, target := range targets {
+		target = skipTarget(target)
+		i := slices.IndexFunc(l.entries, func(e skipEntry) bool { return e.target == target })
+		if i < 0 {
+			return fmt.Errorf("%s is not on the skip list", target)
+		}
+		l.entries = slices.Delete(l.entries, i, i+1)
+	}
+	return nil
+}
+
+// print writes the entries of the list, with their reasons.
+func (l *skipList) print(w io.Writer) {
+	for _, entry := range l.entries {
+		if entry.reason != "" {
+			fmt.Fprintf(w, "%s\t# %s\n", entry.target, entry.reason)
+		} else {
+			fmt.Fprintln(w, entry.target)
+		}
+	}
+}
+
+// match returns the entry that skips path, if any.
+func (l *skipList) match(path string) (skipEntry, bool) {
+	abs := absPath(path)
+	for _, entry := range l.entries {
+		if strings.ContainsAny(entry.target, "*?[") {
+			if excluded(abs, []string{entry.target}) {
+				return entry, true
+			}
+		} else if within(abs, entry.target) {
+			return entry, true
+		}
+	}
+	return skipEntry{}, false
+}
+
+// skips reports whether path is on the list, recording it if so.
+func (l *skipList) skips(path string) bool {
+	entry, ok := l.match(path)
+	if ok {
+		l.skipped = append(l.skipped, skipEntry{target: path, reason: entry.reason})
+	}
+	return ok
+}
+
+// runSkip runs "executor skip add|remove|list" on the skip list at path.
+func runSkip(w io.Writer, path string, args []string) error {
+	if path == "" {
+		return errors.New("cannot find the home directory; set EXECUTOR_SKIP_LIST")
+	}
+	if len(args) == 0 {
+		return errors.New("expected add, remove or list")
+	}
+	list, err := loadSkipList(path)
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("skip "+args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	reason := fs.String("reason", "", "")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "add":
+		if fs.NArg() == 0 {
+			return errors.New("expected a target or pattern to add")
+		}
+		list.add(fs.Args(), *reason)
+	case "remove":
+		if fs.NArg() == 0 {
+			return errors.New("expected a target or pattern to remove")
+		}
+		if err := list.remove(fs.Args()); err != nil {
+			return err
+		}
+	case "list":
+		list.print(w)
+		return nil
+	default:
+		return fmt.Errorf("unknown skip command %q", args[0])
+	}
+	return list.save()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// Ways of distributing targets over the -ssh hosts.
+const (
+	sshRoundRobin = "round-robin" // each target runs on one host, in turn
+	sshAllHosts   = "all"         // each target runs on every host
+)
+
+// sshPool shares one connection per host between the commands run there,
+// using OpenSSH connection multiplexing.
+type sshPool struct {
+	hosts  []string
+	dir    string
+	client []string
+}
+
+// newSSHPool prepares the ssh client command for hosts, with extra options
+// from opts, and a directory for the control sockets of the connections.
+func newSSHPool(hosts []string, opts string) (*sshPool, error) {
+	dir, err := os.MkdirTemp("", "executor-ssh-")
+	if err != nil {
+		return nil, err
+	}
+
+	client := append([]string(nil), executor.DefaultSSH...)
+	client = append(client,
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath="+filepath.Join(dir, "%C"),
+		"-o", "ControlPersist=60",
+	)
+	client = append(client, strings.Fields(opts)...)
+	return &sshPool{hosts: hosts, dir: dir, client: client}, nil
+}
+
+// assign spreads targets over the hosts according to mode and returns the
+// resulting tasks with the host of each. index maps each task back to its
+// position in targets.
+func (p *sshPool) assign(targets []string, mode string) (tasks, hosts []string, index []int) {
+	for i, target := range targets {
+		if mode == sshAllHosts {
+			for _, host := range p.hosts {
+				tasks, hosts, index = append(tasks, target), append(hosts, host), append(index, i)
+			}
+			continue
+		}
+		tasks = append(tasks, target)
+		hosts = append(hosts, p.hosts[i%len(p.hosts)])
+		index = append(index, i)
+	}
+	return tasks, hosts, index
+}
+
+// close shuts down the shared connections and removes their sockets.
+func (p *sshPool) close() {
+	for _, host := range p.hosts {
+		args := append(p.client[1:len(p.client):len(p.client)], "-O", "exit", host)
+		exec.Command(p.client[0], args...).Run()
+	}
+	os.RemoveAll(p.dir)
+}
+
+// label names the target of result for display, along with its host if it
+// ran remotely.
+func label(result *executor.Result) string {
+	if result.Host == "" {
+		return result.Target
+	}
+	return fmt.Sprintf("%s:%s", result.Host, result.Target)
+}
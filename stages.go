@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// stageSummary counts the outcomes of one stage of a -stage pipeline.
+type stageSummary struct {
+	Name       string `json:"name"`
+	Succeeded  int    `json:"succeeded"`
+	Failed     int    `json:"failed"`
+	NotRun     int    `json:"not_run"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// parseStages parses -stage values of the form "NAME: COMMAND".
+func parseStages(values []string) ([]executor.Stage, error) {
+	var stages []executor.Stage
+	seen := make(map[string]bool)
+	for _, value := range values {
+		name, command, ok := strings.Cut(value, ":")
+		name, command = strings.TrimSpace(name), strings.TrimSpace(command)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") || command == "" {
+			return nil, fmt.Errorf("expected NAME: COMMAND, got %q", value)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate stage %q", name)
+		}
+		seen[name] = true
+		stages = append(stages, executor.Stage{Name: name, Command: command})
+	}
+	return stages, nil
+}
+
+// describeStages returns the pipeline of stages as one line.
+func describeStages(stages []executor.Stage) string {
+	parts := make([]string, len(stages))
+	for i, stage := range stages {
+		parts[i] = stage.Name + ": " + stage.Command
+	}
+	return strings.Join(parts, " | ")
+}
+
+// failedStage returns the name of the stage in which the pipeline of result
+// failed, or "" if it had none.
+func failedStage(result executor.Result) string {
+	if n := len(result.Stages); n > 0 && result.Stages[n-1].Err != nil {
+		return result.Stages[n-1].Name
+	}
+	return ""
+}
+
+// breakDown adds to the report how each of stages fared over results.
+// Targets that were processed but failed in an earlier stage count as not
+// run in the later ones.
+func (rep *report) breakDown(stages []executor.Stage, results executor.Results) {
+	rep.Stages = make([]stageSummary, len(stages))
+	for i, stage := range stages {
+		rep.Stages[i].Name = stage.Name
+	}
+	for _, result := range results {
+		if result.Skipped || result.Cancelled {
+			continue
+		}
+		for i := range rep.Stages {
+			summary := &rep.Stages[i]
+			if i >= len(result.Stages) {
+				summary.NotRun++
+				continue
+			}
+			stage := result.Stages[i]
+			if stage.Err != nil {
+				summary.Failed++
+			} else {
+				summary.Succeeded++
+			}
+			summary.DurationMS += stage.Duration.Milliseconds()
+		}
+	}
+}
+
+// printStages writes the breakdown of the report by stage, if any.
+func (rep *report) printStages(w io.Writer) {
+	if len(rep.Stages) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Stages:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  STAGE\tSUCCEEDED\tFAILED\tNOT RUN\tTIME")
+	for _, stage := range rep.Stages {
+		fmt.Fprintf(tw, "  %s\t%d\t%d\t%d\t%s\n", stage.Name, stage.Succeeded, stage.Failed, stage.NotRun,
+			(time.Duration(stage.DurationMS) * time.Millisecond).Round(time.Millisecond))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// stateSaveInterval bounds how much progress an interrupted run can lose.
+const stateSaveInterval = time.Second
+
+// runState is the content of a -state file.
+type runState struct {
+	Command   string   `json:"command"`
+	Completed []string `json:"completed"`
+
+	// Profile and Args are the profile and flags the run was started with,
+	// so "executor resume" can start it again
+	Profile string   `json:"profile,omitempty"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// stateFile records the targets that completed successfully so a later run
+// with -resume can skip them. Targets are stored as absolute paths so the
+// file stays valid when the run is resumed from another directory.
+type stateFile struct {
+	mu      sync.Mutex
+	path    string
+	state   runState
+	done    map[string]bool
+	dirty   bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// openState prepares the state file at path for a run of command. When resume
+// is set, the targets recorded by a previous run are loaded; otherwise the
+// run starts from scratch.
+func openState(path, command string, resume bool) (*stateFile, error) {
+	s := &stateFile{
+		path:  path,
+		state: runState{Command: command},
+		done:  make(map[string]bool),
+	}
+	if !resume {
+		return s, nil
+	}
+
+	state, err := readState(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.state = state
+	for _, target := range s.state.Completed {
+		s.done[target] = true
+	}
+	return s, nil
+}
+
+// readState reads the state file at path.
+func readState(path string) (runState, error) {
+	var state runState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// completed reports whether target completed successfully in a previous run.
+func (s *stateFile) completed(target string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done[absPath(target)]
+}
+
+// handle is the executor event handler that records successful targets.
+func (s *stateFile) handle(ev executor.Event) {
+	if ev.Type != executor.TaskFinished || ev.Result.Err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	target := absPath(ev.Target)
+	if !s.done[target] {
+		s.done[target] = true
+		s.state.Completed = append(s.state.Completed, target)
+		s.dirty = true
+	}
+}
+
+// autosave writes the state file periodically while the run is in progress.
+// Call close to stop it and write the final state.
+func (s *stateFile) autosave() {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+
+	go func() {
+		defer close(s.stopped)
+
+		ticker := time.NewTicker(stateSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.save()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close stops autosaving and writes the final state.
+func (s *stateFile) close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.stopped
+	}
+	s.mu.Lock()
+	s.dirty = true
+	s.mu.Unlock()
+	return s.save()
+}
+
+// save writes the state file if it changed. The file is replaced atomically
+// so a crash mid-write never leaves a truncated state behind.
+func (s *stateFile) save() error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// absPath returns the absolute form of path, or path itself if that fails.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// workerColors are the ANSI colors that tell the workers apart in streamed
+// output.
+var workerColors = []string{"36", "33", "35", "32", "34", "31"}
+
+// formatLine renders a line of streamed output prefixed with its target, in
+// the color of its worker if color is set.
+func formatLine(ev executor.Event, color bool) string {
+	prefix := paint(workerColors[ev.Worker%len(workerColors)], "["+ev.Target+"]", color)
+	return fmt.Sprintf("%s %s\n", prefix, ev.Line)
+}
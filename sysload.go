@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+)
+
+// parseWorkers parses the -workers flag: a number of workers, or "auto" for
+// one per CPU, which it reports as well.
+func parseWorkers(value string) (int, bool, error) {
+	if value == "auto" {
+		return runtime.NumCPU(), true, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, false, fmt.Errorf("%q is neither a positive number nor 'auto'", value)
+	}
+	return n, false, nil
+}
+
+// overloaded returns the executor.Executor.Overloaded hook reporting the
+// system as overloaded while its load average exceeds maxLoad or its
+// available memory falls below minFree. Zero disables either check, and
+// readings that cannot be taken never count as overloaded.
+func overloaded(maxLoad float64, minFree int64) func() bool {
+	if maxLoad <= 0 && minFree <= 0 {
+		return nil
+	}
+	return func() bool {
+		if maxLoad > 0 {
+			if load, err := loadAverage(); err == nil && load > maxLoad {
+				return true
+			}
+		}
+		if minFree > 0 {
+			if free, err := availableMemory(); err == nil && free < minFree {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errNoSysload = errors.New("not supported on this system")
+
+// loadAverage returns the one-minute load average of the system.
+func loadAverage() (float64, error) {
+	return 0, errNoSysload
+}
+
+// availableMemory returns the number of bytes of memory available for new
+// processes without swapping.
+func availableMemory() (int64, error) {
+	return 0, errNoSysload
+}
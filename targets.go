@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// targetList is a list of targets read from a file, with the columns of
+// each target when the file is a table.
+type targetList struct {
+	targets []string
+	columns [][]string
+	names   []string
+}
+
+// loadTargets reads the targets listed in the file at path. A .csv or .tsv
+// file is a table with one task per row: the first column is its target and
+// all columns are kept for the placeholders. If header is set, the first row
+// names the columns. Any other file lists one target per line, ignoring
+// blank lines, and if they are all URLs, gives them the columns of
+// withURLColumns. An s3://BUCKET/PREFIX path lists the objects under it.
+func loadTargets(path string, header bool) (*targetList, error) {
+	if strings.HasPrefix(path, "s3://") {
+		if header {
+			return nil, fmt.Errorf("%s is not a .csv or .tsv file, so it has no columns", path)
+		}
+		return listS3(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".csv" && ext != ".tsv" {
+		if header {
+			return nil, fmt.Errorf("%s is not a .csv or .tsv file, so it has no columns", path)
+		}
+		list := &targetList{}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				list.targets = append(list.targets, line)
+			}
+		}
+		list.withURLColumns()
+		return list, scanner.Err()
+	}
+
+	reader := csv.NewReader(file)
+	if ext == ".tsv" {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	list := &targetList{}
+	if header && len(rows) > 0 {
+		list.names = rows[0]
+		rows = rows[1:]
+	}
+	for _, row := range rows {
+		list.targets = append(list.targets, row[0])
+		list.columns = append(list.columns, row)
+	}
+	return list, nil
+}
+
+// dedupeTargets drops the targets that resolve to the same file as an
+// earlier one, through symbolic links or different spellings of the path.
+// Targets that don't exist are compared as they are.
+func dedupeTargets(targets []string) []string {
+	var kept []string
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		key := target
+		if resolved, err := filepath.EvalSymlinks(target); err == nil {
+			key = absPath(resolved)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, target)
+	}
+	return kept
+}
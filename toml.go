@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the subset of TOML used by executor's configuration
+// files into a *confMap: tables with dotted and quoted names, key/value
+// pairs, strings, numbers, booleans and single-line arrays of them. Values
+// are kept as strings. Inline tables, arrays of tables and multi-line
+// strings are not supported.
+func parseTOML(data []byte) (*confMap, error) {
+	root := newConfMap()
+	table := root
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		num := i + 1
+		line := strings.TrimSpace(stripTOMLComment(raw))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") || !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unsupported table header %s", num, line)
+			}
+			names, err := splitTOMLKey(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", num, err)
+			}
+			table = root
+			for _, name := range names {
+				if table, err = table.child(name); err != nil {
+					return nil, fmt.Errorf("line %d: %v", num, err)
+				}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", num)
+		}
+		names, err := splitTOMLKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", num, err)
+		}
+		parsed, err := parseTOMLValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", num, err)
+		}
+
+		// Dotted keys create nested tables below the current one
+		parent := table
+		for _, name := range names[:len(names)-1] {
+			if parent, err = parent.child(name); err != nil {
+				return nil, fmt.Errorf("line %d: %v", num, err)
+			}
+		}
+		last := names[len(names)-1]
+		if _, dup := parent.values[last]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", num, last)
+		}
+		parent.set(last, parsed)
+	}
+	return root, nil
+}
+
+// splitTOMLKey splits a possibly dotted key such as profiles."update-repos"
+// into its parts.
+func splitTOMLKey(key string) ([]string, error) {
+	var names []string
+	key = strings.TrimSpace(key)
+	for key != "" {
+		var name string
+		switch key[0] {
+		case '"', '\'':
+			end := strings.IndexByte(key[1:], key[0])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted key")
+			}
+			value, err := parseTOMLValue(key[:end+2])
+			if err != nil {
+				return nil, err
+			}
+			name, key = value.(string), strings.TrimSpace(key[end+2:])
+		default:
+			end := strings.IndexByte(key, '.')
+			if end < 0 {
+				end = len(key)
+			}
+			name, key = strings.TrimSpace(key[:end]), key[end:]
+			if name == "" {
+				return nil, fmt.Errorf("empty key")
+			}
+		}
+		names = append(names, name)
+
+		if key != "" {
+			if key[0] != '.' {
+				return nil, fmt.Errorf("invalid key")
+			}
+			key = strings.TrimSpace(key[1:])
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("empty key")
+	}
+	return names, nil
+}
+
+// parseTOMLValue parses a scalar or a single-line array of scalars.
+func parseTOMLValue(s string) (any, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated array %s", s)
+		}
+		var list []any
+		for _, item := range splitFlow(s[1 : len(s)-1]) {
+			value, err := parseTOMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+
+	case strings.HasPrefix(s, `"`):
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return unquoted, nil
+
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") || strings.Contains(s[1:len(s)-1], "'") {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+
+	default:
+		// Numbers and booleans are passed on as written
+		return s, nil
+	}
+}
+
+// stripTOMLComment removes a trailing comment outside of strings.
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
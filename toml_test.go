@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"empty", "", `{}`},
+		{"scalars", "a = 1\nb = true\nc = \"text\"\nd = 'raw \\n'\n", `{"a":"1" "b":"true" "c":"text" "d":"raw \\n"}`},
+		{"tables", "[profiles.fmt]\ncmd = \"gofmt -l {}\"\n[profiles.vet]\ncmd = \"go vet\"\n", `{"profiles":{"fmt":{"cmd":"gofmt -l {}"} "vet":{"cmd":"go vet"}}}`},
+		{"quoted table name", "[profiles.\"update repos\"]\nworkers = 2\n", `{"profiles":{"update repos":{"workers":"2"}}}`},
+		{"quoted keys", "\"*.go\" = \"gofmt\"\n'a.b' = \"c\"\n", `{"*.go":"gofmt" "a.b":"c"}`},
+		{"dotted keys", "a.b = 1\na.c = 2\n", `{"a":{"b":"1" "c":"2"}}`},
+		{"spaces around dots", "[ a . b ]\nc . d = 1\n", `{"a":{"b":{"c":{"d":"1"}}}}`},
+		{"arrays", "a = [\"x\", 'y, z', 3]\nb = []\n", `{"a":["x" "y, z" "3"] "b":[]}`},
+		{"comments", "# head\na = \"b # not\" # note\nc = 'd#e'#x\n", `{"a":"b # not" "c":"d#e"}`},
+		{"equals in value", "a = \"x = y\"\n", `{"a":"x = y"}`},
+		{"back to a table", "[a]\nx = 1\n[b]\ny = 2\n[a.c]\nz = 3\n", `{"a":{"x":"1" "c":{"z":"3"}} "b":{"y":"2"}}`},
+	}
+	for _, tt := range tests {
+		got, err := parseTOML([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: parseTOML(%q): %v", tt.name, tt.in, err)
+			continue
+		}
+		if dump := dumpConf(got); dump != tt.want {
+			t.Errorf("%s: parseTOML(%q) = %s, want %s", tt.name, tt.in, dump, tt.want)
+		}
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"duplicate key", "a = 1\nb = 2\na = 3\n", `line 3: duplicate key "a"`},
+		{"duplicate quoted key", "a = 1\n\"a\" = 2\n", `line 2: duplicate key "a"`},
+		{"duplicate dotted key", "[t]\nx.y = 1\nx.y = 2\n", `line 3: duplicate key "y"`},
+		{"key over a table", "[a.b]\n[a]\nb = 1\n", `line 3: duplicate key "b"`},
+		{"table over a key", "a = 1\n[a]\n", `line 2: "a" is not a table`},
+		{"array of tables", "[[a]]\n", "line 1: unsupported table header [[a]]"},
+		{"unclosed header", "[a\n", "line 1: unsupported table header [a"},
+		{"no equals", "a\n", "line 1: expected key = value"},
+		{"missing value", "a =\n", "line 1: missing value"},
+		{"empty key", "= 1\n", "line 1: empty key"},
+		{"empty dotted part", "a..b = 1\n", "line 1: empty key"},
+		{"unterminated quoted key", "\"a = 1\n", "line 1: unterminated quoted key"},
+		{"junk after quoted key", "\"a\"b = 1\n", "line 1: invalid key"},
+		{"bad string", "a = \"x\n", `line 1: invalid string "x`},
+		{"quote in literal", "a = 'x'y'\n", "line 1: invalid string 'x'y'"},
+		{"unterminated array", "a = [1, 2\n", "line 1: unterminated array [1, 2"},
+	}
+	for _, tt := range tests {
+		got, err := parseTOML([]byte(tt.in))
+		if err == nil {
+			t.Errorf("%s: parseTOML(%q) = %s, want error %q", tt.name, tt.in, dumpConf(got), tt.want)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("%s: parseTOML(%q) error = %q, want %q", tt.name, tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestStripTOMLComment(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"a = 1", "a = 1"},
+		{"# all", ""},
+		{"a = 1 # c", "a = 1 "},
+		{"a = 1#c", "a = 1"},
+		{`a = "b # c"`, `a = "b # c"`},
+		{`a = "b \" # c" # d`, `a = "b \" # c" `},
+		{"a = 'b # c' # d", "a = 'b # c' "},
+		{`a = 'b \' # c`, `a = 'b \' `},
+	}
+	for _, tt := range tests {
+		if got := stripTOMLComment(tt.in); got != tt.want {
+			t.Errorf("stripTOMLComment(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
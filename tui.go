@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+const (
+	tuiRefresh   = 200 * time.Millisecond
+	tuiBarWidth  = 30
+	tuiNameWidth = 60
+
+	// tuiETAWindow is how many of the latest task durations the ETA is
+	// based on, so it follows changes in how long tasks take
+	tuiETAWindow = 20
+)
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// tui renders a live status block at the bottom of the terminal: one line per
+// worker with its current target, and a progress bar with the failure count,
+// throughput and ETA. Finished task output is printed above the block so it
+// never gets mixed up with the status lines.
+type tui struct {
+	mu      sync.Mutex
+	out     io.Writer
+	total   int
+	done    int
+	failed  int
+	workers []string
+	current []int
+	recent  []time.Duration
+	next    int
+	start   time.Time
+	lines   int
+	output  outputOptions
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// newTUI starts rendering the status of a run of total targets on workers
+// workers. Call close once the run is over.
+func newTUI(out io.Writer, total, workers int, output outputOptions) *tui {
+	t := &tui{
+		out:     out,
+		total:   total,
+		workers: make([]string, workers),
+		current: make([]int, workers),
+		start:   time.Now(),
+		output:  output,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go t.refresh()
+	return t
+}
+
+// refresh redraws the status block periodically so elapsed time and ETA stay
+// current while long commands run.
+func (t *tui) refresh() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.mu.Lock()
+			t.redraw()
+			t.mu.Unlock()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// handle is the executor event handler.
+func (t *tui) handle(ev executor.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch ev.Type {
+	case executor.TaskStarted:
+		for len(t.workers) <= ev.Worker {
+			// Workers are added when the run is resized through -control
+			t.workers = append(t.workers, "")
+			t.current = append(t.current, 0)
+		}
+		t.workers[ev.Worker] = ev.Target
+		t.current[ev.Worker] = ev.Index
+
+	case executor.TaskRetrying:
+		t.workers[ev.Worker] = fmt.Sprintf("%s (retry %d)", ev.Target, ev.Attempt)
+
+	case executor.TaskFinished:
+		// With -keep-order the worker may have moved on to another target
+		if t.current[ev.Worker] == ev.Index {
+			t.workers[ev.Worker] = ""
+		}
+		t.done++
+		if ev.Result.Failed() {
+			t.failed++
+		}
+		if !ev.Result.Cancelled {
+			t.record(ev.Result.Duration)
+		}
+		if (ev.Result.Err != nil || !t.output.quietSuccess) && t.output.showResult(ev.Result) {
+			t.clear()
+			t.out.Write(formatResult(ev.Result, "", t.output.showOutput(ev.Result), t.output))
+		}
+
+	case executor.TaskSkipped:
+		// Targets skipped before they started may come from workers added
+		// since, or from none
+		if ev.Worker >= 0 && ev.Worker < len(t.current) && t.current[ev.Worker] == ev.Index {
+			t.workers[ev.Worker] = ""
+		}
+		t.done++
+		t.clear()
+		fmt.Fprintf(t.out, "%s %s\n", ev.Target, paint(colorWarning, "skipped: "+ev.Result.SkipReason, t.output.color))
+
+	case executor.TaskOutput:
+		if !t.output.showLine(ev) {
+			return
+		}
+		t.clear()
+		io.WriteString(t.out, formatLine(ev, t.output.color))
+	}
+	t.redraw()
+}
+
+// close stops refreshing and leaves the final state of the block on screen.
+func (t *tui) close() {
+	close(t.stop)
+	<-t.stopped
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.redraw()
+	t.lines = 0
+}
+
+// clear erases the status block and leaves the cursor where it started.
+func (t *tui) clear() {
+	for ; t.lines > 0; t.lines-- {
+		fmt.Fprint(t.out, "\x1b[1A\x1b[2K")
+	}
+}
+
+func (t *tui) redraw() {
+	t.clear()
+
+	var b strings.Builder
+	for id, target := range t.workers {
+		if target == "" {
+			target = "idle"
+		}
+		fmt.Fprintf(&b, "Worker %d: %s\n", id, truncateLeft(target, tuiNameWidth))
+	}
+
+	filled := 0
+	if t.total > 0 {
+		filled = t.done * tuiBarWidth / t.total
+	}
+	elapsed := time.Since(t.start)
+	fmt.Fprintf(&b, "[%s%s] %d/%d  failed: %d  elapsed: %s  %.1f tasks/s  ETA: %s\n",
+		strings.Repeat("#", filled), strings.Repeat("-", tuiBarWidth-filled),
+		t.done, t.total, t.failed, elapsed.Round(time.Second), float64(t.done)/elapsed.Seconds(), t.eta())
+
+	fmt.Fprint(t.out, b.String())
+	t.lines = len(t.workers) + 1
+}
+
+// record adds the duration of a finished task to the ETA window.
+func (t *tui) record(d time.Duration) {
+	if len(t.recent) < tuiETAWindow {
+		t.recent = append(t.recent, d)
+		return
+	}
+	t.recent[t.next] = d
+	t.next = (t.next + 1) % tuiETAWindow
+}
+
+// eta estimates the remaining time from the average duration of the latest
+// tasks, with the remaining ones shared among the workers.
+func (t *tui) eta() string {
+	if len(t.recent) == 0 {
+		return "unknown"
+	}
+	var sum time.Duration
+	for _, d := range t.recent {
+		sum += d
+	}
+	average := sum / time.Duration(len(t.recent))
+	rounds := (t.total - t.done + len(t.workers) - 1) / len(t.workers)
+	return (average * time.Duration(rounds)).Round(time.Second).String()
+}
+
+// truncateLeft shortens s to at most width runes, keeping its end, which is
+// the most telling part of a path.
+func truncateLeft(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return "..." + string(r[len(r)-width+3:])
+}
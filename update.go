@@ -0,0 +1,391 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the version of executor, set at build time with
+// -ldflags "-X main.version=v1.2.3". Without it, the module version recorded
+// by go install is used.
+var version = ""
+
+// updatePublicKey is the base64 ed25519 key that signs the checksums of the
+// releases, set at build time like version. Without it, self-update only
+// verifies the checksums, which says nothing of where they come from, and
+// warns about it.
+var updatePublicKey = ""
+
+// releasesURL is the GitHub API endpoint of the latest release.
+const releasesURL = "https://api.github.com/repos/truemilk/executor/releases/latest"
+
+// updateTimeout bounds each request of self-update.
+const updateTimeout = 2 * time.Minute
+
+// currentVersion returns the version of the running binary, or "dev".
+func currentVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// printVersion writes what "executor version" reports.
+func printVersion(w io.Writer) {
+	fmt.Fprintf(w, "executor %s (%s/%s, %s)\n", currentVersion(), runtime.GOOS, runtime.GOARCH, runtime.Version())
+}
+
+// release is the part of a GitHub release that self-update reads.
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// asset returns the download URL of the asset called name.
+func (r *release) asset(name string) (string, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL, true
+		}
+	}
+	return "", false
+}
+
+// selfUpdate replaces the running binary with that of the latest release if
+// it is newer. With force set, it also installs an older release or replaces
+// a binary whose version is unknown, such as a dev build. With check set, it
+// only reports whether an update is available. The archive of the release
+// for this platform, named as goreleaser does, must match checksums.txt, and
+// checksums.txt its signature checksums.txt.sig if updatePublicKey is set.
+func selfUpdate(w io.Writer, check, force bool) error {
+	client := &http.Client{Timeout: updateTimeout}
+	data, err := download(client, releasesURL)
+	if err != nil {
+		return fmt.Errorf("cannot get the latest release: %w", err)
+	}
+	var latest release
+	if err := json.Unmarshal(data, &latest); err != nil {
+		return fmt.Errorf("cannot read the latest release: %w", err)
+	}
+
+	current := currentVersion()
+	if _, ok := parseSemver(latest.TagName); !ok {
+		return fmt.Errorf("the latest release has an invalid version %q", latest.TagName)
+	}
+	order, known := compareVersions(latest.TagName, current)
+	switch {
+	case known && order == 0:
+		fmt.Fprintf(w, "executor %s is up to date\n", current)
+		return nil
+	case check && known && order < 0:
+		fmt.Fprintf(w, "executor %s is newer than the latest release %s\n", current, latest.TagName)
+		return nil
+	case check:
+		fmt.Fprintf(w, "executor %s is available (this is %s)\n", latest.TagName, current)
+		return nil
+	case !known && !force:
+		return fmt.Errorf("cannot tell whether %s is newer than this executor %s; use -force to install it anyway", latest.TagName, current)
+	case order < 0 && !force:
+		return fmt.Errorf("the latest release %s is older than this executor %s; use -force to downgrade", latest.TagName, current)
+	}
+
+	ext := ".tar.gz"
+	if runtime.GOOS == "windows" {
+		ext = ".zip"
+	}
+	archiveName := fmt.Sprintf("executor_%s_%s_%s%s", strings.TrimPrefix(latest.TagName, "v"), runtime.GOOS, runtime.GOARCH, ext)
+	archiveURL, ok := latest.asset(archiveName)
+	if !ok {
+		return fmt.Errorf("release %s has no %s", latest.TagName, archiveName)
+	}
+	checksumsURL, ok := latest.asset("checksums.txt")
+	if !ok {
+		return fmt.Errorf("release %s has no checksums.txt", latest.TagName)
+	}
+
+	checksums, err := download(client, checksumsURL)
+	if err != nil {
+		return fmt.Errorf("cannot download checksums.txt: %w", err)
+	}
+	if updatePublicKey != "" {
+		if err := verifySignature(client, &latest, checksums); err != nil {
+			return err
+		}
+	} else {
+		warnf(os.Stderr, "not verifying the signature of %s, as this executor was built without a public key; only its checksum proves it wasn't corrupted, not who published it", latest.TagName)
+	}
+	archive, err := download(client, archiveURL)
+	if err != nil {
+		return fmt.Errorf("cannot download %s: %w", archiveName, err)
+	}
+	if err := verifyChecksum(checksums, archiveName, archive); err != nil {
+		return err
+	}
+
+	binary, err := extractBinary(archive, ext)
+	if err != nil {
+		return fmt.Errorf("cannot extract %s: %w", archiveName, err)
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return err
+	}
+	if err := replaceExecutable(path, binary); err != nil {
+		return fmt.Errorf("cannot replace %s: %w", path, err)
+	}
+	fmt.Fprintf(w, "Updated executor from %s to %s\n", current, latest.TagName)
+	return nil
+}
+
+// semver is a version such as v1.2.3-rc.1, without its build metadata.
+type semver struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+// parseSemver parses a semantic version with or without its leading "v".
+func parseSemver(s string) (semver, bool) {
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, pre, hasPre := strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+	var v semver
+	for i, field := range []*int{&v.major, &v.minor, &v.patch} {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 {
+			return semver{}, false
+		}
+		*field = n
+	}
+	if hasPre {
+		v.pre = strings.Split(pre, ".")
+		if slices.Contains(v.pre, "") {
+			return semver{}, false
+		}
+	}
+	return v, true
+}
+
+// compareVersions compares the versions a and b as semver does, returning
+// -1, 0 or 1. It reports false if either is not a semantic version.
+func compareVersions(a, b string) (int, bool) {
+	va, ok := parseSemver(a)
+	if !ok {
+		return 0, false
+	}
+	vb, ok := parseSemver(b)
+	if !ok {
+		return 0, false
+	}
+	if c := cmp.Compare(va.major, vb.major); c != 0 {
+		return c, true
+	}
+	if c := cmp.Compare(va.minor, vb.minor); c != 0 {
+		return c, true
+	}
+	if c := cmp.Compare(va.patch, vb.patch); c != 0 {
+		return c, true
+	}
+
+	// A pre-release comes before the release, and its identifiers compare
+	// numerically when they are numbers, which come before the others
+	if len(va.pre) == 0 || len(vb.pre) == 0 {
+		return cmp.Compare(len(vb.pre), len(va.pre)), true
+	}
+	for i := 0; i < len(va.pre) && i < len(vb.pre); i++ {
+		na, errA := strconv.Atoi(va.pre[i])
+		nb, errB := strconv.Atoi(vb.pre[i])
+		var c int
+		switch {
+		case errA == nil && errB == nil:
+			c = cmp.Compare(na, nb)
+		case errA == nil:
+			c = -1
+		case errB == nil:
+			c = 1
+		default:
+			c = strings.Compare(va.pre[i], vb.pre[i])
+		}
+		if c != 0 {
+			return c, true
+		}
+	}
+	return cmp.Compare(len(va.pre), len(vb.pre)), true
+}
+
+// download returns the body of url. A GITHUB_TOKEN in the environment is
+// sent along to raise the rate limit of the API.
+func download(client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && strings.HasPrefix(url, "https://api.github.com/") {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// verifySignature checks checksums against the checksums.txt.sig of the
+// release, a base64 ed25519 signature by updatePublicKey.
+func verifySignature(client *http.Client, r *release, checksums []byte) error {
+	key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("the public key built into executor is invalid")
+	}
+	url, ok := r.asset("checksums.txt.sig")
+	if !ok {
+		return fmt.Errorf("release %s has no checksums.txt.sig", r.TagName)
+	}
+	data, err := download(client, url)
+	if err != nil {
+		return fmt.Errorf("cannot download checksums.txt.sig: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), checksums, sig) {
+		return errors.New("the signature of checksums.txt does not match")
+	}
+	return nil
+}
+
+// verifyChecksum checks data against the SHA-256 of name in checksums, a
+// file of "HASH  NAME" lines as written by sha256sum.
+func verifyChecksum(checksums []byte, name string, data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+			return fmt.Errorf("the checksum of %s does not match", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("checksums.txt lists no %s", name)
+}
+
+// extractBinary returns the executor binary in a .tar.gz or .zip archive.
+func extractBinary(archive []byte, ext string) ([]byte, error) {
+	name := "executor"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	if ext == ".zip" {
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range zr.File {
+			if filepath.Base(file.Name) == name {
+				f, err := file.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer f.Close()
+				return io.ReadAll(f)
+			}
+		}
+		return nil, fmt.Errorf("no %s in the archive", name)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s in the archive", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == name {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// replaceExecutable atomically replaces the file at path with binary: it is
+// written next to it and renamed over it. The running binary is moved aside
+// first where it cannot be replaced while it runs.
+func replaceExecutable(path string, binary []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".executor-update-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0o111); err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		old := path + ".old"
+		os.Remove(old)
+		if err := os.Rename(path, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			os.Rename(old, path)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), path)
+}
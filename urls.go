@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// urlColumns name the columns given to targets that are URLs, so commands
+// can refer to them as {url} and {key}.
+var urlColumns = []string{"url", "key"}
+
+// withURLColumns gives each target of list its URL and key as columns if
+// all of them are http(s) or s3 URLs. Tables keep their own columns.
+func (list *targetList) withURLColumns() {
+	if list.columns != nil {
+		return
+	}
+	columns := make([][]string, len(list.targets))
+	for i, target := range list.targets {
+		key, ok := urlKey(target)
+		if !ok {
+			return
+		}
+		columns[i] = []string{target, key}
+	}
+	list.columns, list.names = columns, urlColumns
+}
+
+// urlKey returns the key of an s3 URL, or the path of an http(s) URL,
+// without the leading slash. It reports false if target is not such a URL.
+func urlKey(target string) (string, bool) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	switch u.Scheme {
+	case "http", "https", "s3":
+		return strings.TrimPrefix(u.Path, "/"), true
+	}
+	return "", false
+}
+
+// listS3 lists the objects under prefix, an s3://BUCKET/PREFIX URL, as
+// targets with the URL and key of each. It runs the AWS CLI, which picks up
+// the credentials and region as usual, and leaves out folder markers.
+func listS3(prefix string) (*targetList, error) {
+	bucket, keyPrefix, _ := strings.Cut(strings.TrimPrefix(prefix, "s3://"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("%s names no bucket", prefix)
+	}
+
+	cmd := exec.Command("aws", "s3api", "list-objects-v2", "--bucket", bucket, "--prefix", keyPrefix,
+		"--query", "Contents[].Key", "--output", "json")
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("cannot list %s: %s", prefix, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("cannot list %s: %w", prefix, err)
+	}
+	var keys []string
+	if err := json.Unmarshal(out, &keys); err != nil {
+		return nil, fmt.Errorf("cannot list %s: %w", prefix, err)
+	}
+
+	list := &targetList{names: urlColumns}
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		target := "s3://" + bucket + "/" + key
+		list.targets = append(list.targets, target)
+		list.columns = append(list.columns, []string{target, key})
+	}
+	return list, nil
+}
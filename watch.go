@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// watchFullWalk is how many polls go by between two walks of every watched
+// tree; the polls in between only look at the top level of each target.
+const watchFullWalk = 15
+
+// fingerprint summarizes the state of a target: a change to any file in it
+// changes its fingerprint.
+type fingerprint struct {
+	entries  int
+	size     int64
+	modified time.Time
+}
+
+// watcher detects changes to targets. Notifications from the system, where
+// available, or polls every interval tell which targets may have changed,
+// and their fingerprints tell whether they did.
+type watcher struct {
+	targets []string
+	prints  []fingerprint
+
+	mu         sync.Mutex
+	candidates map[int]bool
+	signal     chan struct{}
+}
+
+// newWatcher starts watching targets from their current state until ctx is
+// done. It polls them every interval when the system cannot notify it of
+// changes, returning why as well.
+func newWatcher(ctx context.Context, targets []string, interval time.Duration) (*watcher, error) {
+	w := &watcher{
+		targets:    targets,
+		prints:     make([]fingerprint, len(targets)),
+		candidates: make(map[int]bool),
+		signal:     make(chan struct{}, 1),
+	}
+	w.reset()
+	err := watchNotify(ctx, w)
+	if err != nil {
+		go w.poll(ctx, interval)
+	}
+	return w, err
+}
+
+// mark records that the targets at indices may have changed.
+func (w *watcher) mark(indices ...int) {
+	w.mu.Lock()
+	for _, i := range indices {
+		w.candidates[i] = true
+	}
+	w.mu.Unlock()
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+// markAll records that any target may have changed.
+func (w *watcher) markAll() {
+	indices := make([]int, len(w.targets))
+	for i := range indices {
+		indices[i] = i
+	}
+	w.mark(indices...)
+}
+
+// take returns the targets that may have changed since the last call.
+func (w *watcher) take() map[int]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	candidates := w.candidates
+	w.candidates = make(map[int]bool)
+	return candidates
+}
+
+// poll marks the targets whose top level changed every interval, and all of
+// them every watchFullWalk polls, for changes deeper down.
+func (w *watcher) poll(ctx context.Context, interval time.Duration) {
+	tops := make([]fingerprint, len(w.targets))
+	for i, target := range w.targets {
+		tops[i] = topFingerprintOf(target)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for polls := 1; ; polls++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if polls%watchFullWalk == 0 {
+			w.markAll()
+			continue
+		}
+		for i, target := range w.targets {
+			if fp := topFingerprintOf(target); fp != tops[i] {
+				tops[i] = fp
+				w.mark(i)
+			}
+		}
+	}
+}
+
+// reset takes the current state of the targets as unchanged, so the changes
+// made by the commands themselves don't trigger another run.
+func (w *watcher) reset() {
+	for i, target := range w.targets {
+		w.prints[i] = fingerprintOf(target)
+	}
+}
+
+// wait blocks until some targets changed and then stayed unchanged for the
+// debounce period, and returns their indices in order. It returns nil when
+// ctx is done.
+func (w *watcher) wait(ctx context.Context, debounce time.Duration) []int {
+	changed := make(map[int]bool)
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-w.signal:
+			for i := range w.take() {
+				if fp := fingerprintOf(w.targets[i]); fp != w.prints[i] {
+					w.prints[i] = fp
+					changed[i] = true
+					settled = time.After(debounce)
+				}
+			}
+		case <-settled:
+			indices := make([]int, 0, len(changed))
+			for i := range changed {
+				indices = append(indices, i)
+			}
+			slices.Sort(indices)
+			return indices
+		}
+	}
+}
+
+// fingerprintOf reads the state of target, covering every file below it if
+// it is a directory. Git metadata is left out: it changes with every git
+// command, not with the contents. A missing target has the zero fingerprint.
+func fingerprintOf(target string) fingerprint {
+	var fp fingerprint
+	filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" && path != target {
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fp.add(info)
+		return nil
+	})
+	return fp
+}
+
+// topFingerprintOf reads the state of target and, if it is a directory, of
+// its entries, but not of anything deeper.
+func topFingerprintOf(target string) fingerprint {
+	var fp fingerprint
+	info, err := os.Stat(target)
+	if err != nil {
+		return fp
+	}
+	fp.add(info)
+	if !info.IsDir() {
+		return fp
+	}
+	entries, _ := os.ReadDir(target)
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			fp.add(info)
+		}
+	}
+	return fp
+}
+
+// add counts the file described by info in the fingerprint.
+func (fp *fingerprint) add(info fs.FileInfo) {
+	fp.entries++
+	if !info.IsDir() {
+		fp.size += info.Size()
+	}
+	if info.ModTime().After(fp.modified) {
+		fp.modified = info.ModTime()
+	}
+}
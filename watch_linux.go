@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask selects the inotify events that may change a fingerprint.
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+	syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotifyOwner is a target interested in the events of a watched directory:
+// in all of them when it is the target or below it, or else only in those
+// about its entry name, the target itself.
+type inotifyOwner struct {
+	index int
+	name  string
+}
+
+// inotifyDir is a watched directory and the targets it tells about.
+type inotifyDir struct {
+	path   string
+	owners []inotifyOwner
+}
+
+// inotifyWatcher maps inotify watches to the directories they are on. Once
+// watching started, only the goroutine reading events uses it.
+type inotifyWatcher struct {
+	fd      int
+	targets []string
+	dirs    map[int32]*inotifyDir
+}
+
+// watchNotify has inotify mark the targets of w that may have changed until
+// ctx is done: each directory in a target, and the parent directory of each
+// target, for it to be created or replaced, is watched. It fails if the
+// watches would exceed the limit of the system.
+func watchNotify(ctx context.Context, w *watcher) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking descriptor goes through the runtime poller, so closing
+	// the file interrupts a pending read
+	file := os.NewFile(uintptr(fd), "inotify")
+	n := &inotifyWatcher{fd: fd, targets: w.targets, dirs: make(map[int32]*inotifyDir)}
+	for i, target := range w.targets {
+		err := n.add(filepath.Dir(target), inotifyOwner{index: i, name: filepath.Base(target)})
+		if err == nil {
+			err = n.addTree(target, i)
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+	go n.read(file, w)
+	return nil
+}
+
+// add watches dir for owner. Directories that don't exist are left out.
+func (n *inotifyWatcher) add(dir string, owner inotifyOwner) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, inotifyMask|syscall.IN_MASK_ADD|syscall.IN_ONLYDIR)
+	switch {
+	case err == syscall.ENOSPC:
+		return os.NewSyscallError("inotify_add_watch", err)
+	case err != nil:
+		return nil
+	}
+	d := n.dirs[int32(wd)]
+	if d == nil {
+		d = &inotifyDir{path: dir}
+		n.dirs[int32(wd)] = d
+	}
+	if !slices.Contains(d.owners, owner) {
+		d.owners = append(d.owners, owner)
+	}
+	return nil
+}
+
+// addTree watches root and every directory below it for the target at
+// index, leaving git metadata out as fingerprintOf does.
+func (n *inotifyWatcher) addTree(root string, index int) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if d.Name() == ".git" && path != root {
+			return filepath.SkipDir
+		}
+		return n.add(path, inotifyOwner{index: index})
+	})
+}
+
+// read marks the targets concerned by each event until file is closed.
+func (n *inotifyWatcher) read(file *os.File, w *watcher) {
+	buf := make([]byte, 64*1024)
+	for {
+		size, err := file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= size; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			name := string(nameBytes)
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			n.handle(event, name, w)
+		}
+	}
+}
+
+// handle marks the targets concerned by event, about the entry name of the
+// watched directory, and watches the directories it creates in targets.
+func (n *inotifyWatcher) handle(event *syscall.InotifyEvent, name string, w *watcher) {
+	if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.markAll()
+		return
+	}
+	dir := n.dirs[event.Wd]
+	if dir == nil {
+		return
+	}
+	if event.Mask&syscall.IN_IGNORED != 0 {
+		delete(n.dirs, event.Wd)
+	}
+
+	created := event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0
+	var indices []int
+	for _, owner := range slices.Clone(dir.owners) {
+		switch {
+		case owner.name == "" && name == ".git":
+			continue
+		case owner.name != "" && owner.name != name:
+			continue
+		}
+		indices = append(indices, owner.index)
+		if created {
+			// Watch the new directory, in a target or the target itself, as
+			// it may only be filled later. Past the limit of the system,
+			// changes below it go unnoticed.
+			n.addTree(filepath.Join(dir.path, name), owner.index)
+		}
+	}
+	if len(indices) > 0 {
+		w.mark(indices...)
+	}
+}
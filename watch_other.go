@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// watchNotify would have the system mark the targets of w that may have
+// changed, but only inotify is supported, so targets are polled instead.
+func watchNotify(ctx context.Context, w *watcher) error {
+	return errors.New("change notifications are not supported on this system")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// webFailures is how many of the latest failures the status page shows.
+const webFailures = 20
+
+// webOutputTail is how much of the output of a failure the status page
+// shows, from its end.
+const webOutputTail = 4096
+
+//go:embed web.html
+var webPage []byte
+
+// webStatus is what the status page polls from /status.
+type webStatus struct {
+	Targets  int           `json:"targets"`
+	Done     int           `json:"done"`
+	Failed   int           `json:"failed"`
+	Skipped  int           `json:"skipped"`
+	Elapsed  float64       `json:"elapsed_seconds"`
+	Finished bool          `json:"finished"`
+	Running  []controlTask `json:"running"`
+	Failures []webFailure  `json:"failures"`
+}
+
+// webFailure is a failed target on the status page, latest first.
+type webFailure struct {
+	Target string `json:"target"`
+	Kind   string `json:"kind"`
+	Error  string `json:"error"`
+	Output string `json:"output"`
+}
+
+// webServer serves a status page for -web, following the progress of the
+// runs like controlServer, and the report of the run so far as JSON.
+type webServer struct {
+	command string
+	profile string
+	args    []string
+	seed    uint64
+	server  *http.Server
+
+	mu       sync.Mutex
+	targets  int
+	start    time.Time
+	end      time.Time           // when the run finished, or zero
+	running  map[int]controlTask // by target index
+	results  executor.Results    // of the tasks done, without the output of those that succeeded
+	failures []webFailure
+}
+
+// serveWeb starts serving the status page on addr, such as ":8080", for
+// runs of command started with profile and args and scheduled from seed.
+func serveWeb(addr, command, profile string, args []string, seed uint64) (*webServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &webServer{
+		command: command,
+		profile: profile,
+		args:    args,
+		seed:    seed,
+		start:   time.Now(),
+		running: make(map[int]controlTask),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webPage)
+	})
+	mux.HandleFunc("GET /status", s.serveStatus)
+	mux.HandleFunc("GET /report.json", s.serveReport)
+	s.server = &http.Server{Handler: mux}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// plan starts following a run of the given number of targets.
+func (s *webServer) plan(targets int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets, s.start, s.end, s.results = targets, time.Now(), time.Time{}, nil
+}
+
+// finish marks the current run as over.
+func (s *webServer) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.end = time.Now()
+}
+
+// elapsed returns how long the current run took so far.
+func (s *webServer) elapsed() time.Duration {
+	if s.end.IsZero() {
+		return time.Since(s.start)
+	}
+	return s.end.Sub(s.start)
+}
+
+// handle is the executor event handler that follows the progress of the run.
+func (s *webServer) handle(ev executor.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch ev.Type {
+	case executor.TaskStarted:
+		s.running[ev.Index] = controlTask{Target: ev.Target, Worker: ev.Worker, start: time.Now()}
+		return
+	case executor.TaskFinished, executor.TaskSkipped:
+	default:
+		return
+	}
+	delete(s.running, ev.Index)
+
+	result := *ev.Result
+	if result.Failed() {
+		output := result.Output
+		if len(output) > webOutputTail {
+			output = output[len(output)-webOutputTail:]
+		}
+		failure := webFailure{Target: result.Target, Kind: string(result.Failure()), Error: result.Err.Error(), Output: string(output)}
+		s.failures = append([]webFailure{failure}, s.failures[:min(len(s.failures), webFailures-1)]...)
+	} else {
+		result.Output, result.Stdout, result.Stderr = nil, nil, nil
+	}
+	s.results = append(s.results, result)
+}
+
+// serveStatus writes the current state of the run.
+func (s *webServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	status := webStatus{
+		Targets:  s.targets,
+		Done:     len(s.results),
+		Failed:   s.results.Failed(),
+		Skipped:  s.results.Skipped(),
+		Elapsed:  s.elapsed().Seconds(),
+		Finished: !s.end.IsZero(),
+		Running:  []controlTask{},
+		Failures: append([]webFailure{}, s.failures...),
+	}
+	for _, task := range s.running {
+		task.Elapsed = time.Since(task.start).Seconds()
+		status.Running = append(status.Running, task)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(status.Running, func(i, j int) bool {
+		return status.Running[i].Worker < status.Running[j].Worker
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// serveReport writes the report of the tasks done so far, as -report would
+// at the end of the run.
+func (s *webServer) serveReport(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	rep := newReport(s.results, s.elapsed())
+	s.mu.Unlock()
+	rep.Command, rep.Profile, rep.Args, rep.Seed = s.command, s.profile, s.args, s.seed
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="report.json"`)
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(append(data, '\n'))
+}
+
+// close stops serving the status page.
+func (s *webServer) close() error {
+	return s.server.Close()
+}
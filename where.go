@@ -0,0 +1,283 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// whereFile is what a -where expression is evaluated against.
+type whereFile struct {
+	path string
+	info os.FileInfo
+	root string
+	now  time.Time
+}
+
+// wherePredicate is a compiled -where expression.
+type wherePredicate func(f *whereFile) bool
+
+// The fields of -where expressions by type.
+var (
+	whereBools = map[string]func(f *whereFile) bool{
+		"isdir":  func(f *whereFile) bool { return f.info.IsDir() },
+		"isfile": func(f *whereFile) bool { return f.info.Mode().IsRegular() },
+		"islink": func(f *whereFile) bool { return fileType(f.path, f.info) == 'l' },
+	}
+	whereStrings = map[string]func(f *whereFile) string{
+		"path": func(f *whereFile) string { return f.path },
+		"name": func(f *whereFile) string { return filepath.Base(f.path) },
+		"dir":  func(f *whereFile) string { return filepath.Dir(f.path) },
+		"ext":  func(f *whereFile) string { return strings.TrimPrefix(filepath.Ext(f.path), ".") },
+	}
+	whereNumbers = map[string]func(f *whereFile) int64{
+		"size":  func(f *whereFile) int64 { return f.info.Size() },
+		"depth": func(f *whereFile) int64 { return int64(pathDepth(f.root, f.path)) },
+		"age":   func(f *whereFile) int64 { return int64(f.now.Sub(f.info.ModTime())) },
+	}
+)
+
+// parseWhere compiles a -where expression such as
+//
+//	isdir && name =~ "^lib" || size > 1MB && age < 7d
+//
+// Expressions combine comparisons and the boolean fields isdir, isfile and
+// islink with &&, || and !, and parentheses. The string fields path, name,
+// dir and ext (without its dot) compare with ==, !=, <, <=, >, >= and match
+// regular expressions with =~ and !~; the numeric fields size (as in
+// -min-size), depth (as in -min-depth) and age (as in -newer-than) compare
+// with the first six. Strings are double-quoted, or bare words.
+func parseWhere(expr string) (wherePredicate, error) {
+	tokens, err := tokenizeWhere(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &whereParser{tokens: tokens}
+	pred, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return pred, nil
+}
+
+// whereOperators lists the operators, longest first so that "!=" is not
+// taken for "!".
+var whereOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")"}
+
+// tokenizeWhere splits expr into operators, quoted strings and words.
+func tokenizeWhere(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		if c == ' ' || c == '\t' || c == '\n' {
+			i++
+			continue
+		}
+
+		if c == '"' {
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string %s", expr[i:])
+			}
+			tokens = append(tokens, expr[i:end+1])
+			i = end + 1
+			continue
+		}
+
+		operator := ""
+		for _, op := range whereOperators {
+			if strings.HasPrefix(expr[i:], op) {
+				operator = op
+				break
+			}
+		}
+		if operator != "" {
+			tokens = append(tokens, operator)
+			i += len(operator)
+			continue
+		}
+
+		end := i
+		for end < len(expr) && isWordByte(expr[end]) {
+			end++
+		}
+		if end == i {
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+		tokens = append(tokens, expr[i:end])
+		i = end
+	}
+	return tokens, nil
+}
+
+// isWordByte reports whether c can be part of a bare word.
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("._-/*+", c) >= 0
+}
+
+// whereParser is a recursive descent parser of -where expressions.
+type whereParser struct {
+	tokens []string
+	pos    int
+}
+
+// next returns the next token without consuming it, or "" at the end.
+func (p *whereParser) next() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *whereParser) or() (wherePredicate, error) {
+	left, err := p.and()
+	for err == nil && p.next() == "||" {
+		p.pos++
+		var right wherePredicate
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(f *whereFile) bool { return l(f) || right(f) }
+		}
+	}
+	return left, err
+}
+
+func (p *whereParser) and() (wherePredicate, error) {
+	left, err := p.unary()
+	for err == nil && p.next() == "&&" {
+		p.pos++
+		var right wherePredicate
+		if right, err = p.unary(); err == nil {
+			l := left
+			left = func(f *whereFile) bool { return l(f) && right(f) }
+		}
+	}
+	return left, err
+}
+
+func (p *whereParser) unary() (wherePredicate, error) {
+	switch p.next() {
+	case "!":
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(f *whereFile) bool { return !operand(f) }, nil
+	case "(":
+		p.pos++
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return inner, nil
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return p.comparison()
+}
+
+// comparison parses a boolean field, or a field compared with a value.
+func (p *whereParser) comparison() (wherePredicate, error) {
+	field := p.next()
+	p.pos++
+	if get, ok := whereBools[field]; ok {
+		return get, nil
+	}
+
+	op := p.next()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+		p.pos++
+	default:
+		if _, ok := whereStrings[field]; !ok {
+			if _, ok := whereNumbers[field]; !ok {
+				return nil, fmt.Errorf("unknown field %q", field)
+			}
+		}
+		return nil, fmt.Errorf("expected an operator after %s", field)
+	}
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("expected a value after %s %s", field, op)
+	}
+	literal := p.next()
+	p.pos++
+	value := literal
+	if strings.HasPrefix(literal, `"`) {
+		var err error
+		if value, err = strconv.Unquote(literal); err != nil {
+			return nil, fmt.Errorf("invalid string %s", literal)
+		}
+	}
+
+	if get, ok := whereStrings[field]; ok {
+		if op == "=~" || op == "!~" {
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, err
+			}
+			return func(f *whereFile) bool { return re.MatchString(get(f)) == (op == "=~") }, nil
+		}
+		return func(f *whereFile) bool { return compare(strings.Compare(get(f), value), op) }, nil
+	}
+
+	get, ok := whereNumbers[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+	if op == "=~" || op == "!~" {
+		return nil, fmt.Errorf("cannot match %s against a regular expression", field)
+	}
+	var n int64
+	var err error
+	switch field {
+	case "size":
+		n, err = parseSize(value)
+	case "age":
+		var d time.Duration
+		d, err = parseAge(value)
+		n = int64(d)
+	default:
+		n, err = strconv.ParseInt(value, 10, 64)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", field, value)
+	}
+	return func(f *whereFile) bool { return compare(cmp.Compare(get(f), n), op) }, nil
+}
+
+// compare reports whether the result c of a three-way comparison satisfies
+// op.
+func compare(c int, op string) bool {
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
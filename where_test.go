@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeInfo is the os.FileInfo of a file that doesn't exist.
+type fakeInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi fakeInfo) Name() string       { return fi.name }
+func (fi fakeInfo) Size() int64        { return fi.size }
+func (fi fakeInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi fakeInfo) ModTime() time.Time { return fi.modTime }
+func (fi fakeInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fakeInfo) Sys() any           { return nil }
+
+func TestTokenizeWhere(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "isdir", want: []string{"isdir"}},
+		{in: "!isdir&&size>=1MB", want: []string{"!", "isdir", "&&", "size", ">=", "1MB"}},
+		{in: "name != a || !(ext == go)", want: []string{"name", "!=", "a", "||", "!", "(", "ext", "==", "go", ")"}},
+		{in: "name =~ x !~ y", want: []string{"name", "=~", "x", "!~", "y"}},
+		{in: "a<b<=c>d>=e", want: []string{"a", "<", "b", "<=", "c", ">", "d", ">=", "e"}},
+		{in: `path == "a b" && name == "say \"hi\""`, want: []string{"path", "==", `"a b"`, "&&", "name", "==", `"say \"hi\""`}},
+		{in: "path == ./src/*.go+x-y_z", want: []string{"path", "==", "./src/*.go+x-y_z"}},
+		{in: "\tisdir\n", want: []string{"isdir"}},
+		{in: `name == "open`, wantErr: true},
+		{in: `name == "trailing\"`, wantErr: true},
+		{in: "name == $HOME", wantErr: true},
+		{in: "name = a", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := tokenizeWhere(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("tokenizeWhere(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("tokenizeWhere(%q): %v", tt.in, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("tokenizeWhere(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseWhereErrors(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", "unexpected end of expression"},
+		{"isdir &&", "unexpected end of expression"},
+		{"!", "unexpected end of expression"},
+		{"(isdir", "missing )"},
+		{"isdir)", `unexpected ")"`},
+		{"isdir isfile", `unexpected "isfile"`},
+		{"color == red", `unknown field "color"`},
+		{"color", `unknown field "color"`},
+		{"name", "expected an operator after name"},
+		{"size isdir", "expected an operator after size"},
+		{"name ==", "expected a value after name =="},
+		{`name == "open`, `unterminated string "open`},
+		{"name == $x", `unexpected '$'`},
+		{`name == "\q"`, `invalid string "\q"`},
+		{"name =~ (", "error parsing regexp: missing closing ): `(`"},
+		{"size =~ 1", "cannot match size against a regular expression"},
+		{"size > big", `invalid size "big"`},
+		{"age < soon", `invalid age "soon"`},
+		{"depth == 1.5", `invalid depth "1.5"`},
+	}
+	for _, tt := range tests {
+		_, err := parseWhere(tt.in)
+		if err == nil {
+			t.Errorf("parseWhere(%q) succeeded, want error %q", tt.in, tt.want)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("parseWhere(%q) error = %q, want %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestParseWhere(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	root := filepath.Join("no", "such", "root")
+	files := []struct {
+		label string
+		path  string
+		info  fakeInfo
+	}{
+		{"lib", "lib", fakeInfo{mode: fs.ModeDir | 0o755, modTime: now.Add(-time.Hour)}},
+		{"util", filepath.Join("lib", "util.go"), fakeInfo{size: 2048, mode: 0o644, modTime: now.Add(-2 * time.Hour)}},
+		{"data", "data.bin", fakeInfo{size: 3 << 20, mode: 0o644, modTime: now.Add(-30 * 24 * time.Hour)}},
+		{"pipe", "pipe", fakeInfo{mode: fs.ModeNamedPipe | 0o600, modTime: now}},
+	}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"isdir", []string{"lib"}},
+		{"isfile", []string{"util", "data"}},
+		{"!isdir", []string{"util", "data", "pipe"}},
+		{"islink", nil},
+		{"name == util.go", []string{"util"}},
+		{`name == "util.go"`, []string{"util"}},
+		{"name != util.go", []string{"lib", "data", "pipe"}},
+		{"name < lib", []string{"data"}},
+		{"name >= pipe", []string{"util", "pipe"}},
+		{"ext == go", []string{"util"}},
+		{`ext == ""`, []string{"lib", "pipe"}},
+		{`name =~ "^[dp]"`, []string{"data", "pipe"}},
+		{`name !~ "\\."`, []string{"lib", "pipe"}},
+		{`dir =~ "lib$"`, []string{"util"}},
+		{"path =~ util", []string{"util"}},
+		{"size > 1MB", []string{"data"}},
+		{"size == 2K", []string{"util"}},
+		{"size <= 2048", []string{"lib", "util", "pipe"}},
+		{"depth == 2", []string{"util"}},
+		{"depth < 2", []string{"lib", "data", "pipe"}},
+		{"age < 90m", []string{"lib", "pipe"}},
+		{"age > 7d", []string{"data"}},
+		{"age >= 2h && age <= 1w", []string{"util"}},
+		{"isdir || size > 1MB", []string{"lib", "data"}},
+		{"isfile && size > 1K || isdir", []string{"lib", "util", "data"}},
+		{"isfile && (size > 1MB || isdir)", []string{"data"}},
+		{"!(isdir || isfile)", []string{"pipe"}},
+		{"!!isdir", []string{"lib"}},
+		{"isfile && !ext == go", []string{"data"}},
+	}
+	for _, tt := range tests {
+		pred, err := parseWhere(tt.expr)
+		if err != nil {
+			t.Errorf("parseWhere(%q): %v", tt.expr, err)
+			continue
+		}
+		var got []string
+		for _, file := range files {
+			f := &whereFile{path: filepath.Join(root, file.path), info: file.info, root: root, now: now}
+			if pred(f) {
+				got = append(got, file.label)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("parseWhere(%q) holds for %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestWhereIsLink(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	link := filepath.Join(dir, "link")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(file, link); err != nil {
+		t.Skipf("cannot create a symbolic link: %v", err)
+	}
+
+	pred, err := parseWhere("islink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{file: false, link: true} {
+		// As for the targets, the link is followed for its info
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pred(&whereFile{path: path, info: info, root: dir, now: time.Now()}); got != want {
+			t.Errorf("islink for %s = %v, want %v", filepath.Base(path), got, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/truemilk/executor/executor"
+)
+
+// runWindow is the time of day during which tasks are started, from start
+// up to end in minutes after midnight. It wraps around midnight when end
+// comes before start.
+type runWindow struct {
+	start, end int
+}
+
+// parseRunWindow parses a window such as "22:00-06:00" in local time.
+func parseRunWindow(s string) (runWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return runWindow{}, fmt.Errorf("%q is not of the form HH:MM-HH:MM", s)
+	}
+	var w runWindow
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return runWindow{}, err
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return runWindow{}, err
+	}
+	if w.start == w.end {
+		return runWindow{}, fmt.Errorf("%q is empty", s)
+	}
+	return w, nil
+}
+
+// parseClock parses a time of day such as "06:00" into minutes after
+// midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether tasks may start at t.
+func (w runWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// next returns the first time after t at which the window opens or closes.
+func (w runWindow) next(t time.Time) time.Time {
+	var next time.Time
+	for _, minute := range []int{w.start, w.end} {
+		// time.Date normalizes the minutes, and days across DST changes
+		at := time.Date(t.Year(), t.Month(), t.Day(), 0, minute, 0, 0, t.Location())
+		if !at.After(t) {
+			at = time.Date(t.Year(), t.Month(), t.Day()+1, 0, minute, 0, 0, t.Location())
+		}
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
+
+// String formats w as parseRunWindow expects it.
+func (w runWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+}
+
+// enforce holds control whenever the window is closed and releases it when
+// the window opens, until ctx is done, leaving pausing and resuming through
+// -control to the operator. It sets the current state before
+// returning so that a run started next doesn't start tasks outside of it.
+func (w runWindow) enforce(ctx context.Context, control *executor.Control) {
+	open := w.contains(time.Now())
+	if !open {
+		control.Hold()
+		logger.Info("Outside the -run-window, waiting for it to open", "window", w.String())
+	}
+
+	go func() {
+		for {
+			timer := time.NewTimer(time.Until(w.next(time.Now())))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+
+			if inside := w.contains(time.Now()); inside != open {
+				open = inside
+				if open {
+					control.Release()
+					logger.Info("The -run-window opened, starting tasks again", "window", w.String())
+				} else {
+					control.Hold()
+					logger.Info("The -run-window closed, no tasks start until it opens again", "window", w.String())
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a significant line of a YAML document.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// yamlParser parses the subset of YAML used by executor's configuration
+// files: nested block mappings and sequences, flow lists of scalars, plain
+// and quoted scalars, and comments. Scalars are kept as strings. Anchors,
+// multi-line strings and flow mappings are not supported.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML parses data into a *confMap, []any or string.
+func parseYAML(data []byte) (any, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(stripYAMLComment(raw), " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot be used for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(raw) - len(text), text: text})
+	}
+	if len(p.lines) == 0 {
+		return newConfMap(), nil
+	}
+
+	node, err := p.parseNode(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return node, nil
+}
+
+// parseNode parses the block starting at the current line, which is
+// indented by indent.
+func (p *yamlParser) parseNode(indent int) (any, error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseMapping(indent int) (*confMap, error) {
+	m := newConfMap()
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent || isYAMLItem(line.text) {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+
+		key, value, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line.num)
+		}
+		if _, dup := m.values[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.num, key)
+		}
+		p.pos++
+
+		if value != "" {
+			scalar, err := parseYAMLScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line.num, err)
+			}
+			m.set(key, scalar)
+			continue
+		}
+
+		// An empty value introduces a nested block, which may be a sequence
+		// at the same indentation as the key
+		switch {
+		case p.pos < len(p.lines) && p.lines[p.pos].indent > indent:
+			child, err := p.parseNode(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			m.set(key, child)
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text):
+			child, err := p.parseSequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			m.set(key, child)
+		default:
+			m.set(key, "")
+		}
+	}
+	return m, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) ([]any, error) {
+	var list []any
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || (line.indent == indent && !isYAMLItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+
+		item := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		switch {
+		case item == "":
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				child, err := p.parseNode(p.lines[p.pos].indent)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, child)
+			} else {
+				list = append(list, "")
+			}
+
+		case isYAMLMappingStart(item):
+			// "- key: value" starts a mapping indented like its first key
+			itemIndent := line.indent + len(line.text) - len(item)
+			p.lines[p.pos] = yamlLine{num: line.num, indent: itemIndent, text: item}
+			child, err := p.parseMapping(itemIndent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, child)
+
+		default:
+			scalar, err := parseYAMLScalar(item)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line.num, err)
+			}
+			list = append(list, scalar)
+			p.pos++
+		}
+	}
+	return list, nil
+}
+
+// isYAMLItem reports whether text is a sequence item.
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// isYAMLMappingStart reports whether a sequence item starts a mapping.
+func isYAMLMappingStart(item string) bool {
+	if strings.HasPrefix(item, "[") {
+		return false
+	}
+	_, _, ok := splitYAMLKey(item)
+	return ok
+}
+
+// splitYAMLKey splits "key: value" or "key:" at the first colon followed by a
+// space or the end of the line. Keys may be quoted.
+func splitYAMLKey(text string) (key, value string, ok bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		rest := text[end+2:]
+		if !strings.HasPrefix(rest, ":") || (len(rest) > 1 && rest[1] != ' ') {
+			return "", "", false
+		}
+		unquoted, err := parseYAMLScalar(text[:end+2])
+		if err != nil {
+			return "", "", false
+		}
+		return unquoted.(string), strings.TrimSpace(rest[1:]), true
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), i > 0
+		}
+	}
+	return "", "", false
+}
+
+// parseYAMLScalar parses a plain or quoted scalar, or a flow list of them.
+func parseYAMLScalar(s string) (any, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated list %s", s)
+		}
+		var list []any
+		for _, item := range splitFlow(s[1 : len(s)-1]) {
+			value, err := parseYAMLScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+
+	case strings.HasPrefix(s, `"`):
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s", s)
+		}
+		return unquoted, nil
+
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("invalid quoted string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+
+	case s == "~" || s == "null":
+		return "", nil
+
+	default:
+		return s, nil
+	}
+}
+
+// stripYAMLComment removes a trailing comment, which starts with a "#" at the
+// beginning of the line or after whitespace, outside of quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == '\'' && quote == '\'' && i+1 < len(line) && line[i+1] == '\'' {
+				// '' is a quote inside a single-quoted string
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// Quotes only start a string at the beginning of a value
+			if i == 0 || strings.ContainsRune(" [,:-", rune(line[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"empty", "", `{}`},
+		{"only comments", "# nothing\n---\n   # here\n", `{}`},
+		{"scalars", "a: 1\nb: two words\nc:\n", `{"a":"1" "b":"two words" "c":""}`},
+		{"null", "a: ~\nb: null\n", `{"a":"" "b":""}`},
+		{"nested", "profiles:\n  fmt:\n    cmd: gofmt -l {}\n    workers: 8\n", `{"profiles":{"fmt":{"cmd":"gofmt -l {}" "workers":"8"}}}`},
+		{"keys keep their order", "z: 1\na: 2\nm: 3\n", `{"z":"1" "a":"2" "m":"3"}`},
+		{"colon in value", "url: http://host:8080/x\n", `{"url":"http://host:8080/x"}`},
+		{"colon in key", "a:b: c\n", `{"a:b":"c"}`},
+		{"double-quoted key", `"*.go": gofmt -l {}` + "\n", `{"*.go":"gofmt -l {}"}`},
+		{"single-quoted key", "'a: b': c\n", `{"a: b":"c"}`},
+		{"double-quoted value", `a: "x # not a comment \"q\""` + "\n", `{"a":"x # not a comment \"q\""}`},
+		{"single-quoted value", "a: 'it''s # here'\n", `{"a":"it's # here"}`},
+		{"comment", "a: b # note\n# whole line\nc: d#e\n", `{"a":"b" "c":"d#e"}`},
+		{"flow list", `a: [x, "y, z", 'w']` + "\n", `{"a":["x" "y, z" "w"]}`},
+		{"empty flow list", "a: []\n", `{"a":[]}`},
+		{"block list", "a:\n  - x\n  - y # note\n", `{"a":["x" "y"]}`},
+		{"block list at key indentation", "a:\n- x\n- y\nb: z\n", `{"a":["x" "y"] "b":"z"}`},
+		{"empty item", "a:\n  -\n  - x\n", `{"a":["" "x"]}`},
+		{"mapping items", "a:\n  - name: x\n    cmd: run x\n  - name: y\n", `{"a":[{"name":"x" "cmd":"run x"} {"name":"y"}]}`},
+		{"nested list item", "a:\n  -\n    - x\n", `{"a":[["x"]]}`},
+		{"top-level list", "- a\n- b\n", `["a" "b"]`},
+		{"document marker", "---\na: b\n", `{"a":"b"}`},
+		{"windows line ends", "a: b\r\nc: d\r\n", `{"a":"b" "c":"d"}`},
+	}
+	for _, tt := range tests {
+		got, err := parseYAML([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: parseYAML(%q): %v", tt.name, tt.in, err)
+			continue
+		}
+		if dump := dumpConf(got); dump != tt.want {
+			t.Errorf("%s: parseYAML(%q) = %s, want %s", tt.name, tt.in, dump, tt.want)
+		}
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"duplicate key", "a: 1\nb: 2\na: 3\n", `line 3: duplicate key "a"`},
+		{"duplicate quoted key", "a: 1\n\"a\": 2\n", `line 2: duplicate key "a"`},
+		{"not a mapping", "a: 1\njust text\n", `line 2: expected "key: value"`},
+		{"tab indentation", "a:\n\tb: c\n", "line 2: tabs cannot be used for indentation"},
+		{"deeper indentation", "a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"item in mapping", "a: 1\n- b\n", "line 2: unexpected indentation"},
+		{"dedent below the root", "  a: 1\nb: 2\n", "line 2: unexpected indentation"},
+		{"unterminated list", "a: [x, y\n", "line 1: unterminated list [x, y"},
+		{"bad double quotes", `a: "x` + "\n", `line 1: invalid quoted string "x`},
+		{"bad single quotes", "a: 'x\n", "line 1: invalid quoted string 'x"},
+		{"bad item", "a:\n  - \"x\n", `line 2: invalid quoted string "x`},
+	}
+	for _, tt := range tests {
+		got, err := parseYAML([]byte(tt.in))
+		if err == nil {
+			t.Errorf("%s: parseYAML(%q) = %s, want error %q", tt.name, tt.in, dumpConf(got), tt.want)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("%s: parseYAML(%q) error = %q, want %q", tt.name, tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestStripYAMLComment(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"a: b", "a: b"},
+		{"# all", ""},
+		{"a: b # c", "a: b "},
+		{"a: b\t# c", "a: b\t"},
+		{"a: b#c", "a: b#c"},
+		{`a: "b # c"`, `a: "b # c"`},
+		{`a: "b \" # c" # d`, `a: "b \" # c" `},
+		{"a: 'b # c' # d", "a: 'b # c' "},
+		{"a: it's # c", "a: it's "},
+		{"a: 'it''s # c' # d", "a: 'it''s # c' "},
+		{"- 'b # c'", "- 'b # c'"},
+		{"a: [x, '# y'] # z", "a: [x, '# y'] "},
+	}
+	for _, tt := range tests {
+		if got := stripYAMLComment(tt.in); got != tt.want {
+			t.Errorf("stripYAMLComment(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}